@@ -1,13 +1,15 @@
 package dao
 
 import (
+	"encoding/json"
 	"music-echo/utils"
 	"time"
 )
 
 type Artists struct {
-	Id   int64  `json:"id"`
-	Name string `json:"name"`
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	ImageHash string `json:"-"`
 }
 
 type Tracks struct {
@@ -19,6 +21,60 @@ type Tracks struct {
 	Year      int64          `json:"year"`
 	Genre     []string       `json:"genre"`
 	Version   int64          `json:"version"`
+	ImageHash string         `json:"-"`
+	PlayCount int64          `json:"-"`
+}
+
+type Genre struct {
+	Id         int64    `json:"id"`
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Parent     *string  `json:"parent"`
+	Aliases    []string `json:"aliases"`
+	TrackCount int64    `json:"track_count"`
+}
+
+type TrackCredit struct {
+	IdArtist int64  `json:"id_artist"`
+	Role     string `json:"role"`
+}
+
+type TrackAudio struct {
+	TrackId   int64     `json:"track_id"`
+	Location  string    `json:"-"`
+	Format    string    `json:"format"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Image struct {
+	Hash        string    `json:"hash"`
+	Size        string    `json:"size"`
+	Location    string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Play struct {
+	Id        int64     `json:"id"`
+	IdUsers   int64     `json:"-"`
+	IdTracks  int64     `json:"id_tracks"`
+	PlayedAt  time.Time `json:"played_at"`
+	Seconds   int64     `json:"seconds"`
+	ClientId  string    `json:"client_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChartEntry struct {
+	Rank         int64     `json:"rank"`
+	PreviousRank *int64    `json:"previous_rank"`
+	Delta        *int64    `json:"delta"`
+	Score        int64     `json:"score"`
+	ComputedAt   time.Time `json:"-"`
 }
 
 type Likes struct {
@@ -33,12 +89,47 @@ type Users struct {
 	Email     string         `json:"email"`
 	Password  utils.Password `json:"-"`
 	Activated bool           `json:"activated"`
+	Banned    bool           `json:"banned"`
 	Version   int            `json:"-"`
 }
 
+// AnonymousUser is the user of a request without Authorization header
+var AnonymousUser = &Users{}
+
+func (u *Users) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// AuditEvent is an action an actor took on an entity, ActorId is 0 when the actor is gone or
+// anonymous. VersionBefore is nil for a created entity and VersionAfter for a deleted one
+type AuditEvent struct {
+	Id            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorId       int64           `json:"actor_id"`
+	Action        string          `json:"action"`
+	Entity        string          `json:"entity"`
+	EntityId      int64           `json:"entity_id"`
+	VersionBefore *int64          `json:"version_before"`
+	VersionAfter  *int64          `json:"version_after"`
+	Details       json.RawMessage `json:"details"`
+	Diff          json.RawMessage `json:"diff"`
+}
+
 type Token struct {
 	Hash   []byte
 	UserId int64
 	Expiry time.Time
 	Scope  string
 }
+
+const (
+	SuggestionTrack  = "track"
+	SuggestionArtist = "artist"
+)
+
+type Suggestion struct {
+	Type  string  `json:"type"`
+	Id    int64   `json:"id"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
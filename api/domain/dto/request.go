@@ -2,16 +2,23 @@ package dto
 
 import (
 	"music-echo/utils"
+	"time"
 )
 
 type TrackPostRequest struct {
 	Artist struct {
 		Name string `json:"name"`
 	} `json:"artist"`
-	Title    string         `validate:"required,min=1" json:"title"`
-	Duration utils.Duration `validate:"required" json:"duration"`
-	Year     int64          `validate:"required,number,min=1900,max=2024" json:"year"`
-	Genre    []string       `validate:"required" json:"genre"`
+	Title    string               `validate:"required,min=1" json:"title"`
+	Duration utils.Duration       `validate:"required" json:"duration"`
+	Year     int64                `validate:"required,number,min=1900,max=2024" json:"year"`
+	Genre    []string             `validate:"required" json:"genre"`
+	Credits  []TrackCreditRequest `validate:"omitempty,max=20,dive" json:"credits"`
+}
+
+type TrackCreditRequest struct {
+	Name string `validate:"required" json:"name"`
+	Role string `validate:"required,oneof=featured producer writer remixer" json:"role"`
 }
 
 type TrackUpdateRequest struct {
@@ -21,7 +28,7 @@ type TrackUpdateRequest struct {
 	Title    *string         `validate:"omitempty,min=1" json:"title"`
 	Duration *utils.Duration `validate:"omitempty" json:"duration"`
 	Year     *int64          `validate:"omitempty,number,min=1900,max=2024" json:"year"`
-	Genre    *[]string       `validate:"omitempty" json:"genre"`
+	Genre    *[]string       `validate:"omitempty,min=1,max=10" json:"genre"`
 }
 
 type UserRegisterActivated struct {
@@ -33,3 +40,48 @@ type UserPostRequest struct {
 	Password string `validate:"required,min=8,max=72" json:"password"`
 	Name     string `validate:"required,min=2,max=500" json:"name"`
 }
+
+type UserLoginRequest struct {
+	Email    string `validate:"required,email" json:"email"`
+	Password string `validate:"required,min=8,max=72" json:"password"`
+}
+
+type PermissionsRequest struct {
+	Permissions []string `validate:"required,min=1,dive,required" json:"permissions"`
+}
+
+// UserFilters narrow the admin user listing, Query match a part of the name or email
+type UserFilters struct {
+	Query  string
+	Status string `validate:"oneof=all activated unactivated banned"`
+}
+
+// AuditFilters narrow the audit log, zero values match everything, To is exclusive
+type AuditFilters struct {
+	Entity   string `validate:"omitempty,oneof=track artist user"`
+	EntityId int64  `validate:"min=0"`
+	ActorId  int64  `validate:"min=0"`
+	From     time.Time
+	To       time.Time
+}
+
+type TrackFilters struct {
+	Query        string
+	Title        string
+	Artist       string
+	Genre        []string
+	GenreMode    string         `validate:"oneof=any all"`
+	YearMin      int64          `validate:"omitempty,min=1900,max=2024"`
+	YearMax      int64          `validate:"omitempty,min=1900,max=2024,gtefield=YearMin"`
+	DurationMin  utils.Duration `validate:"min=0"`
+	DurationMax  utils.Duration `validate:"omitempty,min=0,gtefield=DurationMin"`
+	MinLikes     int64          `validate:"min=0"`
+	CreatedAfter time.Time
+}
+
+type PlayPostRequest struct {
+	TrackId  int64      `validate:"required,min=1" json:"track_id"`
+	PlayedAt *time.Time `validate:"omitempty" json:"played_at"`
+	Seconds  int64      `validate:"min=0,max=86400" json:"seconds"`
+	ClientId string     `validate:"required,max=64" json:"client_id"`
+}
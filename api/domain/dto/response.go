@@ -2,15 +2,18 @@ package dto
 
 import (
 	"music-echo/api/domain/dao"
+	"music-echo/utils"
+	"music-echo/utils/audiotag"
 	"time"
 )
 
 type MetadataResponse struct {
-	CurrentPage int64 `json:"current_page,omitempty"`
-	PageSize    int64 `json:"page_size,omitempty"`
-	FirstPage   int64 `json:"first_page,omitempty"`
-	LastPage    int64 `json:"last_page,omitempty"`
-	TotalRecord int64 `json:"total_record,omitempty"`
+	CurrentPage int64  `json:"current_page,omitempty"`
+	PageSize    int64  `json:"page_size,omitempty"`
+	FirstPage   int64  `json:"first_page,omitempty"`
+	LastPage    int64  `json:"last_page,omitempty"`
+	TotalRecord int64  `json:"total_record,omitempty"`
+	NextCursor  string `json:"next_cursor,omitempty"`
 }
 
 type TrackInsertResponse struct {
@@ -19,9 +22,12 @@ type TrackInsertResponse struct {
 }
 
 type TrackGetResponse struct {
-	Track  *dao.Tracks  `json:"track"`
-	Artist *dao.Artists `json:"artist"`
-	Likes  *int64       `json:"likes"`
+	Track        *dao.Tracks       `json:"track"`
+	Artist       *dao.Artists      `json:"artist"`
+	Likes        *int64            `json:"likes"`
+	Plays        int64             `json:"plays"`
+	Images       map[string]string `json:"images,omitempty"`
+	ArtistImages map[string]string `json:"artist_images,omitempty"`
 }
 
 type TrackUpdateResponse struct {
@@ -31,9 +37,63 @@ type TrackUpdateResponse struct {
 }
 
 type TrackGetAllResponse struct {
-	Track  *dao.Tracks  `json:"track"`
-	Artist *dao.Artists `json:"artist"`
-	Likes  int64        `json:"likes"`
+	Track        *dao.Tracks       `json:"track"`
+	Artist       *dao.Artists      `json:"artist"`
+	Likes        int64             `json:"likes"`
+	Plays        int64             `json:"plays"`
+	Images       map[string]string `json:"images,omitempty"`
+	ArtistImages map[string]string `json:"artist_images,omitempty"`
+}
+
+type ImportRowError struct {
+	Row     int64             `json:"row"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type ImportReport struct {
+	Mode      string           `json:"mode"`
+	DryRun    bool             `json:"dry_run"`
+	Total     int64            `json:"total"`
+	Imported  int64            `json:"imported"`
+	Failed    int64            `json:"failed"`
+	Committed bool             `json:"committed"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+type ImportJobResponse struct {
+	Id         string       `json:"id"`
+	Status     string       `json:"status"`
+	CreatedAt  time.Time    `json:"created_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Report     ImportReport `json:"report"`
+}
+
+type MetadataConflict struct {
+	Field     string `json:"field"`
+	Current   any    `json:"current"`
+	Extracted any    `json:"extracted"`
+}
+
+type TrackMetadataResponse struct {
+	Tags      *audiotag.Tags     `json:"tags"`
+	Duration  utils.Duration     `json:"duration"`
+	Proposed  *dao.Tracks        `json:"proposed"`
+	Artist    *dao.Artists       `json:"artist,omitempty"`
+	Conflicts []MetadataConflict `json:"conflicts"`
+	Warnings  []string           `json:"warnings,omitempty"`
+	Applied   bool               `json:"applied"`
+}
+
+type TrackAudioResponse struct {
+	Audio    *dao.TrackAudio        `json:"audio"`
+	Metadata *TrackMetadataResponse `json:"metadata,omitempty"`
+}
+
+type SuggestResponse struct {
+	*dao.Suggestion
+	Highlight string `json:"highlight"`
 }
 
 type UsersCreateResponse struct {
@@ -42,8 +102,92 @@ type UsersCreateResponse struct {
 	Version   int       `json:"version"`
 }
 
+type TokenResponse struct {
+	Token  string    `json:"token"`
+	Expiry time.Time `json:"expiry"`
+}
+
 type WebResponse struct {
 	Message  string           `json:"message,omitempty"`
 	Metadata MetadataResponse `json:"metadata,omitempty"`
 	Data     interface{}      `json:"data,omitempty"`
 }
+
+type ImageUploadResponse struct {
+	Hash   string            `json:"hash"`
+	Images map[string]string `json:"images"`
+}
+
+type PlayResponse struct {
+	Play      *dao.Play `json:"play"`
+	Duplicate bool      `json:"duplicate"`
+}
+
+type HistoryResponse struct {
+	Play   *dao.Play    `json:"play"`
+	Track  *dao.Tracks  `json:"track"`
+	Artist *dao.Artists `json:"artist"`
+}
+
+type ChartResponse struct {
+	Window     string               `json:"window"`
+	Genre      string               `json:"genre,omitempty"`
+	ComputedAt *time.Time           `json:"computed_at"`
+	Entries    []ChartEntryResponse `json:"entries"`
+}
+
+type ChartEntryResponse struct {
+	*dao.ChartEntry
+	Track  *dao.Tracks       `json:"track"`
+	Artist *dao.Artists      `json:"artist"`
+	Images map[string]string `json:"images,omitempty"`
+}
+
+type RecommendationResponse struct {
+	Track   *dao.Tracks       `json:"track"`
+	Artist  *dao.Artists      `json:"artist"`
+	Score   float64           `json:"score"`
+	Reasons []string          `json:"reasons"`
+	Images  map[string]string `json:"images,omitempty"`
+}
+
+type ArtistGetResponse struct {
+	Artist    *dao.Artists      `json:"artist"`
+	Followers int64             `json:"followers"`
+	Following bool              `json:"following"`
+	Images    map[string]string `json:"images,omitempty"`
+}
+
+type FollowResponse struct {
+	ArtistId  int64 `json:"artist_id"`
+	Following bool  `json:"following"`
+	Followers int64 `json:"followers"`
+}
+
+type UnlockResponse struct {
+	UserId int64  `json:"user_id"`
+	Ip     string `json:"ip,omitempty"`
+}
+
+type LikeResponse struct {
+	TrackId int64 `json:"track_id"`
+	Liked   bool  `json:"liked"`
+	Likes   int64 `json:"likes"`
+}
+
+// UserTokenResponse describe a token of a user, never its hash
+type UserTokenResponse struct {
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
+type AdminUserResponse struct {
+	User        *dao.Users          `json:"user"`
+	Tokens      []UserTokenResponse `json:"tokens"`
+	Permissions []string            `json:"permissions"`
+}
+
+type RevokeTokensResponse struct {
+	UserId  int64 `json:"user_id"`
+	Revoked int64 `json:"revoked"`
+}
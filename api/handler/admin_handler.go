@@ -0,0 +1,521 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"math"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"music-echo/utils/token"
+	"net/http"
+	"time"
+)
+
+type AdminHandler interface {
+	GetAllUsers(e echo.Context) error
+	GetUser(e echo.Context) error
+	ActivateUser(e echo.Context) error
+	DeactivateUser(e echo.Context) error
+	BanUser(e echo.Context) error
+	UnbanUser(e echo.Context) error
+	RevokeTokens(e echo.Context) error
+	ResendWelcome(e echo.Context) error
+	GrantPermissions(e echo.Context) error
+	RevokePermissions(e echo.Context) error
+	GetAuditEvents(e echo.Context) error
+}
+
+type AdminHandlerImpl struct {
+	UsersRepository       repository.UsersRepository
+	TokenRepository       repository.TokenRepository
+	PermissionsRepository repository.PermissionsRepository
+	AuditRepository       repository.AuditRepository
+	TxManager             repository.TxManager
+	Mailer                utils.Mailer
+	Validators            *validator.Validate
+}
+
+func NewAdminHandlerImpl(usersRepository repository.UsersRepository, tokenRepository repository.TokenRepository, permissionsRepository repository.PermissionsRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, mailer utils.Mailer, validators *validator.Validate) AdminHandler {
+	return AdminHandlerImpl{
+		UsersRepository:       usersRepository,
+		TokenRepository:       tokenRepository,
+		PermissionsRepository: permissionsRepository,
+		AuditRepository:       auditRepository,
+		TxManager:             txManager,
+		Mailer:                mailer,
+		Validators:            validators,
+	}
+}
+
+func (a AdminHandlerImpl) GetAllUsers(e echo.Context) error {
+	var err error
+	var filters dto.UserFilters
+	var sorting utils.Sortings
+	var paginating utils.Paginatings
+	var metadata dto.MetadataResponse
+
+	// Query Parameter
+	filters.Query = utils.ReadStrQuery(e, "q", "")
+	filters.Status = utils.ReadStrQuery(e, "status", "all")
+	err = a.Validators.Struct(filters)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+
+		ok := errors.As(err, &validationErrors)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		errorMap := make(map[string]string)
+		for i := 0; i < len(validationErrors); i++ {
+			errorMap[validationErrors[i].Field()] = getValidationMessage(validationErrors[i])
+		}
+
+		return echo.NewHTTPError(http.StatusBadRequest, errorMap)
+	}
+
+	paginating.Page, err = utils.ReadIntQuery(e, "page", 1)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	paginating.PageSize, err = utils.ReadIntQuery(e, "page_size", 20)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	err = paginating.Validate(a.Validators)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	sorting.Sorts = utils.ReadStrQuery(e, "sort", "id")
+	sorting.SafeSortLists = repository.UserSortLists
+	err = sorting.Validate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Get all users
+	users, totalRecord, err := a.UsersRepository.GetAll(e.Request().Context(), filters, sorting, paginating)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "list users", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+	if users == nil {
+		users = []*dao.Users{}
+	}
+
+	// Response
+	metadata.CurrentPage = paginating.Page
+	metadata.PageSize = paginating.PageSize
+	metadata.FirstPage = 1
+	metadata.LastPage = int64(math.Ceil(float64(totalRecord) / float64(paginating.PageSize)))
+	metadata.TotalRecord = totalRecord
+
+	response := dto.WebResponse{
+		Message:  fmt.Sprintf("Query:%s Status:%s Page:%d PageSize:%d Sort:%s", filters.Query, filters.Status, paginating.Page, paginating.PageSize, sorting.Sorts),
+		Metadata: metadata,
+		Data:     users,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a AdminHandlerImpl) GetUser(e echo.Context) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	user, err := a.UsersRepository.GetById(e.Request().Context(), id)
+	if err != nil {
+		return userNotFoundOr(e, id, err)
+	}
+
+	tokens, err := a.TokenRepository.GetAllForUser(e.Request().Context(), id)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get user tokens", "user_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	permissions, err := a.PermissionsRepository.GetAllForUser(e.Request().Context(), id)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get user permissions", "user_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	userResponse := dto.AdminUserResponse{
+		User:        user,
+		Tokens:      make([]dto.UserTokenResponse, len(tokens)),
+		Permissions: append([]string{}, permissions...),
+	}
+	for i, t := range tokens {
+		userResponse.Tokens[i] = dto.UserTokenResponse{Scope: t.Scope, Expiry: t.Expiry}
+	}
+
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("get user %d", id),
+		Data:    userResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a AdminHandlerImpl) ActivateUser(e echo.Context) error {
+	// The activation token the user didn't use is no longer needed
+	return a.changeUser(e, AuditUserActivate, func(ctx context.Context, user *dao.Users) error {
+		user.Activated = true
+		return a.TokenRepository.Delete(ctx, user.Id, token.ScopeActivation)
+	})
+}
+
+func (a AdminHandlerImpl) DeactivateUser(e echo.Context) error {
+	return a.changeUser(e, AuditUserDeactivate, func(ctx context.Context, user *dao.Users) error {
+		user.Activated = false
+		return nil
+	})
+}
+
+func (a AdminHandlerImpl) BanUser(e echo.Context) error {
+	// A banned user is signed out everywhere
+	return a.changeUser(e, AuditUserBan, func(ctx context.Context, user *dao.Users) error {
+		user.Banned = true
+		_, err := a.TokenRepository.DeleteAllForUser(ctx, user.Id)
+		return err
+	})
+}
+
+func (a AdminHandlerImpl) UnbanUser(e echo.Context) error {
+	return a.changeUser(e, AuditUserUnban, func(ctx context.Context, user *dao.Users) error {
+		user.Banned = false
+		return nil
+	})
+}
+
+func (a AdminHandlerImpl) RevokeTokens(e echo.Context) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	var revoked int64
+	err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		_, err := a.UsersRepository.GetById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		revoked, err = a.TokenRepository.DeleteAllForUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, a.AuditRepository, auditChange{
+			Action:   AuditUserRevokeTokens,
+			Entity:   AuditEntityUser,
+			EntityId: id,
+			Details:  map[string]any{"revoked": revoked},
+		})
+	})
+	if err != nil {
+		return userNotFoundOr(e, id, err)
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("revoke tokens of user %d", id),
+		Data: dto.RevokeTokensResponse{
+			UserId:  id,
+			Revoked: revoked,
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a AdminHandlerImpl) ResendWelcome(e echo.Context) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	// The new activation token replace the ones sent before
+	var user *dao.Users
+	var plainText string
+	err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		var err error
+		user, err = a.UsersRepository.GetById(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.Activated {
+			return echo.NewHTTPError(http.StatusConflict, "user is already activated")
+		}
+
+		err = a.TokenRepository.Delete(ctx, id, token.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		var tokens *dao.Token
+		tokens, plainText, err = token.GenerateToken(id, 3*24*time.Hour, token.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		err = a.TokenRepository.Insert(ctx, tokens)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, a.AuditRepository, auditChange{
+			Action:   AuditUserResendWelcome,
+			Entity:   AuditEntityUser,
+			EntityId: id,
+		})
+	})
+	if err != nil {
+		return userNotFoundOr(e, id, err)
+	}
+
+	// Send email
+	utils.Background(e.Request().Context(), func(ctx context.Context) {
+		data := map[string]any{
+			"Id":              user.Id,
+			"Name":            user.Name,
+			"activationToken": plainText,
+		}
+		err := a.Mailer.Send(ctx, user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			slog.ErrorContext(ctx, "resend welcome email", "user_id", user.Id, "error", err)
+		}
+	})
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("resend welcome email to user %d", id),
+		Data:    user,
+	}
+	return e.JSON(http.StatusAccepted, response)
+}
+
+func (a AdminHandlerImpl) GrantPermissions(e echo.Context) error {
+	return a.changePermissions(e, AuditUserGrantPermissions, a.PermissionsRepository.AddForUser)
+}
+
+func (a AdminHandlerImpl) RevokePermissions(e echo.Context) error {
+	return a.changePermissions(e, AuditUserRevokePermissions, a.PermissionsRepository.RemoveForUser)
+}
+
+func (a AdminHandlerImpl) GetAuditEvents(e echo.Context) error {
+	var err error
+	var filters dto.AuditFilters
+	var paginating utils.Paginatings
+	var metadata dto.MetadataResponse
+
+	// Query Parameter
+	filters.Entity = utils.ReadStrQuery(e, "entity", "")
+	filters.EntityId, err = utils.ReadIntQuery(e, "entity_id", 0)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	filters.ActorId, err = utils.ReadIntQuery(e, "actor", 0)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	filters.From, err = utils.ReadTimeQuery(e, "from", time.Time{})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filters.To, err = utils.ReadTimeQuery(e, "to", time.Time{})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = a.Validators.Struct(filters)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+
+		ok := errors.As(err, &validationErrors)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		errorMap := make(map[string]string)
+		for i := 0; i < len(validationErrors); i++ {
+			errorMap[validationErrors[i].Field()] = getValidationMessage(validationErrors[i])
+		}
+
+		return echo.NewHTTPError(http.StatusBadRequest, errorMap)
+	}
+	if !filters.From.IsZero() && !filters.To.IsZero() && !filters.To.After(filters.From) {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"To": "must be after from"})
+	}
+
+	paginating.Page, err = utils.ReadIntQuery(e, "page", 1)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	paginating.PageSize, err = utils.ReadIntQuery(e, "page_size", 20)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	err = paginating.Validate(a.Validators)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Get audit events
+	events, totalRecord, err := a.AuditRepository.GetAll(e.Request().Context(), filters, paginating)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "list audit events", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+	if events == nil {
+		events = []*dao.AuditEvent{}
+	}
+
+	// Response
+	metadata.CurrentPage = paginating.Page
+	metadata.PageSize = paginating.PageSize
+	metadata.FirstPage = 1
+	metadata.LastPage = int64(math.Ceil(float64(totalRecord) / float64(paginating.PageSize)))
+	metadata.TotalRecord = totalRecord
+
+	response := dto.WebResponse{
+		Message:  fmt.Sprintf("Entity:%s EntityId:%d Actor:%d Page:%d PageSize:%d", filters.Entity, filters.EntityId, filters.ActorId, paginating.Page, paginating.PageSize),
+		Metadata: metadata,
+		Data:     events,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// changeUser apply change to the user and save it along with its audit event
+func (a AdminHandlerImpl) changeUser(e echo.Context, action string, change func(ctx context.Context, user *dao.Users) error) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	var user *dao.Users
+	err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		var err error
+		user, err = a.UsersRepository.GetById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		before := *user
+		err = change(ctx, user)
+		if err != nil {
+			return err
+		}
+		err = a.UsersRepository.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, a.AuditRepository, auditChange{
+			Action:        action,
+			Entity:        AuditEntityUser,
+			EntityId:      id,
+			VersionBefore: auditVersion(before.Version),
+			VersionAfter:  auditVersion(user.Version),
+			Before:        &before,
+			After:         user,
+		})
+	})
+	if err != nil {
+		return userNotFoundOr(e, id, err)
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("%s %d", action, id),
+		Data:    user,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// changePermissions grant or revoke the requested permissions with save, unknown codes are refused
+func (a AdminHandlerImpl) changePermissions(e echo.Context, action string, save func(ctx context.Context, userId int64, codes ...string) error) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	// Read and validate request body
+	permissionsRequest := new(dto.PermissionsRequest)
+	err = utils.ReadJSON(e, permissionsRequest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	err = a.Validators.Struct(permissionsRequest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, map[string]string{"permissions": "is required"})
+	}
+
+	var permissions repository.Permissions
+	err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		_, err := a.UsersRepository.GetById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		known, err := a.PermissionsRepository.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, code := range permissionsRequest.Permissions {
+			if !known.Include(code) {
+				return echo.NewHTTPError(http.StatusNotAcceptable, map[string]string{"permissions": fmt.Sprintf("unknown permission %q", code)})
+			}
+		}
+
+		err = save(ctx, id, permissionsRequest.Permissions...)
+		if err != nil {
+			return err
+		}
+		permissions, err = a.PermissionsRepository.GetAllForUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, a.AuditRepository, auditChange{
+			Action:   action,
+			Entity:   AuditEntityUser,
+			EntityId: id,
+			Details:  map[string]any{"permissions": permissionsRequest.Permissions},
+		})
+	})
+	if err != nil {
+		return userNotFoundOr(e, id, err)
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("%s %d", action, id),
+		Data:    append([]string{}, permissions...),
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// userNotFoundOr turn an error of an admin action on the user id into its response
+func userNotFoundOr(e echo.Context, id int64, err error) error {
+	var httpError *echo.HTTPError
+	switch {
+	case errors.As(err, &httpError):
+		return httpError
+	case err.Error() == "record not found":
+		return echo.NewHTTPError(http.StatusNotFound, "theres no user that match an id")
+	case err.Error() == "edit conflict":
+		return echo.NewHTTPError(http.StatusConflict, "unable to update the record due to an edit conflict, please try again")
+	default:
+		slog.ErrorContext(e.Request().Context(), "admin user action", "user_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+}
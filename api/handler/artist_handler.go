@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+)
+
+type ArtistHandler interface {
+	GetArtist(e echo.Context) error
+	FollowArtist(e echo.Context) error
+	UnfollowArtist(e echo.Context) error
+	GetFeed(e echo.Context) error
+}
+
+type ArtistHandlerImpl struct {
+	ArtistRepository  repository.ArtistRepository
+	FollowsRepository repository.FollowsRepository
+}
+
+func NewArtistHandlerImpl(artistRepository repository.ArtistRepository, followsRepository repository.FollowsRepository) ArtistHandler {
+	return &ArtistHandlerImpl{
+		ArtistRepository:  artistRepository,
+		FollowsRepository: followsRepository,
+	}
+}
+
+func (a *ArtistHandlerImpl) GetArtist(e echo.Context) error {
+	var err error
+	var id int64
+	var artistGet *dao.Artists
+	var followers int64
+	var following bool
+
+	// Read ID of Artist
+	id, err = utils.ReadIdParamByName(e, "artistId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	artistGet, err = a.ArtistRepository.GetById(e.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no artist that match an id")
+	}
+
+	followers, err = a.FollowsRepository.CountFollowers(e.Request().Context(), id)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "count artist followers", "artist_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	user := middleware.ContextGetUser(e)
+	if !user.IsAnonymous() {
+		following, err = a.FollowsRepository.IsFollowing(e.Request().Context(), user.Id, id)
+		if err != nil {
+			slog.ErrorContext(e.Request().Context(), "check artist follow", "artist_id", id, "error", err)
+			return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+		}
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("get artist %d", id),
+		Data: dto.ArtistGetResponse{
+			Artist:    artistGet,
+			Followers: followers,
+			Following: following,
+			Images:    imageURLs(artistGet.ImageHash),
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a *ArtistHandlerImpl) FollowArtist(e echo.Context) error {
+	var err error
+	var id int64
+
+	// Read ID of Artist
+	id, err = utils.ReadIdParamByName(e, "artistId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	// Following twice leave a single follow
+	_, err = a.FollowsRepository.Follow(e.Request().Context(), middleware.ContextGetUser(e).Id, id)
+	if err != nil {
+		if err.Error() == "artist doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no artist that match an id")
+		}
+		slog.ErrorContext(e.Request().Context(), "follow artist", "artist_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	return a.followResponse(e, id, true, fmt.Sprintf("follow artist %d", id))
+}
+
+func (a *ArtistHandlerImpl) UnfollowArtist(e echo.Context) error {
+	var err error
+	var id int64
+
+	// Read ID of Artist
+	id, err = utils.ReadIdParamByName(e, "artistId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	_, err = a.FollowsRepository.Unfollow(e.Request().Context(), middleware.ContextGetUser(e).Id, id)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "unfollow artist", "artist_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	return a.followResponse(e, id, false, fmt.Sprintf("unfollow artist %d", id))
+}
+
+func (a *ArtistHandlerImpl) GetFeed(e echo.Context) error {
+	var err error
+	var limit int64
+	var before *dao.Tracks
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	var metadata dto.MetadataResponse
+
+	// Query Parameter
+	limit, err = utils.ReadIntQuery(e, "limit", 20)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 50")
+	}
+
+	cursor := utils.ReadStrQuery(e, "cursor", "")
+	if cursor != "" {
+		createdAt, id, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		before = &dao.Tracks{Id: id, CreatedAt: createdAt}
+	}
+
+	// Get Feed
+	tracks, artists, err = a.FollowsRepository.Feed(e.Request().Context(), middleware.ContextGetUser(e).Id, before, int(limit))
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get feed", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response, a full page may have more behind it
+	feedResponse := make([]dto.TrackGetAllResponse, len(tracks))
+	for i := 0; i < len(tracks); i++ {
+		feedResponse[i].Track = tracks[i]
+		feedResponse[i].Artist = artists[i]
+		feedResponse[i].Plays = tracks[i].PlayCount
+		feedResponse[i].Images = imageURLs(tracks[i].ImageHash)
+		feedResponse[i].ArtistImages = imageURLs(artists[i].ImageHash)
+	}
+	if len(tracks) == int(limit) {
+		last := tracks[len(tracks)-1]
+		metadata.NextCursor = utils.EncodeCursor(last.CreatedAt, last.Id)
+	}
+	metadata.PageSize = limit
+
+	response := dto.WebResponse{
+		Message:  fmt.Sprintf("feed Limit:%d", limit),
+		Metadata: metadata,
+		Data:     feedResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a *ArtistHandlerImpl) followResponse(e echo.Context, id int64, following bool, message string) error {
+	followers, err := a.FollowsRepository.CountFollowers(e.Request().Context(), id)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "count artist followers", "artist_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	response := dto.WebResponse{
+		Message: message,
+		Data: dto.FollowResponse{
+			ArtistId:  id,
+			Following: following,
+			Followers: followers,
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
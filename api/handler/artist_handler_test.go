@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"encoding/json"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// follow call FollowArtist, or UnfollowArtist, for artistId and return the status and the follow
+func follow(t *testing.T, handler ArtistHandler, artistId string, unfollow bool) (int, dto.FollowResponse) {
+	t.Helper()
+	method := http.MethodPost
+	if unfollow {
+		method = http.MethodDelete
+	}
+	e, recorder := newContext(method, "/v1/artists/"+artistId+"/follow", "")
+	e.SetParamNames("artistId")
+	e.SetParamValues(artistId)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+
+	var err error
+	if unfollow {
+		err = handler.UnfollowArtist(e)
+	} else {
+		err = handler.FollowArtist(e)
+	}
+	var response struct {
+		Data dto.FollowResponse `json:"data"`
+	}
+	status := statusOf(err, recorder)
+	if status == http.StatusOK {
+		if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return status, response.Data
+}
+
+func TestFollowArtistTwice(t *testing.T) {
+	handler := NewArtistHandlerImpl(&fakeArtists{}, newFakeFollows())
+
+	for i := 0; i < 2; i++ {
+		status, response := follow(t, handler, "3", false)
+		if status != http.StatusOK || !response.Following || response.Followers != 1 {
+			t.Fatalf("follow %d: status = %d response = %+v, want a single follow", i+1, status, response)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		status, response := follow(t, handler, "3", true)
+		if status != http.StatusOK || response.Following || response.Followers != 0 {
+			t.Fatalf("unfollow %d: status = %d response = %+v, want no follow", i+1, status, response)
+		}
+	}
+}
+
+func TestFollowArtistNotFound(t *testing.T) {
+	handler := NewArtistHandlerImpl(&fakeArtists{}, newFakeFollows())
+
+	if status, _ := follow(t, handler, "99", false); status != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", status)
+	}
+	if status, _ := follow(t, handler, "abc", false); status != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", status)
+	}
+}
+
+func TestGetFeedKeyset(t *testing.T) {
+	follows := newFakeFollows()
+	// 7 tracks newest first, the last of the first page and the first of the next were created
+	// at the same time so only the id tell them apart
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 7; i >= 1; i-- {
+		createdAt := start.Add(time.Duration(i) * time.Hour)
+		if i == 4 {
+			createdAt = start.Add(5 * time.Hour)
+		}
+		follows.feed = append(follows.feed, &dao.Tracks{Id: int64(i), IdArtist: 3, CreatedAt: createdAt})
+	}
+	handler := NewArtistHandlerImpl(&fakeArtists{}, follows)
+
+	var seen []int64
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		target := "/v1/me/feed?limit=3"
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		e, recorder := newContext(http.MethodGet, target, "")
+		withUser(e, &dao.Users{Id: 9, Activated: true})
+		if status := statusOf(handler.GetFeed(e), recorder); status != http.StatusOK {
+			t.Fatalf("page %d: status = %d, want 200", page, status)
+		}
+
+		var response struct {
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"metadata"`
+			Data []struct {
+				Track struct {
+					Id int64 `json:"id"`
+				} `json:"track"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		for _, track := range response.Data {
+			seen = append(seen, track.Track.Id)
+		}
+		cursor = response.Metadata.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	if len(seen) != 7 {
+		t.Fatalf("paged through %v, want the 7 tracks once each", seen)
+	}
+	for i, id := range seen {
+		if id != int64(7-i) {
+			t.Fatalf("paged through %v, want newest first", seen)
+		}
+	}
+}
+
+func TestGetFeedInvalidQuery(t *testing.T) {
+	handler := NewArtistHandlerImpl(&fakeArtists{}, newFakeFollows())
+
+	for _, query := range []string{"?limit=0", "?limit=51", "?cursor=not-a-cursor"} {
+		e, recorder := newContext(http.MethodGet, "/v1/me/feed"+query, "")
+		withUser(e, &dao.Users{Id: 9, Activated: true})
+		if status := statusOf(handler.GetFeed(e), recorder); status != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", query, status)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log/slog"
+	"math"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"music-echo/utils/audiotag"
+	"music-echo/utils/storage"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// audioMimeTypes are the audio formats accepted on upload
+var audioMimeTypes = []string{
+	"audio/mpeg",
+	"audio/flac",
+	"audio/ogg",
+	"audio/wav",
+	"audio/aiff",
+	"audio/aac",
+	"audio/mp4",
+	"audio/x-m4a",
+}
+
+const (
+	// streamPlayFraction is the share of a file a user must stream before it count as a play
+	streamPlayFraction = 0.5
+	// streamPlayWindow is how long the bytes of separate range requests add up to the same play
+	streamPlayWindow = 30 * time.Minute
+	// streamClientId is the client id of plays counted by the stream endpoint
+	streamClientId = "stream"
+)
+
+type AudioHandler interface {
+	UploadAudio(e echo.Context) error
+	StreamAudio(e echo.Context) error
+}
+
+type AudioHandlerImpl struct {
+	TracksRepository repository.TracksRepository
+	ArtistRepository repository.ArtistRepository
+	PlaysRepository  repository.PlaysRepository
+	GenresRepository repository.GenresRepository
+	AuditRepository  repository.AuditRepository
+	TxManager        repository.TxManager
+	BlobStore        storage.BlobStore
+	MaxAudioSize     int64
+	plays            *playTracker
+}
+
+func NewAudioHandlerImpl(tracksRepository repository.TracksRepository, artistRepository repository.ArtistRepository, playsRepository repository.PlaysRepository, genresRepository repository.GenresRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, blobStore storage.BlobStore, maxAudioSize int64) AudioHandler {
+	return &AudioHandlerImpl{
+		TracksRepository: tracksRepository,
+		ArtistRepository: artistRepository,
+		PlaysRepository:  playsRepository,
+		GenresRepository: genresRepository,
+		AuditRepository:  auditRepository,
+		TxManager:        txManager,
+		BlobStore:        blobStore,
+		MaxAudioSize:     maxAudioSize,
+		plays:            newPlayTracker(streamPlayWindow),
+	}
+}
+
+func (a *AudioHandlerImpl) UploadAudio(e echo.Context) error {
+	var err error
+	var id int64
+	var autofill bool
+	var trackGet *dao.Tracks
+	var artistGet *dao.Artists
+	var previous *dao.TrackAudio
+	var metadata *dto.TrackMetadataResponse
+	var response dto.WebResponse
+
+	// Read ID of Tracks
+	id, err = utils.ReadIdParam(e)
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	autofill, err = strconv.ParseBool(utils.ReadStrQuery(e, "autofill", "false"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "autofill must be a boolean")
+	}
+
+	trackGet, artistGet, _, err = a.TracksRepository.GetId(e.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+	}
+
+	// Read multipart file, leave some room for the multipart envelope
+	e.Request().Body = http.MaxBytesReader(e.Response(), e.Request().Body, a.MaxAudioSize+1<<20)
+	fileHeader, err := e.FormFile("audio")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("audio has maximum of %d bytes", a.MaxAudioSize))
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "audio file is required")
+	}
+	if fileHeader.Size > a.MaxAudioSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("audio has maximum of %d bytes", a.MaxAudioSize))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	defer file.Close()
+
+	// Validate content type from the bytes, never trust the client Content-Type
+	mimeType, err := mimetype.DetectReader(file)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !isAudio(mimeType) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("%s is not a supported audio format", mimeType.String()))
+	}
+
+	// Extract tags, a file we can't parse is still a valid upload
+	tags, err := audiotag.Read(file)
+	if err == nil {
+		metadata = a.proposeMetadata(e.Request().Context(), trackGet, artistGet, tags)
+	} else {
+		slog.WarnContext(e.Request().Context(), "read audio tags", "track_id", id, "error", err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// Store and checksum in one pass
+	hash := sha256.New()
+	location, size, err := a.BlobStore.Put(e.Request().Context(), io.TeeReader(file, hash))
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "store audio", "track_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not store audio")
+	}
+
+	// Record on the track, the old file is dropped once nothing point to it
+	previous, _ = a.TracksRepository.GetAudio(e.Request().Context(), id)
+
+	audio := &dao.TrackAudio{
+		TrackId:  id,
+		Location: location,
+		Format:   strings.TrimPrefix(mimeType.Extension(), "."),
+		MimeType: mimeType.String(),
+		Size:     size,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}
+	// The audio is set along with its audit event, versioned from the track read alongside
+	err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		trackGet, _, _, err := a.TracksRepository.GetId(ctx, id)
+		if err != nil {
+			return errors.New("track doesnt exist")
+		}
+		version, err := a.TracksRepository.UpdateAudio(ctx, audio)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, a.AuditRepository, auditChange{
+			Action:        AuditTrackAudio,
+			Entity:        AuditEntityTrack,
+			EntityId:      id,
+			VersionBefore: auditVersion(trackGet.Version),
+			VersionAfter:  auditVersion(version),
+			Details:       map[string]any{"format": audio.Format, "size": audio.Size, "checksum": audio.Checksum},
+		})
+	})
+	if err != nil {
+		_ = a.BlobStore.Delete(e.Request().Context(), location)
+		if err.Error() == "track doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		slog.ErrorContext(e.Request().Context(), "set track audio", "track_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	if previous != nil && previous.Location != location {
+		err = a.BlobStore.Delete(e.Request().Context(), previous.Location)
+		if err != nil {
+			slog.ErrorContext(e.Request().Context(), "delete replaced audio", "location", previous.Location, "error", err)
+		}
+	}
+
+	// Auto-fill the track from its tags, conflicts are overwritten but still reported
+	if autofill && metadata != nil {
+		err = a.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+			// Apply the tags over the track as it is now, an edit made during the upload is kept
+			trackGet, _, _, err := a.TracksRepository.GetId(ctx, id)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+			}
+			before := *trackGet
+			applyMetadata(trackGet, metadata)
+
+			err = a.TracksRepository.Update(ctx, trackGet)
+			if err != nil {
+				return err
+			}
+			metadata.Proposed = trackGet
+			return recordAudit(ctx, e, a.AuditRepository, auditChange{
+				Action:        AuditTrackUpdate,
+				Entity:        AuditEntityTrack,
+				EntityId:      id,
+				VersionBefore: auditVersion(before.Version),
+				VersionAfter:  auditVersion(trackGet.Version),
+				Before:        &before,
+				After:         trackGet,
+				Details:       map[string]any{"source": "audio_tags"},
+			})
+		})
+		if err != nil {
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				return httpError
+			}
+			slog.ErrorContext(e.Request().Context(), "apply audio tags", "track_id", id, "error", err)
+			return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+		}
+		metadata.Applied = true
+	}
+
+	// Response
+	response = dto.WebResponse{
+		Message: fmt.Sprintf("upload audio tracks %d", id),
+		Data: dto.TrackAudioResponse{
+			Audio:    audio,
+			Metadata: metadata,
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (a *AudioHandlerImpl) StreamAudio(e echo.Context) error {
+	var err error
+	var id int64
+	var audio *dao.TrackAudio
+	var blob storage.Blob
+
+	// Read ID of Tracks
+	id, err = utils.ReadIdParam(e)
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	// Get stored audio
+	audio, err = a.TracksRepository.GetAudio(e.Request().Context(), id)
+	if err != nil {
+		if err.Error() == "audio doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no audio for this track")
+		}
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	blob, err = a.BlobStore.Open(e.Request().Context(), audio.Location)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "open audio", "location", audio.Location, "error", err)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no audio for this track")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not open audio")
+	}
+	defer blob.Close()
+
+	// ServeContent handle Range, If-Range, If-None-Match, If-Modified-Since and 206 for us,
+	// as long as ETag and Content-Type are set beforehand
+	header := e.Response().Header()
+	header.Set(echo.HeaderContentType, audio.MimeType)
+	header.Set("ETag", `"`+audio.Checksum+`"`)
+	header.Set("Cache-Control", "private, max-age=0, must-revalidate")
+
+	counter := &countingReadSeeker{ReadSeeker: blob}
+	http.ServeContent(e.Response(), e.Request(), "", audio.UpdatedAt, counter)
+
+	// Count a play once enough of the file went out, across however many range requests. A listener
+	// skipping ahead disconnect right after, so the play is recorded in the background
+	user := middleware.ContextGetUser(e)
+	if a.plays.add(user.Id, id, counter.read, blob.Size()) {
+		utils.Background(e.Request().Context(), func(ctx context.Context) {
+			err := a.recordStreamPlay(ctx, user.Id, id)
+			if err != nil {
+				slog.ErrorContext(ctx, "record stream play", "track_id", id, "error", err)
+			}
+			a.plays.done(user.Id, id, err == nil)
+		})
+	}
+
+	return nil
+}
+
+// recordStreamPlay record a counted stream through the same path as a client scrobble
+func (a *AudioHandlerImpl) recordStreamPlay(ctx context.Context, userId, trackId int64) error {
+	play := &dao.Play{
+		IdUsers:  userId,
+		IdTracks: trackId,
+		PlayedAt: time.Now(),
+		ClientId: streamClientId,
+	}
+
+	// Streams are counted at streamPlayFraction of the file, credit that share of the track
+	track, _, _, err := a.TracksRepository.GetId(ctx, trackId)
+	if err == nil {
+		play.Seconds = int64(float64(track.Duration) * streamPlayFraction)
+	}
+
+	_, err = a.PlaysRepository.Insert(ctx, play)
+	return err
+}
+
+// proposeMetadata apply the extracted tags over a copy of the track, any field that
+// already hold a different value is reported as a conflict
+func (a *AudioHandlerImpl) proposeMetadata(ctx context.Context, track *dao.Tracks, artist *dao.Artists, tags *audiotag.Tags) *dto.TrackMetadataResponse {
+	proposed := *track
+	metadata := &dto.TrackMetadataResponse{
+		Tags:      tags,
+		Duration:  utils.Duration(math.Round(tags.Duration.Seconds())),
+		Proposed:  &proposed,
+		Conflicts: []dto.MetadataConflict{},
+	}
+	conflict := func(field string, current, extracted any) {
+		metadata.Conflicts = append(metadata.Conflicts, dto.MetadataConflict{Field: field, Current: current, Extracted: extracted})
+	}
+
+	if tags.Title != "" && tags.Title != track.Title {
+		conflict("title", track.Title, tags.Title)
+		proposed.Title = tags.Title
+	}
+
+	if metadata.Duration > 0 && metadata.Duration != track.Duration {
+		conflict("duration", track.Duration, metadata.Duration)
+		proposed.Duration = metadata.Duration
+	}
+
+	if tags.Year != 0 {
+		if tags.Year < 1900 || tags.Year > 2024 {
+			metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("year %d is out of range", tags.Year))
+		} else if tags.Year != track.Year {
+			conflict("year", track.Year, tags.Year)
+			proposed.Year = tags.Year
+		}
+	}
+
+	// Tag genres are free text, only the ones in the taxonomy are kept
+	if len(tags.Genre) > 0 {
+		genres, unknown, err := resolveGenres(ctx, a.GenresRepository, tags.Genre)
+		if err != nil {
+			slog.ErrorContext(ctx, "resolve tag genres", "error", err)
+		}
+		for _, genre := range unknown {
+			metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("genre %q not found", genre))
+		}
+		if len(genres) > 0 && !slices.Equal(genres, track.Genre) {
+			conflict("genre", track.Genre, genres)
+			proposed.Genre = genres
+		}
+	}
+
+	// Resolve artist by name, an unknown artist is never created implicitly
+	if tags.Artist != "" {
+		artistGet, err := a.ArtistRepository.GetByName(ctx, tags.Artist)
+		switch {
+		case err != nil:
+			metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("artist %q not found", tags.Artist))
+		case artistGet.Id != track.IdArtist:
+			conflict("artist", artist.Name, artistGet.Name)
+			proposed.IdArtist = artistGet.Id
+			metadata.Artist = artistGet
+		default:
+			metadata.Artist = artistGet
+		}
+	}
+
+	return metadata
+}
+
+// applyMetadata set on track only the fields its tags were proposed for
+func applyMetadata(track *dao.Tracks, metadata *dto.TrackMetadataResponse) {
+	for _, conflict := range metadata.Conflicts {
+		switch conflict.Field {
+		case "title":
+			track.Title = metadata.Proposed.Title
+		case "duration":
+			track.Duration = metadata.Proposed.Duration
+		case "year":
+			track.Year = metadata.Proposed.Year
+		case "genre":
+			track.Genre = metadata.Proposed.Genre
+		case "artist":
+			track.IdArtist = metadata.Proposed.IdArtist
+		}
+	}
+}
+
+func isAudio(mimeType *mimetype.MIME) bool {
+	for _, allowed := range audioMimeTypes {
+		if mimeType.Is(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// countingReadSeeker count the bytes actually read out of the blob
+type countingReadSeeker struct {
+	io.ReadSeeker
+	read int64
+}
+
+func (c *countingReadSeeker) Read(p []byte) (int, error) {
+	n, err := c.ReadSeeker.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// playSweepEvery is how often the streams of past windows are looked for
+const playSweepEvery = time.Minute
+
+// playTracker add up the bytes streamed per user and track, a play is counted once per window
+type playTracker struct {
+	lock      sync.Mutex
+	window    time.Duration
+	streams   map[[2]int64]*playStream
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type playStream struct {
+	bytes int64
+	// recording is set while the play is being recorded, counted once it was
+	recording bool
+	counted   bool
+	started   time.Time
+}
+
+func newPlayTracker(window time.Duration) *playTracker {
+	return &playTracker{
+		window:  window,
+		streams: make(map[[2]int64]*playStream),
+		now:     time.Now,
+	}
+}
+
+// add record n more bytes streamed and report whether this is the moment the play should be
+// recorded, done must then be called with the outcome
+func (p *playTracker) add(userId, trackId, n, size int64) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.now()
+	p.sweep(now)
+
+	key := [2]int64{userId, trackId}
+	stream, ok := p.streams[key]
+	if !ok || now.Sub(stream.started) > p.window {
+		stream = &playStream{started: now}
+		p.streams[key] = stream
+	}
+
+	stream.bytes += n
+	if stream.counted || stream.recording || float64(stream.bytes) < float64(size)*streamPlayFraction {
+		return false
+	}
+
+	stream.recording = true
+	return true
+}
+
+// done mark the play reported by add as counted, or not so the next request try again
+func (p *playTracker) done(userId, trackId int64, counted bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stream, ok := p.streams[[2]int64{userId, trackId}]
+	if !ok {
+		return
+	}
+	stream.recording = false
+	stream.counted = counted
+}
+
+// sweep drop the streams whose window is over, at most once per playSweepEvery
+func (p *playTracker) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < playSweepEvery {
+		return
+	}
+	p.lastSweep = now
+
+	for key, stream := range p.streams {
+		if now.Sub(stream.started) > p.window {
+			delete(p.streams, key)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime/multipart"
+	"music-echo/api/domain/dao"
+	"music-echo/utils/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memBlobStore keep blobs in memory, onPut run once a blob is stored
+type memBlobStore struct {
+	blobs map[string][]byte
+	onPut func()
+}
+
+func (m *memBlobStore) Put(_ context.Context, r io.Reader) (string, int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+	if m.blobs == nil {
+		m.blobs = make(map[string][]byte)
+	}
+	location := fmt.Sprintf("mem:%d", len(m.blobs)+1)
+	m.blobs[location] = data
+	if m.onPut != nil {
+		m.onPut()
+	}
+	return location, int64(len(data)), nil
+}
+
+func (m *memBlobStore) Open(_ context.Context, location string) (storage.Blob, error) {
+	data, ok := m.blobs[location]
+	if !ok {
+		return nil, storage.ErrBlobNotFound
+	}
+	return memBlob{bytes.NewReader(data)}, nil
+}
+
+func (m *memBlobStore) Delete(_ context.Context, location string) error {
+	if _, ok := m.blobs[location]; !ok {
+		return storage.ErrBlobNotFound
+	}
+	delete(m.blobs, location)
+	return nil
+}
+
+type memBlob struct {
+	*bytes.Reader
+}
+
+func (m memBlob) Close() error {
+	return nil
+}
+
+// taggedMP3 build an MP3 titled title, 100 silent frames at 128 kbps that last about 3 seconds
+func taggedMP3(title string) []byte {
+	value := append([]byte{0}, title...)
+	frame := binary.BigEndian.AppendUint32([]byte("TIT2"), uint32(len(value)))
+	frame = append(append(frame, 0, 0), value...)
+
+	size := len(frame)
+	file := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	file = append(file, frame...)
+	for i := 0; i < 100; i++ {
+		audio := make([]byte, 417)
+		copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+		file = append(file, audio...)
+	}
+	return file
+}
+
+// audioUpload build a multipart upload of file for the track id
+func audioUpload(t *testing.T, id, query string, file []byte) (echo.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("audio", "track.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/v1/tracks/"+id+"/audio"+query, &body)
+	request.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	e := echo.New().NewContext(request, recorder)
+	e.SetParamNames("tracksId")
+	e.SetParamValues(id)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return e, recorder
+}
+
+func TestUploadAudioAutofillKeepConcurrentEdit(t *testing.T) {
+	log := &writeLog{}
+	tracks := &fakeTracks{log: log, tracks: []*dao.Tracks{{Id: 1, IdArtist: 3, Title: "Untitled", Duration: 3, Year: 1990, Version: 1}}}
+	audit := &fakeAudit{log: log}
+	blobs := &memBlobStore{}
+	handler := NewAudioHandlerImpl(tracks, &fakeArtists{}, nil, &fakeGenres{}, audit, &fakeTxManager{}, blobs, 1<<20)
+
+	// Someone fix the year while the file is uploading
+	blobs.onPut = func() {
+		tracks.tracks[0].Year = 1997
+		tracks.tracks[0].Version++
+	}
+
+	e, recorder := audioUpload(t, "1", "?autofill=true", taggedMP3("Paranoid Android"))
+	if status := statusOf(handler.UploadAudio(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", status, recorder.Body.String())
+	}
+
+	stored := tracks.tracks[0]
+	if stored.Title != "Paranoid Android" || stored.Year != 1997 || stored.IdArtist != 3 {
+		t.Fatalf("track = %+v, want the tag title over the edited year", stored)
+	}
+	if stored.Version != 4 {
+		t.Fatalf("version = %d, want 4 after the edit, the audio and the tags", stored.Version)
+	}
+
+	event := audit.events[1]
+	if event.Action != AuditTrackUpdate || *event.VersionBefore != 3 || *event.VersionAfter != 4 {
+		t.Fatalf("audit event = %+v", event)
+	}
+	if diff := string(event.Diff); !strings.Contains(diff, `"title"`) || strings.Contains(diff, `"year"`) {
+		t.Fatalf("diff = %s, want only the title", diff)
+	}
+}
+
+func TestUploadAudioVersionReadWithinTx(t *testing.T) {
+	log := &writeLog{}
+	tx := &fakeTxManager{}
+	tracks := &fakeTracks{log: log, tracks: []*dao.Tracks{{Id: 1, Title: "Untitled", Version: 4}}}
+	audit := &fakeAudit{log: log}
+	handler := NewAudioHandlerImpl(tracks, &fakeArtists{}, nil, &fakeGenres{}, audit, tx, &memBlobStore{}, 1<<20)
+
+	e, recorder := audioUpload(t, "1", "", taggedMP3("Paranoid Android"))
+	if status := statusOf(handler.UploadAudio(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", status, recorder.Body.String())
+	}
+
+	// The read before the upload run outside, the one the event is versioned from within
+	log.writes = log.writes[1:]
+	log.assertAllInTx(t, "tracks.GetId", "tracks.UpdateAudio", "audit.Insert")
+	event := audit.events[0]
+	if event.Action != AuditTrackAudio || *event.VersionBefore != 4 || *event.VersionAfter != 5 {
+		t.Fatalf("audit event = %+v, want versions 4 -> 5", event)
+	}
+}
+
+// streamTest serve a 1000 byte audio file for track 1
+type streamTest struct {
+	handler *AudioHandlerImpl
+	plays   *fakePlays
+	audio   []byte
+}
+
+func newStreamTest() *streamTest {
+	audio := bytes.Repeat([]byte{0xAA}, 1000)
+	tracks := &fakeTracks{log: &writeLog{}, tracks: []*dao.Tracks{{Id: 1, Title: "Untitled", Duration: 200, Version: 1}}}
+	tracks.audio = map[int64]*dao.TrackAudio{1: {
+		TrackId:   1,
+		Location:  "mem:1",
+		MimeType:  "audio/mpeg",
+		Size:      int64(len(audio)),
+		Checksum:  "abc123",
+		UpdatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	plays := newFakePlays()
+	blobs := &memBlobStore{blobs: map[string][]byte{"mem:1": audio}}
+	handler := NewAudioHandlerImpl(tracks, &fakeArtists{}, plays, &fakeGenres{}, &fakeAudit{}, &fakeTxManager{}, blobs, 1<<20)
+	return &streamTest{handler: handler.(*AudioHandlerImpl), plays: plays, audio: audio}
+}
+
+// stream request the audio of track id as user 9, header are set on the request
+func (s *streamTest) stream(ctx context.Context, id string, header map[string]string) (int, *httptest.ResponseRecorder) {
+	request := httptest.NewRequestWithContext(ctx, http.MethodGet, "/v1/tracks/"+id+"/audio", nil)
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	e := echo.New().NewContext(request, recorder)
+	e.SetParamNames("tracksId")
+	e.SetParamValues(id)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return statusOf(s.handler.StreamAudio(e), recorder), recorder
+}
+
+func TestStreamAudioRecordPlayAfterDisconnect(t *testing.T) {
+	test := newStreamTest()
+
+	// A listener skipping ahead close the connection once past the threshold
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if status, _ := test.stream(ctx, "1", map[string]string{"Range": "bytes=0-599"}); status != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", status)
+	}
+
+	play := test.plays.wait(t)
+	if play.IdUsers != 9 || play.IdTracks != 1 || play.ClientId != streamClientId || play.Seconds != 100 {
+		t.Fatalf("play = %+v", play)
+	}
+}
+
+func TestPlayTrackerCountOncePerWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := newPlayTracker(30 * time.Minute)
+	tracker.now = clock.Now
+
+	if tracker.add(9, 1, 400, 1000) {
+		t.Fatal("counted below half of the file")
+	}
+	if !tracker.add(9, 1, 200, 1000) {
+		t.Fatal("not counted at half of the file")
+	}
+	// While the play is being recorded, the next requests don't record it again
+	if tracker.add(9, 1, 400, 1000) {
+		t.Fatal("counted again while recording")
+	}
+	tracker.done(9, 1, true)
+	if tracker.add(9, 1, 1000, 1000) {
+		t.Fatal("counted twice within the window")
+	}
+	// Other listeners and tracks add up on their own
+	if tracker.add(8, 1, 400, 1000) || tracker.add(9, 2, 400, 1000) {
+		t.Fatal("bytes shared across users or tracks")
+	}
+
+	clock.Advance(31 * time.Minute)
+	if !tracker.add(9, 1, 600, 1000) {
+		t.Fatal("not counted in the next window")
+	}
+}
+
+func TestPlayTrackerRetryFailedPlay(t *testing.T) {
+	tracker := newPlayTracker(30 * time.Minute)
+
+	if !tracker.add(9, 1, 600, 1000) {
+		t.Fatal("not counted at 60% of the file")
+	}
+	tracker.done(9, 1, false)
+	if !tracker.add(9, 1, 100, 1000) {
+		t.Fatal("a play that failed to record wasn't tried again")
+	}
+}
+
+func TestPlayTrackerSweepPastWindows(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := newPlayTracker(30 * time.Minute)
+	tracker.now = clock.Now
+
+	tracker.add(9, 1, 100, 1000)
+	tracker.add(9, 2, 100, 1000)
+	clock.Advance(20 * time.Minute)
+	tracker.add(9, 3, 100, 1000)
+	if len(tracker.streams) != 3 {
+		t.Fatalf("streams = %d, want 3 within their window", len(tracker.streams))
+	}
+
+	clock.Advance(11 * time.Minute)
+	tracker.add(9, 3, 100, 1000)
+	if len(tracker.streams) != 1 {
+		t.Fatalf("streams = %d, want the 2 past their window swept", len(tracker.streams))
+	}
+}
+
+func TestStreamAudio(t *testing.T) {
+	lastModified := "Mon, 01 Jan 2024 00:00:00 GMT"
+	tests := []struct {
+		name         string
+		header       map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"whole file", nil, http.StatusOK, strings.Repeat("\xAA", 1000), ""},
+		{"range", map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, strings.Repeat("\xAA", 10), "bytes 10-19/1000"},
+		{"suffix range", map[string]string{"Range": "bytes=-5"}, http.StatusPartialContent, strings.Repeat("\xAA", 5), "bytes 995-999/1000"},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=2000-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */1000"},
+		{"if-range matching etag", map[string]string{"Range": "bytes=0-9", "If-Range": `"abc123"`}, http.StatusPartialContent, strings.Repeat("\xAA", 10), "bytes 0-9/1000"},
+		{"if-range stale etag", map[string]string{"Range": "bytes=0-9", "If-Range": `"old"`}, http.StatusOK, strings.Repeat("\xAA", 1000), ""},
+		{"if-none-match", map[string]string{"If-None-Match": `"abc123"`}, http.StatusNotModified, "", ""},
+		{"if-none-match stale", map[string]string{"If-None-Match": `"old"`}, http.StatusOK, strings.Repeat("\xAA", 1000), ""},
+		{"if-modified-since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newStreamTest()
+			status, recorder := test.stream(context.Background(), "1", tt.header)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && recorder.Body.String() != tt.body {
+				t.Errorf("body = %d bytes, want %d", recorder.Body.Len(), len(tt.body))
+			}
+			if got := recorder.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := recorder.Header().Get("ETag"); got != `"abc123"` {
+				t.Errorf("ETag = %q", got)
+			}
+			if got := recorder.Header().Get("Accept-Ranges"); tt.status != http.StatusNotModified && got != "bytes" {
+				t.Errorf("Accept-Ranges = %q", got)
+			}
+		})
+	}
+}
+
+func TestStreamAudioNotFound(t *testing.T) {
+	test := newStreamTest()
+
+	// No audio was uploaded for the track
+	if status, _ := test.stream(context.Background(), "2", nil); status != http.StatusNotFound {
+		t.Fatalf("track without audio: status = %d, want 404", status)
+	}
+
+	// The track point to a blob that is gone
+	delete(test.handler.BlobStore.(*memBlobStore).blobs, "mem:1")
+	if status, _ := test.stream(context.Background(), "1", nil); status != http.StatusNotFound {
+		t.Fatalf("missing blob: status = %d, want 404", status)
+	}
+	test.plays.assertNoneRecorded(t)
+}
+
+func TestStreamAudioCountPlayOnceAcrossRanges(t *testing.T) {
+	test := newStreamTest()
+
+	// Seeking around, the play count once half the file went out
+	for _, byteRange := range []string{"bytes=0-199", "bytes=500-699"} {
+		test.stream(context.Background(), "1", map[string]string{"Range": byteRange})
+	}
+	test.plays.assertNoneRecorded(t)
+
+	test.stream(context.Background(), "1", map[string]string{"Range": "bytes=200-299"})
+	test.plays.wait(t)
+
+	// The rest of the file, and a replay, are the same play
+	test.stream(context.Background(), "1", map[string]string{"Range": "bytes=700-999"})
+	test.stream(context.Background(), "1", nil)
+	test.plays.assertNoneRecorded(t)
+}
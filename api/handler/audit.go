@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"music-echo/api/domain/dao"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils/audit"
+)
+
+// Audited entities
+const (
+	AuditEntityTrack  = "track"
+	AuditEntityArtist = "artist"
+	AuditEntityUser   = "user"
+)
+
+// Audit actions
+const (
+	AuditTrackCreate           = "track.create"
+	AuditTrackUpdate           = "track.update"
+	AuditTrackDelete           = "track.delete"
+	AuditTrackImage            = "track.image"
+	AuditTrackAudio            = "track.audio"
+	AuditArtistImage           = "artist.image"
+	AuditUserCreate            = "user.create"
+	AuditUserActivate          = "user.activate"
+	AuditUserDeactivate        = "user.deactivate"
+	AuditUserBan               = "user.ban"
+	AuditUserUnban             = "user.unban"
+	AuditUserUnlock            = "user.unlock"
+	AuditUserRevokeTokens      = "user.revoke_tokens"
+	AuditUserResendWelcome     = "user.resend_welcome"
+	AuditUserGrantPermissions  = "user.grant_permissions"
+	AuditUserRevokePermissions = "user.revoke_permissions"
+)
+
+// auditChange describe an audited action, Before and After are the entity around it, nil when
+// it was created or deleted, ActorId default to the request user of e
+type auditChange struct {
+	Action        string
+	Entity        string
+	EntityId      int64
+	ActorId       int64
+	VersionBefore *int64
+	VersionAfter  *int64
+	Before        any
+	After         any
+	Details       map[string]any
+}
+
+// recordAudit save the change along with the diff of its entity, within the transaction of ctx.
+// Every event has an actor, e may be nil when the change name it
+func recordAudit(ctx context.Context, e echo.Context, auditRepository repository.AuditRepository, change auditChange) error {
+	diff, err := audit.Diff(change.Before, change.After, "version")
+	if err != nil {
+		return err
+	}
+
+	event := &dao.AuditEvent{
+		ActorId:       change.ActorId,
+		Action:        change.Action,
+		Entity:        change.Entity,
+		EntityId:      change.EntityId,
+		VersionBefore: change.VersionBefore,
+		VersionAfter:  change.VersionAfter,
+	}
+	if event.ActorId == 0 && e != nil {
+		event.ActorId = middleware.ContextGetUser(e).Id
+	}
+	if event.ActorId == 0 {
+		return errors.New("audit event without an actor")
+	}
+
+	event.Diff, err = json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	if change.Details != nil {
+		event.Details, err = json.Marshal(change.Details)
+		if err != nil {
+			return err
+		}
+	}
+	return auditRepository.Insert(ctx, event)
+}
+
+// auditVersion is the version an audit event record
+func auditVersion[T ~int | ~int64](version T) *int64 {
+	v := int64(version)
+	return &v
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"context"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"testing"
+)
+
+func TestRecordAuditRefuseNoActor(t *testing.T) {
+	log := &writeLog{}
+	audit := &fakeAudit{log: log}
+	change := auditChange{Action: AuditTrackCreate, Entity: AuditEntityTrack, EntityId: 1}
+
+	// An anonymous request
+	e, _ := newContext(http.MethodPost, "/v1/tracks", "")
+	if err := recordAudit(context.Background(), e, audit, change); err == nil {
+		t.Fatal("event without an actor was recorded")
+	}
+	if err := recordAudit(context.Background(), nil, audit, change); err == nil {
+		t.Fatal("event without an actor nor request was recorded")
+	}
+	if len(audit.events) != 0 {
+		t.Fatalf("events = %d, want 0", len(audit.events))
+	}
+
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if err := recordAudit(context.Background(), e, audit, change); err != nil {
+		t.Fatal(err)
+	}
+	change.ActorId = 4
+	if err := recordAudit(context.Background(), nil, audit, change); err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.events) != 2 || audit.events[0].ActorId != 9 || audit.events[1].ActorId != 4 {
+		t.Fatalf("events = %+v", audit.events)
+	}
+}
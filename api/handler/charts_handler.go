@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+	"strings"
+)
+
+type ChartsHandler interface {
+	GetTrackChart(e echo.Context) error
+}
+
+type ChartsHandlerImpl struct {
+	ChartsRepository repository.ChartsRepository
+}
+
+func NewChartsHandlerImpl(chartsRepository repository.ChartsRepository) ChartsHandler {
+	return &ChartsHandlerImpl{
+		ChartsRepository: chartsRepository,
+	}
+}
+
+func (c *ChartsHandlerImpl) GetTrackChart(e echo.Context) error {
+	var err error
+	var window string
+	var genre string
+	var limit int64
+	var entries []*dao.ChartEntry
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	var response dto.WebResponse
+
+	// Query Parameter
+	window = utils.ReadStrQuery(e, "window", "week")
+	if _, ok := repository.ChartWindows[window]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "window must be one of: day week month")
+	}
+
+	genre = strings.TrimSpace(utils.ReadStrQuery(e, "genre", ""))
+
+	limit, err = utils.ReadIntQuery(e, "limit", 50)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 100")
+	}
+
+	// Get Chart
+	entries, tracks, artists, err = c.ChartsRepository.Get(e.Request().Context(), window, genre, int(limit))
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get chart", "window", window, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	chartResponse := dto.ChartResponse{
+		Window:  window,
+		Genre:   genre,
+		Entries: make([]dto.ChartEntryResponse, len(entries)),
+	}
+	for i := 0; i < len(entries); i++ {
+		chartResponse.Entries[i].ChartEntry = entries[i]
+		chartResponse.Entries[i].Track = tracks[i]
+		chartResponse.Entries[i].Artist = artists[i]
+		chartResponse.Entries[i].Images = imageURLs(tracks[i].ImageHash)
+	}
+	if len(entries) > 0 {
+		chartResponse.ComputedAt = &entries[0].ComputedAt
+	}
+
+	response = dto.WebResponse{
+		Message: fmt.Sprintf("chart Window:%s Genre:%s Limit:%d", window, genre, limit),
+		Data:    chartResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,545 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-mail/mail/v2"
+	"github.com/labstack/echo/v4"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLog record the writes of the fake repositories, and the reads a write depend on, prefixed by "tx: " when it ran within
+// fakeTxManager and "db: " otherwise. The write named failOn fail
+type writeLog struct {
+	lock   sync.Mutex
+	failOn string
+	writes []string
+}
+
+func (w *writeLog) record(ctx context.Context, write string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	where := "db: "
+	if ctx.Value(fakeTxKey{}) != nil {
+		where = "tx: "
+	}
+	w.writes = append(w.writes, where+write)
+	if write == w.failOn {
+		return errors.New(write + " failed")
+	}
+	return nil
+}
+
+// assertAllInTx check that the writes, in order, all ran within a transaction
+func (w *writeLog) assertAllInTx(t *testing.T, writes ...string) {
+	t.Helper()
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.writes) != len(writes) {
+		t.Fatalf("writes = %q, want %q", w.writes, writes)
+	}
+	for i, write := range writes {
+		if w.writes[i] != "tx: "+write {
+			t.Fatalf("writes = %q, want %q all within a transaction", w.writes, writes)
+		}
+	}
+}
+
+type fakeTxKey struct{}
+
+// fakeTxManager count transactions, a nested WithinTx join the outer one like TxManagerImpl
+type fakeTxManager struct {
+	commits   int
+	rollbacks int
+}
+
+func (f *fakeTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(fakeTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	err := fn(context.WithValue(ctx, fakeTxKey{}, f))
+	if err != nil {
+		f.rollbacks++
+		return err
+	}
+	f.commits++
+	return nil
+}
+
+// fakeUsers keep users in memory, activation tokens are looked up by plain text
+type fakeUsers struct {
+	repository.UsersRepository
+	log         *writeLog
+	users       map[int64]*dao.Users
+	activations map[string]int64
+}
+
+func newFakeUsers(log *writeLog) *fakeUsers {
+	return &fakeUsers{log: log, users: make(map[int64]*dao.Users), activations: make(map[string]int64)}
+}
+
+// add store a user with the given password, it's returned with its id
+func (f *fakeUsers) add(t *testing.T, user dao.Users, password string) *dao.Users {
+	t.Helper()
+	if err := user.Password.Set(password); err != nil {
+		t.Fatal(err)
+	}
+	user.Id = int64(len(f.users) + 1)
+	user.Version = 1
+	f.users[user.Id] = &user
+	return &user
+}
+
+func (f *fakeUsers) Insert(ctx context.Context, user *dao.Users) error {
+	if err := f.log.record(ctx, "users.Insert"); err != nil {
+		return err
+	}
+	for _, u := range f.users {
+		if u.Email == user.Email {
+			return errors.New("duplicate email")
+		}
+	}
+	user.Id = int64(len(f.users) + 1)
+	user.CreatedAt = time.Now()
+	user.Version = 1
+	stored := *user
+	f.users[user.Id] = &stored
+	return nil
+}
+
+func (f *fakeUsers) GetByEmail(_ context.Context, email string) (*dao.Users, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (f *fakeUsers) GetById(_ context.Context, id int64) (*dao.Users, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	user := *u
+	return &user, nil
+}
+
+func (f *fakeUsers) GetByToken(_ context.Context, plainText, _ string) (*dao.Users, error) {
+	id, ok := f.activations[plainText]
+	if !ok {
+		return nil, errors.New("no record")
+	}
+	user := *f.users[id]
+	return &user, nil
+}
+
+func (f *fakeUsers) Update(ctx context.Context, user *dao.Users) error {
+	if err := f.log.record(ctx, "users.Update"); err != nil {
+		return err
+	}
+	user.Version++
+	stored := *user
+	f.users[user.Id] = &stored
+	return nil
+}
+
+type fakeTokens struct {
+	repository.TokenRepository
+	log    *writeLog
+	tokens []*dao.Token
+}
+
+func (f *fakeTokens) Insert(ctx context.Context, token *dao.Token) error {
+	if err := f.log.record(ctx, "tokens.Insert"); err != nil {
+		return err
+	}
+	f.tokens = append(f.tokens, token)
+	return nil
+}
+
+func (f *fakeTokens) Delete(ctx context.Context, _ int64, _ string) error {
+	return f.log.record(ctx, "tokens.Delete")
+}
+
+type fakeAudit struct {
+	repository.AuditRepository
+	log    *writeLog
+	events []*dao.AuditEvent
+}
+
+func (f *fakeAudit) Insert(ctx context.Context, event *dao.AuditEvent) error {
+	if err := f.log.record(ctx, "audit.Insert"); err != nil {
+		return err
+	}
+	f.events = append(f.events, event)
+	return nil
+}
+
+// fakeTracks number inserted tracks from 1
+type fakeTracks struct {
+	repository.TracksRepository
+	log    *writeLog
+	tracks []*dao.Tracks
+	audio  map[int64]*dao.TrackAudio
+}
+
+func (f *fakeTracks) insert(ctx context.Context, write string, tracks *dao.Tracks) error {
+	if err := f.log.record(ctx, write); err != nil {
+		return err
+	}
+	tracks.Id = int64(len(f.tracks) + 1)
+	tracks.CreatedAt = time.Now()
+	tracks.Version = 1
+	f.tracks = append(f.tracks, tracks)
+	return nil
+}
+
+func (f *fakeTracks) Insert(ctx context.Context, tracks *dao.Tracks) error {
+	return f.insert(ctx, "tracks.Insert", tracks)
+}
+
+func (f *fakeTracks) ImportInsert(ctx context.Context, tracks *dao.Tracks) error {
+	return f.insert(ctx, "tracks.ImportInsert", tracks)
+}
+
+func (f *fakeTracks) GetId(ctx context.Context, id int64) (*dao.Tracks, *dao.Artists, *int64, error) {
+	if err := f.log.record(ctx, "tracks.GetId"); err != nil {
+		return nil, nil, nil, err
+	}
+	if id < 1 || id > int64(len(f.tracks)) {
+		return nil, nil, nil, sql.ErrNoRows
+	}
+	track := *f.tracks[id-1]
+	return &track, &dao.Artists{Id: track.IdArtist}, new(int64), nil
+}
+
+func (f *fakeTracks) Update(ctx context.Context, tracks *dao.Tracks) error {
+	if err := f.log.record(ctx, "tracks.Update"); err != nil {
+		return err
+	}
+	if tracks.Id < 1 || tracks.Id > int64(len(f.tracks)) {
+		return errors.New("track doesnt exist")
+	}
+	tracks.Version = f.tracks[tracks.Id-1].Version + 1
+	stored := *tracks
+	f.tracks[tracks.Id-1] = &stored
+	return nil
+}
+
+func (f *fakeTracks) GetAudio(_ context.Context, id int64) (*dao.TrackAudio, error) {
+	audio, ok := f.audio[id]
+	if !ok {
+		return nil, errors.New("audio doesnt exist")
+	}
+	return audio, nil
+}
+
+func (f *fakeTracks) UpdateAudio(ctx context.Context, audio *dao.TrackAudio) (int64, error) {
+	if err := f.log.record(ctx, "tracks.UpdateAudio"); err != nil {
+		return 0, err
+	}
+	if audio.TrackId < 1 || audio.TrackId > int64(len(f.tracks)) {
+		return 0, errors.New("track doesnt exist")
+	}
+	if f.audio == nil {
+		f.audio = make(map[int64]*dao.TrackAudio)
+	}
+	audio.UpdatedAt = time.Now()
+	f.audio[audio.TrackId] = audio
+	f.tracks[audio.TrackId-1].Version++
+	return f.tracks[audio.TrackId-1].Version, nil
+}
+
+func (f *fakeTracks) UpdateImage(ctx context.Context, id int64, _ string) (int64, error) {
+	if err := f.log.record(ctx, "tracks.UpdateImage"); err != nil {
+		return 0, err
+	}
+	if id < 1 || id > int64(len(f.tracks)) {
+		return 0, errors.New("track doesnt exist")
+	}
+	f.tracks[id-1].Version++
+	return f.tracks[id-1].Version, nil
+}
+
+// Export stream the tracks in insertion order with artist "Artist" and no likes
+func (f *fakeTracks) Export(_ context.Context, _ dto.TrackFilters, _ utils.Sortings, fn func(*dao.Tracks, *dao.Artists, int64) error) error {
+	for _, track := range f.tracks {
+		if err := fn(track, &dao.Artists{Id: track.IdArtist, Name: "Artist"}, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeTracks) InsertCredits(ctx context.Context, _ int64, _ []dao.TrackCredit) error {
+	return f.log.record(ctx, "tracks.InsertCredits")
+}
+
+// fakeArtists know every artist but "Nobody"
+type fakeArtists struct {
+	repository.ArtistRepository
+}
+
+func (f *fakeArtists) GetByName(_ context.Context, name string) (*dao.Artists, error) {
+	if name == "Nobody" {
+		return nil, sql.ErrNoRows
+	}
+	return &dao.Artists{Id: int64(len(name)), Name: name}, nil
+}
+
+// fakeGenres know every genre written in lower case, its slug is itself, and the aliases given
+type fakeGenres struct {
+	repository.GenresRepository
+	aliases map[string]string
+	err     error
+	calls   int
+}
+
+func (f *fakeGenres) Resolve(_ context.Context, genres []string) (map[string]string, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	slugs := make(map[string]string)
+	for _, genre := range genres {
+		if slug, ok := f.aliases[genre]; ok {
+			slugs[genre] = slug
+		} else if genre == strings.ToLower(genre) {
+			slugs[genre] = genre
+		}
+	}
+	return slugs, nil
+}
+
+// fakeLikes toggle likes of a single user in memory
+type fakeLikes struct {
+	repository.LikesRepository
+	log   *writeLog
+	liked map[int64]bool
+}
+
+func (f *fakeLikes) Toggle(ctx context.Context, _ int64, trackId int64) (bool, error) {
+	if err := f.log.record(ctx, "likes.Toggle"); err != nil {
+		return false, err
+	}
+	f.liked[trackId] = !f.liked[trackId]
+	return f.liked[trackId], nil
+}
+
+func (f *fakeLikes) CountLikes(ctx context.Context, trackId int64) (int64, error) {
+	if err := f.log.record(ctx, "likes.CountLikes"); err != nil {
+		return 0, err
+	}
+	if f.liked[trackId] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// fakeFollows keep the follows of a single user in memory, every artist exist but id 99.
+// feed is listed newest first like FollowsRepositoryImpl.Feed
+type fakeFollows struct {
+	repository.FollowsRepository
+	following map[int64]bool
+	feed      []*dao.Tracks
+}
+
+func newFakeFollows() *fakeFollows {
+	return &fakeFollows{following: make(map[int64]bool)}
+}
+
+func (f *fakeFollows) Follow(_ context.Context, _ int64, artistId int64) (bool, error) {
+	if artistId == 99 {
+		return false, errors.New("artist doesnt exist")
+	}
+	followed := !f.following[artistId]
+	f.following[artistId] = true
+	return followed, nil
+}
+
+func (f *fakeFollows) Unfollow(_ context.Context, _ int64, artistId int64) (bool, error) {
+	unfollowed := f.following[artistId]
+	delete(f.following, artistId)
+	return unfollowed, nil
+}
+
+func (f *fakeFollows) CountFollowers(_ context.Context, artistId int64) (int64, error) {
+	if f.following[artistId] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (f *fakeFollows) Feed(_ context.Context, _ int64, before *dao.Tracks, limit int) ([]*dao.Tracks, []*dao.Artists, error) {
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for _, track := range f.feed {
+		if before != nil && !track.CreatedAt.Before(before.CreatedAt) && !(track.CreatedAt.Equal(before.CreatedAt) && track.Id < before.Id) {
+			continue
+		}
+		if len(tracks) == limit {
+			break
+		}
+		tracks = append(tracks, track)
+		artists = append(artists, &dao.Artists{Id: track.IdArtist})
+	}
+	return tracks, artists, nil
+}
+
+// fakePlays hand the plays it record to the test, like a database it refuse a canceled context.
+// history is listed newest first like PlaysRepositoryImpl.History
+type fakePlays struct {
+	repository.PlaysRepository
+	played    chan *dao.Play
+	duplicate bool
+	history   []*dao.Play
+}
+
+func newFakePlays() *fakePlays {
+	return &fakePlays{played: make(chan *dao.Play, 10)}
+}
+
+func (f *fakePlays) Insert(ctx context.Context, play *dao.Play) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if f.duplicate {
+		return false, nil
+	}
+	f.played <- play
+	return true, nil
+}
+
+func (f *fakePlays) History(_ context.Context, _ int64, before *dao.Play, limit int) ([]*dao.Play, []*dao.Tracks, []*dao.Artists, error) {
+	var plays []*dao.Play
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for _, play := range f.history {
+		if before != nil && !play.PlayedAt.Before(before.PlayedAt) && !(play.PlayedAt.Equal(before.PlayedAt) && play.Id < before.Id) {
+			continue
+		}
+		if len(plays) == limit {
+			break
+		}
+		plays = append(plays, play)
+		tracks = append(tracks, &dao.Tracks{Id: play.IdTracks})
+		artists = append(artists, &dao.Artists{})
+	}
+	return plays, tracks, artists, nil
+}
+
+// wait return the next play recorded, stream plays are recorded in the background
+func (f *fakePlays) wait(t *testing.T) *dao.Play {
+	t.Helper()
+	select {
+	case play := <-f.played:
+		return play
+	case <-time.After(5 * time.Second):
+		t.Fatal("no play was recorded")
+		return nil
+	}
+}
+
+// assertNoneRecorded check that no play was recorded, waiting a moment for background inserts
+func (f *fakePlays) assertNoneRecorded(t *testing.T) {
+	t.Helper()
+	select {
+	case play := <-f.played:
+		t.Fatalf("unexpected play of track %d", play.IdTracks)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeDialer hand the messages the mailer deliver to the test instead of an SMTP server
+type fakeDialer struct {
+	sent chan *mail.Message
+}
+
+func newFakeMailer() (utils.Mailer, *fakeDialer) {
+	dialer := &fakeDialer{sent: make(chan *mail.Message, 10)}
+	return utils.Mailer{Dialer: dialer, Sender: "Spookify <no-reply@example.com>"}, dialer
+}
+
+func (f *fakeDialer) DialAndSend(messages ...*mail.Message) error {
+	for _, message := range messages {
+		f.sent <- message
+	}
+	return nil
+}
+
+// wait return the next message sent, emails are sent in the background
+func (f *fakeDialer) wait(t *testing.T) *mail.Message {
+	t.Helper()
+	select {
+	case message := <-f.sent:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+		return nil
+	}
+}
+
+// assertNoneSent check that no message was sent, waiting a moment for background sends
+func (f *fakeDialer) assertNoneSent(t *testing.T) {
+	t.Helper()
+	select {
+	case message := <-f.sent:
+		t.Fatalf("unexpected email to %v", message.GetHeader("To"))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// fakeClock is the clock of lockout guards under test
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+// withUser authenticate the request as user, like middleware.Authenticate
+func withUser(e echo.Context, user *dao.Users) echo.Context {
+	e.Set("user", user)
+	return e
+}
+
+// newContext build the context of a JSON request from 192.0.2.1
+func newContext(method, target, body string) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.RemoteAddr = "192.0.2.1:4321"
+	recorder := httptest.NewRecorder()
+	return echo.New().NewContext(request, recorder), recorder
+}
+
+// statusOf return the status a handler answered with, from its error or its response
+func statusOf(err error, recorder *httptest.ResponseRecorder) int {
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return httpError.Code
+	}
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return recorder.Code
+}
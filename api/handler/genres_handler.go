@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"net/http"
+	"strings"
+)
+
+type GenresHandler interface {
+	GetAllGenres(e echo.Context) error
+}
+
+type GenresHandlerImpl struct {
+	GenresRepository repository.GenresRepository
+}
+
+func NewGenresHandlerImpl(genresRepository repository.GenresRepository) GenresHandler {
+	return &GenresHandlerImpl{
+		GenresRepository: genresRepository,
+	}
+}
+
+func (g *GenresHandlerImpl) GetAllGenres(e echo.Context) error {
+	var err error
+	var genres []*dao.Genre
+
+	genres, err = g.GenresRepository.GetAll(e.Request().Context())
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get genres", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("get %d genres", len(genres)),
+		Data:    genres,
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// resolveGenres turn the genres of a request into their slugs without duplicates, in the
+// order given, along with the ones that don't match any genre
+func resolveGenres(ctx context.Context, genresRepository repository.GenresRepository, genres []string) ([]string, []string, error) {
+	slugs, err := genresRepository.Resolve(ctx, genres)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved, unknown := matchGenres(slugs, genres)
+	return resolved, unknown, nil
+}
+
+// matchGenres is resolveGenres with the genres already resolved into slugs, ex: by batch in an import
+func matchGenres(slugs map[string]string, genres []string) ([]string, []string) {
+	var resolved, unknown []string
+	seen := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		slug, ok := slugs[genre]
+		if !ok {
+			unknown = append(unknown, genre)
+			continue
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			resolved = append(resolved, slug)
+		}
+	}
+
+	return resolved, unknown
+}
+
+// unknownGenresError is the validation error for genres that don't resolve
+func unknownGenresError(unknown []string) error {
+	return echo.NewHTTPError(http.StatusNotAcceptable, map[string]string{
+		"Genre": fmt.Sprintf("unknown genre: %s, see /v1/genres", strings.Join(unknown, ", ")),
+	})
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveGenres(t *testing.T) {
+	genres := &fakeGenres{aliases: map[string]string{"Hip Hop": "hip-hop", "rap": "hip-hop", "Rock": "rock"}}
+
+	tests := []struct {
+		name     string
+		genres   []string
+		resolved []string
+		unknown  []string
+	}{
+		{"none", nil, nil, nil},
+		{"slugs", []string{"rock", "jazz"}, []string{"rock", "jazz"}, nil},
+		{"names and aliases", []string{"Hip Hop", "Rock"}, []string{"hip-hop", "rock"}, nil},
+		{"duplicates keep the first", []string{"rap", "rock", "Hip Hop", "Rock"}, []string{"hip-hop", "rock"}, nil},
+		{"unknown in order", []string{"Vaporwave", "rock", "Nope"}, []string{"rock"}, []string{"Vaporwave", "Nope"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, unknown, err := resolveGenres(context.Background(), genres, tt.genres)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resolved, tt.resolved) || !reflect.DeepEqual(unknown, tt.unknown) {
+				t.Fatalf("resolved = %q unknown = %q, want %q and %q", resolved, unknown, tt.resolved, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestResolveGenresError(t *testing.T) {
+	genres := &fakeGenres{err: errors.New("connection reset")}
+
+	if _, _, err := resolveGenres(context.Background(), genres, []string{"rock"}); err == nil {
+		t.Fatal("resolve failure was not returned")
+	}
+}
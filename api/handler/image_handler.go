@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"music-echo/utils/storage"
+	"music-echo/utils/thumbnail"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// imageMimeTypes are the image formats accepted on upload, all decodable by the standard library
+var imageMimeTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+}
+
+const (
+	// maxImageSize is the largest image upload in bytes
+	maxImageSize = 10 << 20
+	// imageCacheControl is safe because image URLs change whenever the content does
+	imageCacheControl = "public, max-age=31536000, immutable"
+)
+
+var imageHashRX = regexp.MustCompile("^[0-9a-f]{64}$")
+
+type ImageHandler interface {
+	UploadTrackImage(e echo.Context) error
+	UploadArtistImage(e echo.Context) error
+	GetImage(e echo.Context) error
+}
+
+type ImageHandlerImpl struct {
+	TracksRepository repository.TracksRepository
+	ArtistRepository repository.ArtistRepository
+	ImagesRepository repository.ImagesRepository
+	AuditRepository  repository.AuditRepository
+	TxManager        repository.TxManager
+	BlobStore        storage.BlobStore
+}
+
+func NewImageHandlerImpl(tracksRepository repository.TracksRepository, artistRepository repository.ArtistRepository, imagesRepository repository.ImagesRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, blobStore storage.BlobStore) ImageHandler {
+	return &ImageHandlerImpl{
+		TracksRepository: tracksRepository,
+		ArtistRepository: artistRepository,
+		ImagesRepository: imagesRepository,
+		AuditRepository:  auditRepository,
+		TxManager:        txManager,
+		BlobStore:        blobStore,
+	}
+}
+
+func (i *ImageHandlerImpl) UploadTrackImage(e echo.Context) error {
+	var err error
+	var id int64
+	var hash string
+
+	// Read ID of Tracks
+	id, err = utils.ReadIdParam(e)
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	_, _, _, err = i.TracksRepository.GetId(e.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+	}
+
+	hash, err = i.storeImage(e)
+	if err != nil {
+		return err
+	}
+
+	// The image is set along with its audit event, versioned from the track read alongside
+	err = i.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		trackGet, _, _, err := i.TracksRepository.GetId(ctx, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+		}
+		version, err := i.TracksRepository.UpdateImage(ctx, id, hash)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, i.AuditRepository, auditChange{
+			Action:        AuditTrackImage,
+			Entity:        AuditEntityTrack,
+			EntityId:      id,
+			VersionBefore: auditVersion(trackGet.Version),
+			VersionAfter:  auditVersion(version),
+			Details:       map[string]any{"image_hash": hash},
+		})
+	})
+	if err != nil {
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			return httpError
+		}
+		if err.Error() == "track doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		slog.ErrorContext(e.Request().Context(), "set track image", "track_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("upload image tracks %d", id),
+		Data:    dto.ImageUploadResponse{Hash: hash, Images: imageURLs(hash)},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (i *ImageHandlerImpl) UploadArtistImage(e echo.Context) error {
+	var err error
+	var id int64
+	var hash string
+
+	// Read ID of Artist
+	id, err = utils.ReadIdParamByName(e, "artistId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	_, err = i.ArtistRepository.GetById(e.Request().Context(), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no artist that match an id")
+	}
+
+	hash, err = i.storeImage(e)
+	if err != nil {
+		return err
+	}
+
+	// Artists have no version, the event only record the new image
+	err = i.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		err := i.ArtistRepository.UpdateImage(ctx, id, hash)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, i.AuditRepository, auditChange{
+			Action:   AuditArtistImage,
+			Entity:   AuditEntityArtist,
+			EntityId: id,
+			Details:  map[string]any{"image_hash": hash},
+		})
+	})
+	if err != nil {
+		if err.Error() == "artist doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		slog.ErrorContext(e.Request().Context(), "set artist image", "artist_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("upload image artist %d", id),
+		Data:    dto.ImageUploadResponse{Hash: hash, Images: imageURLs(hash)},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (i *ImageHandlerImpl) GetImage(e echo.Context) error {
+	var err error
+	var image *dao.Image
+	var blob storage.Blob
+
+	hash := e.Param("hash")
+	size, ok := strings.CutSuffix(e.Param("file"), ".jpg")
+	if !imageHashRX.MatchString(hash) || !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no image that match")
+	}
+
+	image, err = i.ImagesRepository.Get(e.Request().Context(), hash, size)
+	if err != nil {
+		if err.Error() == "image doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no image that match")
+		}
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	blob, err = i.BlobStore.Open(e.Request().Context(), image.Location)
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "open image", "location", image.Location, "error", err)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no image that match")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not open image")
+	}
+	defer blob.Close()
+
+	header := e.Response().Header()
+	header.Set(echo.HeaderContentType, image.ContentType)
+	header.Set("ETag", `"`+image.Hash+"-"+image.Size+`"`)
+	header.Set("Cache-Control", imageCacheControl)
+
+	http.ServeContent(e.Response(), e.Request(), "", image.CreatedAt, blob)
+	return nil
+}
+
+// storeImage validate the "image" form file, render every thumbnail size and store the
+// ones not already known, the returned content hash identify the image
+func (i *ImageHandlerImpl) storeImage(e echo.Context) (string, error) {
+	// Read multipart file, leave some room for the multipart envelope
+	e.Request().Body = http.MaxBytesReader(e.Response(), e.Request().Body, maxImageSize+1<<20)
+	fileHeader, err := e.FormFile("image")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("image has maximum of %d bytes", maxImageSize))
+		}
+		return "", echo.NewHTTPError(http.StatusBadRequest, "image file is required")
+	}
+	if fileHeader.Size > maxImageSize {
+		return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("image has maximum of %d bytes", maxImageSize))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	defer file.Close()
+
+	// Validate content type from the bytes, never trust the client Content-Type
+	mimeType, err := mimetype.DetectReader(file)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !mimetype.EqualsAny(mimeType.String(), imageMimeTypes...) {
+		return "", echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("%s is not a supported image format", mimeType.String()))
+	}
+
+	// Content hash of the original, the same upload always map to the same URLs
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	sum := sha256.New()
+	_, err = io.Copy(sum, file)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	thumbnails, err := thumbnail.Render(file)
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrTooLarge) {
+			return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return "", echo.NewHTTPError(http.StatusBadRequest, "image could not be decoded")
+	}
+
+	for _, thumb := range thumbnails {
+		err = i.storeThumbnail(e.Request().Context(), hash, thumb)
+		if err != nil {
+			slog.ErrorContext(e.Request().Context(), "store thumbnail", "hash", hash, "size", thumb.Size.Name, "error", err)
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "could not store image")
+		}
+	}
+
+	return hash, nil
+}
+
+// storeThumbnail put one rendition in the blob store unless it already exist
+func (i *ImageHandlerImpl) storeThumbnail(ctx context.Context, hash string, thumb thumbnail.Thumbnail) error {
+	_, err := i.ImagesRepository.Get(ctx, hash, thumb.Size.Name)
+	if err == nil {
+		return nil
+	}
+
+	location, _, err := i.BlobStore.Put(ctx, bytes.NewReader(thumb.Data))
+	if err != nil {
+		return err
+	}
+
+	inserted, err := i.ImagesRepository.Insert(ctx, &dao.Image{
+		Hash:        hash,
+		Size:        thumb.Size.Name,
+		Location:    location,
+		ContentType: "image/jpeg",
+		Width:       thumb.Width,
+		Height:      thumb.Height,
+	})
+	if err != nil || !inserted {
+		// Lost a race with a concurrent upload of the same image, or failed outright
+		_ = i.BlobStore.Delete(ctx, location)
+	}
+
+	return err
+}
+
+// imageURLs map each thumbnail size to its URL, nil when there is no image
+func imageURLs(hash string) map[string]string {
+	if hash == "" {
+		return nil
+	}
+
+	urls := make(map[string]string, len(thumbnail.Sizes))
+	for _, size := range thumbnail.Sizes {
+		urls[size.Name] = fmt.Sprintf("/v1/images/%s/%s.jpg", hash, size.Name)
+	}
+	return urls
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"music-echo/api/domain/dao"
+	"music-echo/api/repository"
+	"music-echo/utils/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeImages has no thumbnail stored yet
+type fakeImages struct {
+	repository.ImagesRepository
+}
+
+func (f *fakeImages) Get(context.Context, string, string) (*dao.Image, error) {
+	return nil, errors.New("record not found")
+}
+
+func (f *fakeImages) Insert(context.Context, *dao.Image) (bool, error) {
+	return true, nil
+}
+
+// imageUpload build a multipart upload of a small PNG
+func imageUpload(t *testing.T, target string) (echo.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(part, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, target, &body)
+	request.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	return echo.New().NewContext(request, recorder), recorder
+}
+
+func TestUploadTrackImageVersionReadWithinTx(t *testing.T) {
+	log := &writeLog{}
+	tx := &fakeTxManager{}
+	tracks := &fakeTracks{log: log, tracks: []*dao.Tracks{{Id: 1, Title: "Title", Version: 4}}}
+	audit := &fakeAudit{log: log}
+	handler := NewImageHandlerImpl(tracks, &fakeArtists{}, &fakeImages{}, audit, tx, storage.NewFileStore(t.TempDir()))
+
+	e, recorder := imageUpload(t, "/v1/tracks/1/image")
+	e.SetParamNames("tracksId")
+	e.SetParamValues("1")
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.UploadTrackImage(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", status, recorder.Body.String())
+	}
+
+	// The read before the upload run outside, the one the event is versioned from within
+	log.writes = log.writes[1:]
+	log.assertAllInTx(t, "tracks.GetId", "tracks.UpdateImage", "audit.Insert")
+	event := audit.events[0]
+	if *event.VersionBefore != 4 || *event.VersionAfter != 5 {
+		t.Fatalf("versions = %d -> %d, want 4 -> 5", *event.VersionBefore, *event.VersionAfter)
+	}
+}
@@ -0,0 +1,657 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log/slog"
+	"mime"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	importModeAtomic     = "atomic"
+	importModeBestEffort = "best_effort"
+
+	importStatusRunning  = "running"
+	importStatusFinished = "finished"
+	importStatusFailed   = "failed"
+
+	// importAsyncThreshold is the body size from which an import runs as an async job
+	importAsyncThreshold = 5 << 20
+	importMaxBodySize    = 256 << 20
+	// importBatchSize is how many rows are read before the genres they use are resolved
+	importBatchSize = 500
+	// importMaxErrors cap the per-row error report so a broken file can't blow up memory
+	importMaxErrors = 1000
+	// importJobTTL is how long a finished job status is kept around
+	importJobTTL = 24 * time.Hour
+)
+
+type ImportHandler interface {
+	ImportTracks(e echo.Context) error
+	GetImportJob(e echo.Context) error
+}
+
+type ImportHandlerImpl struct {
+	TracksRepository repository.TracksRepository
+	ArtistRepository repository.ArtistRepository
+	GenresRepository repository.GenresRepository
+	AuditRepository  repository.AuditRepository
+	TxManager        repository.TxManager
+	Validators       *validator.Validate
+	jobs             map[string]*importJob
+	jobsLock         sync.Mutex
+}
+
+func NewImportHandlerImpl(tracksRepository repository.TracksRepository, artistRepository repository.ArtistRepository, genresRepository repository.GenresRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, validators *validator.Validate) ImportHandler {
+	return &ImportHandlerImpl{
+		TracksRepository: tracksRepository,
+		ArtistRepository: artistRepository,
+		GenresRepository: genresRepository,
+		AuditRepository:  auditRepository,
+		TxManager:        txManager,
+		Validators:       validators,
+		jobs:             make(map[string]*importJob),
+	}
+}
+
+func (i *ImportHandlerImpl) ImportTracks(e echo.Context) error {
+	var err error
+	var format, mode string
+	var dryRun, async bool
+	var body io.Reader
+	var rows importRowReader
+	var job *importJob
+	var response dto.WebResponse
+
+	// Query Parameter
+	format, err = importFormat(e)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+	}
+
+	mode = utils.ReadStrQuery(e, "mode", importModeAtomic)
+	if mode != importModeAtomic && mode != importModeBestEffort {
+		return echo.NewHTTPError(http.StatusBadRequest, "mode must be one of: atomic best_effort")
+	}
+
+	dryRun, err = strconv.ParseBool(utils.ReadStrQuery(e, "dry_run", "false"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "dry_run must be a boolean")
+	}
+
+	async, err = strconv.ParseBool(utils.ReadStrQuery(e, "async", "false"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "async must be a boolean")
+	}
+	async = async || e.Request().ContentLength > importAsyncThreshold
+
+	body = http.MaxBytesReader(e.Response(), e.Request().Body, importMaxBodySize)
+	job = newImportJob(mode, dryRun)
+	// The request is gone by the time an async import run, its user is kept for the audit
+	actorId := middleware.ContextGetUser(e).Id
+
+	// Large import, spool the body to disk and answer before the rows are processed
+	if async {
+		var spool *os.File
+		spool, err = spoolImport(body)
+		if err != nil {
+			return importBodyError(err)
+		}
+
+		rows, err = newImportRowReader(format, spool)
+		if err != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+			return importBodyError(err)
+		}
+
+		i.registerJob(job)
+		utils.Background(e.Request().Context(), func(ctx context.Context) {
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+
+			job.finish(i.runImport(ctx, rows, job, actorId))
+		})
+
+		e.Response().Header().Set(echo.HeaderLocation, "/v1/tracks/import/"+job.id)
+		response = dto.WebResponse{
+			Message: fmt.Sprintf("import job %s accepted", job.id),
+			Data:    job.response(),
+		}
+		return e.JSON(http.StatusAccepted, response)
+	}
+
+	// Small import, stream straight from the request body
+	rows, err = newImportRowReader(format, body)
+	if err != nil {
+		return importBodyError(err)
+	}
+
+	err = i.runImport(e.Request().Context(), rows, job, actorId)
+	job.finish(err)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return importBodyError(err)
+	}
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "import tracks", "job_id", job.id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "import aborted")
+	}
+
+	jobResponse := job.response()
+	response = dto.WebResponse{
+		Message: fmt.Sprintf("Format:%s Mode:%s DryRun:%t Imported:%d Failed:%d", format, mode, dryRun, jobResponse.Report.Imported, jobResponse.Report.Failed),
+		Data:    jobResponse.Report,
+	}
+	if !jobResponse.Report.Committed && !dryRun {
+		return e.JSON(http.StatusUnprocessableEntity, response)
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+func (i *ImportHandlerImpl) GetImportJob(e echo.Context) error {
+	i.jobsLock.Lock()
+	job, ok := i.jobs[e.Param("jobId")]
+	i.jobsLock.Unlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no import job that match an id")
+	}
+
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("get import job %s", job.id),
+		Data:    job.response(),
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// errImportRolledBack end the import transaction without an error, nothing is kept of a dry run
+// or of an atomic import with a failed row
+var errImportRolledBack = errors.New("import rolled back")
+
+// importRow is a row read from an import file, err is set when the row itself is malformed
+type importRow struct {
+	line    int64
+	request *dto.TrackPostRequest
+	err     error
+}
+
+// readImportBatch read up to importBatchSize rows, fewer only at the end of the file
+func readImportBatch(rows importRowReader) ([]importRow, error) {
+	var batch []importRow
+	for len(batch) < importBatchSize {
+		request, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var badRow importRowError
+		if err != nil && !errors.As(err, &badRow) {
+			return nil, err
+		}
+		batch = append(batch, importRow{line: rows.Line(), request: request, err: err})
+	}
+	return batch, nil
+}
+
+// runImport validate and insert every row, each track audited as created by actorId, the transaction
+// is only committed when it's not a dry run and, in atomic mode, no row failed. Rows are read by
+// batch so the genres of a whole batch are resolved in one query
+func (i *ImportHandlerImpl) runImport(ctx context.Context, rows importRowReader, job *importJob, actorId int64) error {
+	err := i.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+		artists := make(map[string]int64)
+		for {
+			batch, err := readImportBatch(rows)
+			if err != nil {
+				return err
+			}
+			if len(batch) == 0 {
+				break
+			}
+
+			var genres []string
+			seen := make(map[string]bool)
+			for _, row := range batch {
+				if row.err != nil {
+					continue
+				}
+				for _, genre := range row.request.Genre {
+					if !seen[genre] {
+						seen[genre] = true
+						genres = append(genres, genre)
+					}
+				}
+			}
+			slugs, err := i.GenresRepository.Resolve(ctx, genres)
+			if err != nil {
+				return err
+			}
+
+			for _, row := range batch {
+				err = i.importRow(ctx, row, slugs, artists, job, actorId)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if job.shouldRollback() {
+			return errImportRolledBack
+		}
+		return nil
+	})
+	if errors.Is(err, errImportRolledBack) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	job.committed()
+
+	return nil
+}
+
+// importRow insert a single row, a row that can't be imported is reported on the job and only
+// errors that must abort the whole import are returned. slugs are the resolved genres of the
+// batch, artists cache the artist ids already looked up
+func (i *ImportHandlerImpl) importRow(ctx context.Context, row importRow, slugs map[string]string, artists map[string]int64, job *importJob, actorId int64) error {
+	if row.err != nil {
+		job.rowFailed(dto.ImportRowError{Row: row.line, Message: row.err.Error()})
+		return nil
+	}
+	request := row.request
+
+	// Validate with the same rules as POST /v1/tracks
+	err := i.Validators.Struct(request)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return err
+		}
+
+		errorMap := make(map[string]string)
+		for j := 0; j < len(validationErrors); j++ {
+			errorMap[validationErrors[j].Field()] = getValidationMessage(validationErrors[j])
+		}
+		job.rowFailed(dto.ImportRowError{Row: row.line, Message: "invalid track", Fields: errorMap})
+		return nil
+	}
+
+	// Get Artist ID by Name, most catalogs repeat the same artists
+	artistId, ok := artists[request.Artist.Name]
+	if !ok {
+		artist, err := i.ArtistRepository.GetByName(ctx, request.Artist.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			job.rowFailed(dto.ImportRowError{Row: row.line, Message: fmt.Sprintf("artist %q not found", request.Artist.Name)})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		artistId = artist.Id
+		artists[request.Artist.Name] = artistId
+	}
+
+	// Genres must be in the taxonomy, stored as slugs
+	genres, unknown := matchGenres(slugs, request.Genre)
+	if len(unknown) > 0 {
+		job.rowFailed(dto.ImportRowError{Row: row.line, Message: "invalid track", Fields: map[string]string{
+			"Genre": fmt.Sprintf("unknown genre: %s", strings.Join(unknown, ", ")),
+		}})
+		return nil
+	}
+
+	// Insert Track
+	tracks := &dao.Tracks{
+		IdArtist: artistId,
+		Title:    request.Title,
+		Duration: request.Duration,
+		Year:     request.Year,
+		Genre:    genres,
+	}
+	err = i.TracksRepository.ImportInsert(ctx, tracks)
+	if err != nil {
+		slog.ErrorContext(ctx, "import track", "job_id", job.id, "line", row.line, "error", err)
+		job.rowFailed(dto.ImportRowError{Row: row.line, Message: "row could not be inserted"})
+		return nil
+	}
+	err = recordAudit(ctx, nil, i.AuditRepository, auditChange{
+		Action:       AuditTrackCreate,
+		Entity:       AuditEntityTrack,
+		EntityId:     tracks.Id,
+		ActorId:      actorId,
+		VersionAfter: auditVersion(tracks.Version),
+		After:        tracks,
+		Details:      map[string]any{"source": "import", "import_job": job.id, "line": row.line},
+	})
+	if err != nil {
+		return err
+	}
+	job.rowImported()
+	return nil
+}
+
+func (i *ImportHandlerImpl) registerJob(job *importJob) {
+	i.jobsLock.Lock()
+	defer i.jobsLock.Unlock()
+
+	// Evict old finished jobs while we're here
+	for id, j := range i.jobs {
+		if j.expired() {
+			delete(i.jobs, id)
+		}
+	}
+	i.jobs[job.id] = job
+}
+
+// importBodyError refuse an import body that can't be read, 413 when it's over importMaxBodySize
+func importBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("import must not be larger than %d bytes", tooLarge.Limit))
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
+// importFormat pick csv or ndjson from the format parameter, falling back to Content-Type
+func importFormat(e echo.Context) (string, error) {
+	format := utils.ReadStrQuery(e, "format", "")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(e.Request().Header.Get(echo.HeaderContentType))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl", "application/json-lines":
+			format = "ndjson"
+		}
+	}
+
+	switch format {
+	case "csv", "ndjson":
+		return format, nil
+	default:
+		return "", errors.New("import must be text/csv or application/x-ndjson")
+	}
+}
+
+// spoolImport copy the request body to a temporary file for an async job
+func spoolImport(body io.Reader) (*os.File, error) {
+	spool, err := os.CreateTemp("", "tracks-import-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(spool, body)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+// importJob hold the progress of an import, sync imports use one too without registering it
+type importJob struct {
+	lock       sync.Mutex
+	id         string
+	status     string
+	createdAt  time.Time
+	finishedAt *time.Time
+	err        string
+	report     dto.ImportReport
+}
+
+func newImportJob(mode string, dryRun bool) *importJob {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return &importJob{
+		id:        hex.EncodeToString(id),
+		status:    importStatusRunning,
+		createdAt: time.Now(),
+		report: dto.ImportReport{
+			Mode:   mode,
+			DryRun: dryRun,
+			Errors: []dto.ImportRowError{},
+		},
+	}
+}
+
+func (j *importJob) rowImported() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.report.Total++
+	j.report.Imported++
+}
+
+func (j *importJob) rowFailed(rowError dto.ImportRowError) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.report.Total++
+	j.report.Failed++
+	if len(j.report.Errors) < importMaxErrors {
+		j.report.Errors = append(j.report.Errors, rowError)
+	}
+}
+
+func (j *importJob) shouldRollback() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.report.DryRun || (j.report.Mode == importModeAtomic && j.report.Failed > 0)
+}
+
+func (j *importJob) committed() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.report.Committed = true
+}
+
+func (j *importJob) finish(err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	now := time.Now()
+	j.finishedAt = &now
+	j.status = importStatusFinished
+	if err != nil {
+		j.status = importStatusFailed
+		j.err = err.Error()
+	}
+}
+
+func (j *importJob) expired() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.finishedAt != nil && time.Since(*j.finishedAt) > importJobTTL
+}
+
+func (j *importJob) response() dto.ImportJobResponse {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	report := j.report
+	report.Errors = append([]dto.ImportRowError{}, j.report.Errors...)
+
+	return dto.ImportJobResponse{
+		Id:         j.id,
+		Status:     j.status,
+		CreatedAt:  j.createdAt,
+		FinishedAt: j.finishedAt,
+		Error:      j.err,
+		Report:     report,
+	}
+}
+
+// importRowError is a row that can't be decoded, it's reported and the import moves on
+type importRowError string
+
+func (e importRowError) Error() string {
+	return string(e)
+}
+
+type importRowReader interface {
+	// Next return the next row, or io.EOF when there are no rows left
+	Next() (*dto.TrackPostRequest, error)
+	// Line return the 1-based line of the file the last row started on, errors are reported with it
+	Line() int64
+}
+
+func newImportRowReader(format string, r io.Reader) (importRowReader, error) {
+	if format == "csv" {
+		return newCSVRowReader(r)
+	}
+	return newNDJSONRowReader(r), nil
+}
+
+// csvRowReader read rows with a header of artist,title,duration,year,genre, genres are separated by ";"
+type csvRowReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int64
+}
+
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New("csv must start with a header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"artist", "title", "duration", "year", "genre"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing the %q column", name)
+		}
+	}
+
+	return &csvRowReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRowReader) Next() (*dto.TrackPostRequest, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			c.line = int64(parseError.StartLine)
+			return nil, importRowError(parseError.Err.Error())
+		}
+		return nil, err
+	}
+	line, _ := c.reader.FieldPos(0)
+	c.line = int64(line)
+
+	field := func(name string) string {
+		i := c.columns[name]
+		if i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	request := new(dto.TrackPostRequest)
+	request.Artist.Name = field("artist")
+	request.Title = field("title")
+
+	if s := field("duration"); s != "" {
+		request.Duration, err = utils.ParseSeconds(s)
+		if err != nil {
+			return nil, importRowError("duration " + err.Error())
+		}
+	}
+
+	if s := field("year"); s != "" {
+		request.Year, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, importRowError("year must be a number")
+		}
+	}
+
+	for _, genre := range strings.Split(field("genre"), ";") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			request.Genre = append(request.Genre, genre)
+		}
+	}
+
+	return request, nil
+}
+
+func (c *csvRowReader) Line() int64 {
+	return c.line
+}
+
+// ndjsonRowReader read one TrackPostRequest JSON object per line, blank lines are skipped
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	line    int64
+}
+
+func newNDJSONRowReader(r io.Reader) *ndjsonRowReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	return &ndjsonRowReader{scanner: scanner}
+}
+
+func (n *ndjsonRowReader) Next() (*dto.TrackPostRequest, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		request := new(dto.TrackPostRequest)
+		err := json.Unmarshal([]byte(line), request)
+		if err != nil {
+			return nil, importRowError("line contains badly-formed JSON: " + err.Error())
+		}
+		return request, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (n *ndjsonRowReader) Line() int64 {
+	return n.line
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"io"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readLines read every row and return the line each one, or its error, was reported on
+func readLines(t *testing.T, rows importRowReader) []int64 {
+	t.Helper()
+	var lines []int64
+	for {
+		_, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			return lines
+		}
+		var badRow importRowError
+		if err != nil && !errors.As(err, &badRow) {
+			t.Fatal(err)
+		}
+		lines = append(lines, rows.Line())
+	}
+}
+
+func TestImportRowReaderLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		want   []int64
+	}{
+		{
+			name:   "csv counts the header",
+			format: "csv",
+			body:   "artist,title,duration,year,genre\nA,One,60,2000,rock\nA,Two,60,abc,rock\n",
+			want:   []int64{2, 3},
+		},
+		{
+			name:   "csv quoted field over two lines",
+			format: "csv",
+			body:   "artist,title,duration,year,genre\nA,\"One\nPart\",60,2000,rock\nA,Two,60,2000,rock\n",
+			want:   []int64{2, 4},
+		},
+		{
+			name:   "ndjson counts blank lines",
+			format: "ndjson",
+			body:   "{\"title\":\"One\"}\n\n{bad\n{\"title\":\"Two\"}\n",
+			want:   []int64{1, 3, 4},
+		},
+	}
+	for _, test := range tests {
+		rows, err := newImportRowReader(test.format, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := readLines(t, rows)
+		if len(got) != len(test.want) {
+			t.Fatalf("%s: lines = %v, want %v", test.name, got, test.want)
+		}
+		for j := range got {
+			if got[j] != test.want[j] {
+				t.Fatalf("%s: lines = %v, want %v", test.name, got, test.want)
+			}
+		}
+	}
+}
+
+func TestImportBodyTooLarge(t *testing.T) {
+	body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader(strings.Repeat("x", 64))), 16)
+	_, err := spoolImport(body)
+	if err == nil {
+		t.Fatal("oversized body was spooled")
+	}
+	var httpError *echo.HTTPError
+	if !errors.As(importBodyError(err), &httpError) || httpError.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("importBodyError = %v, want 413", importBodyError(err))
+	}
+}
+
+const importBody = "artist,title,duration,year,genre\n" +
+	"Queen,Bohemian Rhapsody,354,1975,rock\n" +
+	"Queen,Unknown Genre,200,1980,Rock\n" +
+	"Queen,Radio Ga Ga,343,1984,rock;pop\n"
+
+func importTracks(t *testing.T, mode string) (int, *writeLog, *fakeTxManager, *fakeAudit) {
+	t.Helper()
+	log := &writeLog{}
+	tx := &fakeTxManager{}
+	audit := &fakeAudit{log: log}
+	handler := NewImportHandlerImpl(&fakeTracks{log: log}, &fakeArtists{}, &fakeGenres{}, audit, tx, validator.New())
+
+	e, recorder := newContext(http.MethodPost, "/v1/tracks/import?format=csv&mode="+mode, importBody)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return statusOf(handler.ImportTracks(e), recorder), log, tx, audit
+}
+
+func TestImportTracksWithinTx(t *testing.T) {
+	status, log, tx, audit := importTracks(t, importModeBestEffort)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	log.assertAllInTx(t, "tracks.ImportInsert", "audit.Insert", "tracks.ImportInsert", "audit.Insert")
+	if tx.commits != 1 || tx.rollbacks != 0 {
+		t.Fatalf("commits = %d rollbacks = %d, want 1 and 0", tx.commits, tx.rollbacks)
+	}
+	for j, line := range []string{"2", "4"} {
+		event := audit.events[j]
+		if event.Action != AuditTrackCreate || event.EntityId != int64(j+1) || event.ActorId != 9 ||
+			!strings.Contains(string(event.Details), `"line":`+line) {
+			t.Fatalf("audit event %d = %+v %s", j, event, event.Details)
+		}
+	}
+}
+
+func TestImportTracksAtomicRollback(t *testing.T) {
+	status, log, tx, _ := importTracks(t, importModeAtomic)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", status)
+	}
+	log.assertAllInTx(t, "tracks.ImportInsert", "audit.Insert", "tracks.ImportInsert", "audit.Insert")
+	if tx.commits != 0 || tx.rollbacks != 1 {
+		t.Fatalf("commits = %d rollbacks = %d, want 0 and 1", tx.commits, tx.rollbacks)
+	}
+}
+
+func TestImportTracksAbortWhenAuditFail(t *testing.T) {
+	log := &writeLog{failOn: "audit.Insert"}
+	tx := &fakeTxManager{}
+	handler := NewImportHandlerImpl(&fakeTracks{log: log}, &fakeArtists{}, &fakeGenres{}, &fakeAudit{log: log}, tx, validator.New())
+
+	e, recorder := newContext(http.MethodPost, "/v1/tracks/import?format=csv&mode="+importModeBestEffort, importBody)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.ImportTracks(e), recorder); status != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", status)
+	}
+	if tx.commits != 0 || tx.rollbacks != 1 {
+		t.Fatalf("commits = %d rollbacks = %d, want 0 and 1", tx.commits, tx.rollbacks)
+	}
+}
+
+func TestImportTracksResolveGenresOncePerBatch(t *testing.T) {
+	var body strings.Builder
+	body.WriteString("artist,title,duration,year,genre\n")
+	for j := 0; j < importBatchSize+1; j++ {
+		fmt.Fprintf(&body, "Queen,Track %d,200,1980,rock;pop\n", j)
+	}
+	genres := &fakeGenres{}
+	handler := NewImportHandlerImpl(&fakeTracks{log: &writeLog{}}, &fakeArtists{}, genres, &fakeAudit{log: &writeLog{}}, &fakeTxManager{}, validator.New())
+
+	e, recorder := newContext(http.MethodPost, "/v1/tracks/import?format=csv", body.String())
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.ImportTracks(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", status, recorder.Body)
+	}
+	if genres.calls != 2 {
+		t.Fatalf("resolved genres %d times, want once per batch", genres.calls)
+	}
+}
+
+func TestImportTracksInsertFailure(t *testing.T) {
+	log := &writeLog{failOn: "tracks.ImportInsert"}
+	handler := NewImportHandlerImpl(&fakeTracks{log: log}, &fakeArtists{}, &fakeGenres{}, &fakeAudit{log: log}, &fakeTxManager{}, validator.New())
+
+	e, recorder := newContext(http.MethodPost, "/v1/tracks/import?format=csv&mode="+importModeBestEffort, importBody)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.ImportTracks(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	// The database error is logged, the report only tell which row failed
+	if strings.Contains(recorder.Body.String(), "ImportInsert") || !strings.Contains(recorder.Body.String(), `"row":2,"message":"row could not be inserted"`) {
+		t.Fatalf("body = %s, want the stable insert error", recorder.Body)
+	}
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+	"time"
+)
+
+// playClockSkew is how far in the future a client clock may put a play
+const playClockSkew = 5 * time.Minute
+
+type PlaysHandler interface {
+	RecordPlay(e echo.Context) error
+	GetHistory(e echo.Context) error
+}
+
+type PlaysHandlerImpl struct {
+	PlaysRepository repository.PlaysRepository
+	Validators      *validator.Validate
+}
+
+func NewPlaysHandlerImpl(playsRepository repository.PlaysRepository, validators *validator.Validate) PlaysHandler {
+	return &PlaysHandlerImpl{
+		PlaysRepository: playsRepository,
+		Validators:      validators,
+	}
+}
+
+func (p *PlaysHandlerImpl) RecordPlay(e echo.Context) error {
+	var err error
+	var inserted bool
+	var response dto.WebResponse
+
+	// read and bind request body
+	playRequest := new(dto.PlayPostRequest)
+	err = utils.ReadJSON(e, playRequest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// validate request body
+	err = p.Validators.Struct(playRequest)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+
+		ok := errors.As(err, &validationErrors)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		errorMap := make(map[string]string)
+		for i := 0; i < len(validationErrors); i++ {
+			errorMap[validationErrors[i].Field()] = getValidationMessage(validationErrors[i])
+		}
+
+		return echo.NewHTTPError(http.StatusNotAcceptable, errorMap)
+	}
+
+	playedAt := time.Now()
+	if playRequest.PlayedAt != nil {
+		playedAt = *playRequest.PlayedAt
+	}
+	if playedAt.After(time.Now().Add(playClockSkew)) {
+		return echo.NewHTTPError(http.StatusNotAcceptable, map[string]string{"PlayedAt": "must not be in the future"})
+	}
+
+	// Insert play
+	play := &dao.Play{
+		IdUsers:  middleware.ContextGetUser(e).Id,
+		IdTracks: playRequest.TrackId,
+		PlayedAt: playedAt,
+		Seconds:  playRequest.Seconds,
+		ClientId: playRequest.ClientId,
+	}
+	inserted, err = p.PlaysRepository.Insert(e.Request().Context(), play)
+	if err != nil {
+		if err.Error() == "track doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+		}
+		slog.ErrorContext(e.Request().Context(), "record play", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response, a duplicate scrobble is acknowledged but not recorded again
+	if !inserted {
+		response = dto.WebResponse{
+			Message: fmt.Sprintf("duplicate play tracks %d", play.IdTracks),
+			Data:    dto.PlayResponse{Duplicate: true},
+		}
+		return e.JSON(http.StatusOK, response)
+	}
+
+	response = dto.WebResponse{
+		Message: fmt.Sprintf("record play tracks %d", play.IdTracks),
+		Data:    dto.PlayResponse{Play: play},
+	}
+	return e.JSON(http.StatusCreated, response)
+}
+
+func (p *PlaysHandlerImpl) GetHistory(e echo.Context) error {
+	var err error
+	var limit int64
+	var before *dao.Play
+	var plays []*dao.Play
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	var metadata dto.MetadataResponse
+	var response dto.WebResponse
+
+	// Query Parameter
+	limit, err = utils.ReadIntQuery(e, "limit", 20)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 50")
+	}
+
+	cursor := utils.ReadStrQuery(e, "cursor", "")
+	if cursor != "" {
+		playedAt, id, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		before = &dao.Play{Id: id, PlayedAt: playedAt}
+	}
+
+	// Get History
+	plays, tracks, artists, err = p.PlaysRepository.History(e.Request().Context(), middleware.ContextGetUser(e).Id, before, int(limit))
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "get history", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response, a full page may have more behind it
+	historyResponse := make([]dto.HistoryResponse, len(plays))
+	for i := 0; i < len(plays); i++ {
+		historyResponse[i].Play = plays[i]
+		historyResponse[i].Track = tracks[i]
+		historyResponse[i].Artist = artists[i]
+	}
+	if len(plays) == int(limit) {
+		last := plays[len(plays)-1]
+		metadata.NextCursor = utils.EncodeCursor(last.PlayedAt, last.Id)
+	}
+	metadata.PageSize = limit
+
+	response = dto.WebResponse{
+		Message:  fmt.Sprintf("history Limit:%d", limit),
+		Metadata: metadata,
+		Data:     historyResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
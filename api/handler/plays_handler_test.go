@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func recordPlay(handler PlaysHandler, body string) int {
+	e, recorder := newContext(http.MethodPost, "/v1/plays", body)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return statusOf(handler.RecordPlay(e), recorder)
+}
+
+func TestRecordPlayClockSkew(t *testing.T) {
+	tests := []struct {
+		name     string
+		playedAt time.Time
+		status   int
+	}{
+		{"now", time.Now(), http.StatusCreated},
+		{"in the past", time.Now().Add(-24 * time.Hour), http.StatusCreated},
+		{"client clock a bit ahead", time.Now().Add(playClockSkew - time.Minute), http.StatusCreated},
+		{"in the future", time.Now().Add(playClockSkew + time.Minute), http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plays := newFakePlays()
+			handler := NewPlaysHandlerImpl(plays, validator.New())
+
+			body := `{"track_id":1,"seconds":120,"client_id":"web","played_at":"` + tt.playedAt.Format(time.RFC3339) + `"}`
+			if status := recordPlay(handler, body); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.status != http.StatusCreated {
+				plays.assertNoneRecorded(t)
+			}
+		})
+	}
+}
+
+func TestRecordPlayDuplicate(t *testing.T) {
+	plays := newFakePlays()
+	plays.duplicate = true
+	handler := NewPlaysHandlerImpl(plays, validator.New())
+
+	e, recorder := newContext(http.MethodPost, "/v1/plays", `{"track_id":1,"seconds":120,"client_id":"web"}`)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.RecordPlay(e), recorder); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	var response struct {
+		Data struct {
+			Duplicate bool `json:"duplicate"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || !response.Data.Duplicate {
+		t.Fatalf("response = %s, want a duplicate", recorder.Body.String())
+	}
+}
+
+func TestGetHistoryKeyset(t *testing.T) {
+	plays := newFakePlays()
+	// 25 plays newest first, the last of the first page and the first of the next are at the same
+	// time so only the id tell them apart
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 25; i >= 1; i-- {
+		playedAt := start.Add(time.Duration(i) * time.Minute)
+		if i == 15 {
+			playedAt = start.Add(16 * time.Minute)
+		}
+		plays.history = append(plays.history, &dao.Play{Id: int64(i), IdTracks: 1, PlayedAt: playedAt})
+	}
+	handler := NewPlaysHandlerImpl(plays, validator.New())
+
+	var seen []int64
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		target := "/v1/users/me/history?limit=10"
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		e, recorder := newContext(http.MethodGet, target, "")
+		withUser(e, &dao.Users{Id: 9, Activated: true})
+		if status := statusOf(handler.GetHistory(e), recorder); status != http.StatusOK {
+			t.Fatalf("page %d: status = %d, want 200", page, status)
+		}
+
+		var response struct {
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"metadata"`
+			Data []struct {
+				Play struct {
+					Id int64 `json:"id"`
+				} `json:"play"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		for _, history := range response.Data {
+			seen = append(seen, history.Play.Id)
+		}
+		cursor = response.Metadata.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+
+	if len(seen) != 25 {
+		t.Fatalf("paged through %v, want the 25 plays once each", seen)
+	}
+	for i, id := range seen {
+		if id != int64(25-i) {
+			t.Fatalf("paged through %v, want newest first", seen)
+		}
+	}
+}
+
+func TestGetHistoryInvalidCursor(t *testing.T) {
+	handler := NewPlaysHandlerImpl(newFakePlays(), validator.New())
+
+	e, recorder := newContext(http.MethodGet, "/v1/users/me/history?cursor=not-a-cursor", "")
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(handler.GetHistory(e), recorder); status != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", status)
+	}
+}
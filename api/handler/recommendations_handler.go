@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"music-echo/utils/recommend"
+	"net/http"
+)
+
+const (
+	// recommendCandidates is how many candidates are loaded and scored per request
+	recommendCandidates = 500
+	// recommendSeeds is how many liked or played tracks make up a user profile
+	recommendSeeds = 50
+)
+
+type RecommendationsHandler interface {
+	GetSimilarTracks(e echo.Context) error
+	GetRecommendations(e echo.Context) error
+}
+
+type RecommendationsHandlerImpl struct {
+	RecommendationsRepository repository.RecommendationsRepository
+	Weights                   recommend.Weights
+}
+
+func NewRecommendationsHandlerImpl(recommendationsRepository repository.RecommendationsRepository) RecommendationsHandler {
+	return &RecommendationsHandlerImpl{
+		RecommendationsRepository: recommendationsRepository,
+		Weights:                   recommend.DefaultWeights,
+	}
+}
+
+func (r *RecommendationsHandlerImpl) GetSimilarTracks(e echo.Context) error {
+	var err error
+	var id int64
+	var limit int64
+	var seeds []recommend.Track
+
+	// Read ID of Tracks
+	id, err = utils.ReadIdParam(e)
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	limit, err = utils.ReadIntQuery(e, "limit", 10)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 50")
+	}
+
+	seeds, err = r.RecommendationsRepository.Seeds(e.Request().Context(), []int64{id})
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "load similar track seed", "track_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+	if len(seeds) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+	}
+
+	return r.recommend(e, seeds, nil, nil, int(limit), fmt.Sprintf("similar tracks %d Limit:%d", id, limit))
+}
+
+func (r *RecommendationsHandlerImpl) GetRecommendations(e echo.Context) error {
+	var err error
+	var limit int64
+	var ids []int64
+	var weights []float64
+	var liked []int64
+	var seeds []recommend.Track
+
+	limit, err = utils.ReadIntQuery(e, "limit", 20)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 50 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 50")
+	}
+
+	user := middleware.ContextGetUser(e)
+	ids, weights, err = r.RecommendationsRepository.UserSeeds(e.Request().Context(), user.Id, recommendSeeds)
+	if err == nil {
+		liked, err = r.RecommendationsRepository.Liked(e.Request().Context(), user.Id)
+	}
+	if err == nil && len(ids) > 0 {
+		seeds, err = r.RecommendationsRepository.Seeds(e.Request().Context(), ids)
+	}
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "load user seeds", "user_id", user.Id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Seeds come back in any order, line the weights up with them
+	weightOf := make(map[int64]float64, len(ids))
+	for i := range ids {
+		weightOf[ids[i]] = weights[i]
+	}
+	weights = make([]float64, len(seeds))
+	for i := range seeds {
+		weights[i] = weightOf[seeds[i].Id]
+	}
+
+	return r.recommend(e, seeds, weights, liked, int(limit), fmt.Sprintf("recommendations Limit:%d", limit))
+}
+
+// recommend score the candidates around the seeds and write the best of them, a user
+// without any seed simply get an empty list
+func (r *RecommendationsHandlerImpl) recommend(e echo.Context, seeds []recommend.Track, weights []float64, exclude []int64, limit int, message string) error {
+	var err error
+	var candidates []recommend.Candidate
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+
+	recommendationsResponse := []dto.RecommendationResponse{}
+	if len(seeds) > 0 {
+		var seedIds []int64
+		for _, seed := range seeds {
+			seedIds = append(seedIds, seed.Id)
+		}
+
+		candidates, tracks, artists, err = r.RecommendationsRepository.Candidates(e.Request().Context(), seedIds, exclude, recommendCandidates)
+		if err != nil {
+			slog.ErrorContext(e.Request().Context(), "load recommendation candidates", "error", err)
+			return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+		}
+
+		index := make(map[int64]int, len(candidates))
+		for i := range candidates {
+			index[candidates[i].Id] = i
+		}
+
+		profile := recommend.NewProfile(seeds, weights)
+		for _, scored := range r.Weights.Rank(profile, candidates, limit) {
+			i := index[scored.Id]
+			recommendationsResponse = append(recommendationsResponse, dto.RecommendationResponse{
+				Track:   tracks[i],
+				Artist:  artists[i],
+				Score:   scored.Score,
+				Reasons: scored.Reasons,
+				Images:  imageURLs(tracks[i].ImageHash),
+			})
+		}
+	}
+
+	response := dto.WebResponse{
+		Message: message,
+		Data:    recommendationsResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
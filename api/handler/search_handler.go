@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/api/repository"
+	"music-echo/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type SearchHandler interface {
+	Suggest(e echo.Context) error
+}
+
+type SearchHandlerImpl struct {
+	SearchRepository repository.SearchRepository
+}
+
+func NewSearchHandlerImpl(searchRepository repository.SearchRepository) SearchHandler {
+	return &SearchHandlerImpl{
+		SearchRepository: searchRepository,
+	}
+}
+
+func (s *SearchHandlerImpl) Suggest(e echo.Context) error {
+	var err error
+	var q string
+	var limit int64
+	var suggestions []*dao.Suggestion
+	var suggestResponse []dto.SuggestResponse
+	var response dto.WebResponse
+
+	// Query Parameter
+	q = strings.TrimSpace(utils.ReadStrQuery(e, "q", ""))
+	if len([]rune(q)) < 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "q must be at least 2 characters long")
+	}
+
+	limit, err = utils.ReadIntQuery(e, "limit", 10)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	if limit < 1 || limit > 20 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 20")
+	}
+
+	// Suggest, autocomplete should answer fast or not at all
+	ctx, cancel := context.WithTimeout(e.Request().Context(), 2*time.Second)
+	defer cancel()
+
+	suggestions, err = s.SearchRepository.Suggest(ctx, q, int(limit))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	suggestResponse = make([]dto.SuggestResponse, len(suggestions))
+	for i := 0; i < len(suggestions); i++ {
+		suggestResponse[i].Suggestion = suggestions[i]
+		suggestResponse[i].Highlight = utils.Highlight(suggestions[i].Text, q)
+	}
+
+	response = dto.WebResponse{
+		Message: fmt.Sprintf("Q:%s Limit:%d", q, limit),
+		Data:    suggestResponse,
+	}
+	return e.JSON(http.StatusOK, response)
+}
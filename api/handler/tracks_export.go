@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushEvery is how many rows are written between flushes of the export stream
+const exportFlushEvery = 200
+
+// exportFormats map the export formats to their media type
+var exportFormats = map[string]string{
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"xspf":   "application/xspf+xml",
+}
+
+// exportFormat pick the format parameter, falling back to the first known media type in Accept
+func exportFormat(e echo.Context) (string, error) {
+	format := utils.ReadStrQuery(e, "format", "")
+	if format != "" {
+		if _, ok := exportFormats[format]; !ok {
+			return "", errors.New("format must be one of: csv ndjson xspf")
+		}
+		return format, nil
+	}
+
+	for _, accept := range strings.Split(e.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		for name, contentType := range exportFormats {
+			if mediaType == contentType {
+				return name, nil
+			}
+		}
+	}
+
+	return "csv", nil
+}
+
+// trackWriter render exported tracks one at a time
+type trackWriter interface {
+	ContentType() string
+	Begin() error
+	Write(track *dao.Tracks, artist *dao.Artists, likes int64) error
+	End() error
+}
+
+func newTrackWriter(format string, w io.Writer) trackWriter {
+	switch format {
+	case "ndjson":
+		return &ndjsonTrackWriter{encoder: json.NewEncoder(w)}
+	case "xspf":
+		return &xspfTrackWriter{w: w, encoder: xml.NewEncoder(w)}
+	default:
+		return &csvTrackWriter{writer: csv.NewWriter(w)}
+	}
+}
+
+type csvTrackWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvTrackWriter) ContentType() string {
+	return exportFormats["csv"]
+}
+
+func (c *csvTrackWriter) Begin() error {
+	return c.writer.Write([]string{"id", "title", "artist", "duration", "year", "genre", "likes", "created_at"})
+}
+
+func (c *csvTrackWriter) Write(track *dao.Tracks, artist *dao.Artists, likes int64) error {
+	err := c.writer.Write([]string{
+		strconv.FormatInt(track.Id, 10),
+		track.Title,
+		artist.Name,
+		track.Duration.String(),
+		strconv.FormatInt(track.Year, 10),
+		strings.Join(track.Genre, ";"),
+		strconv.FormatInt(likes, 10),
+		track.CreatedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	// csv.Writer buffer internally, push every row so the stream keep flowing
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvTrackWriter) End() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonTrackWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonTrackWriter) ContentType() string {
+	return exportFormats["ndjson"]
+}
+
+func (n *ndjsonTrackWriter) Begin() error {
+	return nil
+}
+
+func (n *ndjsonTrackWriter) Write(track *dao.Tracks, artist *dao.Artists, likes int64) error {
+	return n.encoder.Encode(dto.TrackGetAllResponse{
+		Track:        track,
+		Artist:       artist,
+		Likes:        likes,
+		Plays:        track.PlayCount,
+		Images:       imageURLs(track.ImageHash),
+		ArtistImages: imageURLs(artist.ImageHash),
+	})
+}
+
+func (n *ndjsonTrackWriter) End() error {
+	return nil
+}
+
+// xspfTrack is a track of a XSPF playlist, see https://xspf.org/spec
+type xspfTrack struct {
+	XMLName    xml.Name `xml:"track"`
+	Identifier string   `xml:"identifier"`
+	Title      string   `xml:"title"`
+	Creator    string   `xml:"creator"`
+	Duration   int64    `xml:"duration"`
+	Annotation string   `xml:"annotation,omitempty"`
+}
+
+type xspfTrackWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+}
+
+func (x *xspfTrackWriter) ContentType() string {
+	return exportFormats["xspf"]
+}
+
+func (x *xspfTrackWriter) Begin() error {
+	_, err := io.WriteString(x.w, xml.Header+`<playlist version="1" xmlns="http://xspf.org/ns/0/"><trackList>`)
+	return err
+}
+
+func (x *xspfTrackWriter) Write(track *dao.Tracks, artist *dao.Artists, likes int64) error {
+	return x.encoder.Encode(xspfTrack{
+		Identifier: fmt.Sprintf("/v1/tracks/%d", track.Id),
+		Title:      track.Title,
+		Creator:    artist.Name,
+		Duration:   track.Duration.Milliseconds(),
+		Annotation: fmt.Sprintf("%d, %s, %d likes", track.Year, strings.Join(track.Genre, ", "), likes),
+	})
+}
+
+func (x *xspfTrackWriter) End() error {
+	_, err := io.WriteString(x.w, `</trackList></playlist>`)
+	return err
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportTracks = []struct {
+	track  *dao.Tracks
+	artist *dao.Artists
+	likes  int64
+}{
+	{
+		&dao.Tracks{Id: 1, Title: "Bohemian Rhapsody", Duration: 354, Year: 1975, Genre: []string{"rock", "progressive-rock"},
+			CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), PlayCount: 7},
+		&dao.Artists{Id: 3, Name: "Queen"},
+		42,
+	},
+	{
+		&dao.Tracks{Id: 2, Title: `Say "Hello", & Goodbye`, Duration: 61, Year: 2001,
+			CreatedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), ImageHash: "abc"},
+		&dao.Artists{Id: 4, Name: "Me <and> You"},
+		0,
+	},
+}
+
+func TestTrackWriters(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		want        string
+	}{
+		{
+			"csv",
+			"text/csv",
+			"id,title,artist,duration,year,genre,likes,created_at\n" +
+				"1,Bohemian Rhapsody,Queen,354 seconds,1975,rock;progressive-rock,42,2024-01-01T12:00:00Z\n" +
+				`2,"Say ""Hello"", & Goodbye",Me <and> You,61 seconds,2001,,0,2024-01-02T12:00:00Z` + "\n",
+		},
+		{
+			"ndjson",
+			"application/x-ndjson",
+			`{"track":{"id":1,"created_at":"2024-01-01T12:00:00Z","id_artist":0,"title":"Bohemian Rhapsody","duration":"354 seconds","year":1975,"genre":["rock","progressive-rock"],"version":0},"artist":{"id":3,"name":"Queen"},"likes":42,"plays":7}` + "\n" +
+				`{"track":{"id":2,"created_at":"2024-01-02T12:00:00Z","id_artist":0,"title":"Say \"Hello\", & Goodbye","duration":"61 seconds","year":2001,"genre":null,"version":0},"artist":{"id":4,"name":"Me <and> You"},"likes":0,"plays":0,"images":{"medium":"/v1/images/abc/medium.jpg","small":"/v1/images/abc/small.jpg","large":"/v1/images/abc/large.jpg"}}` + "\n",
+		},
+		{
+			"xspf",
+			"application/xspf+xml",
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<playlist version="1" xmlns="http://xspf.org/ns/0/"><trackList>` +
+				`<track><identifier>/v1/tracks/1</identifier><title>Bohemian Rhapsody</title><creator>Queen</creator><duration>354000</duration><annotation>1975, rock, progressive-rock, 42 likes</annotation></track>` +
+				`<track><identifier>/v1/tracks/2</identifier><title>Say &#34;Hello&#34;, &amp; Goodbye</title><creator>Me &lt;and&gt; You</creator><duration>61000</duration><annotation>2001, , 0 likes</annotation></track>` +
+				`</trackList></playlist>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := newTrackWriter(tt.format, &buf)
+			if writer.ContentType() != tt.contentType {
+				t.Fatalf("content type = %q, want %q", writer.ContentType(), tt.contentType)
+			}
+
+			if err := writer.Begin(); err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range exportTracks {
+				if err := writer.Write(row.track, row.artist, row.likes); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.End(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.format == "ndjson" {
+				assertNDJSON(t, buf.String(), tt.want)
+				return
+			}
+			if buf.String() != tt.want {
+				t.Fatalf("output =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+// assertNDJSON compare line by line, ignoring the order of the image sizes
+func assertNDJSON(t *testing.T, got, want string) {
+	t.Helper()
+	gotLines := strings.Split(got, "\n")
+	wantLines := strings.Split(want, "\n")
+	if len(gotLines) != len(wantLines) {
+		t.Fatalf("output =\n%s\nwant\n%s", got, want)
+	}
+	for i := range gotLines {
+		if !jsonEqual(t, gotLines[i], wantLines[i]) {
+			t.Fatalf("line %d =\n%s\nwant\n%s", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		format string
+		status int
+	}{
+		{"", "", "csv", http.StatusOK},
+		{"?format=ndjson", "", "ndjson", http.StatusOK},
+		{"?format=xspf", "text/csv", "xspf", http.StatusOK},
+		{"", "application/x-ndjson", "ndjson", http.StatusOK},
+		{"", "text/html, application/xspf+xml;q=0.9", "xspf", http.StatusOK},
+		{"", "application/json", "csv", http.StatusOK},
+		{"?format=json", "", "", http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+" "+tt.accept, func(t *testing.T) {
+			test := newTracksHandlerTest()
+			test.tracks.tracks = []*dao.Tracks{exportTracks[0].track}
+
+			e, recorder := newContext(http.MethodGet, "/v1/tracks/export"+tt.query, "")
+			if tt.accept != "" {
+				e.Request().Header.Set("Accept", tt.accept)
+			}
+			if status := statusOf(test.handler.ExportTracks(e), recorder); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if got := recorder.Header().Get("Content-Type"); got != exportFormats[tt.format] {
+				t.Errorf("Content-Type = %q, want %q", got, exportFormats[tt.format])
+			}
+			if got := recorder.Header().Get("Content-Disposition"); got != `attachment; filename="tracks.`+tt.format+`"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if !strings.Contains(recorder.Body.String(), "Bohemian Rhapsody") {
+				t.Errorf("body = %s, want the exported track", recorder.Body.String())
+			}
+		})
+	}
+}
+
+// jsonEqual compare two JSON documents regardless of key order
+func jsonEqual(t *testing.T, a, b string) bool {
+	t.Helper()
+	if a == "" || b == "" {
+		return a == b
+	}
+	var x, y any
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		t.Fatalf("%v: %s", err, a)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	return reflect.DeepEqual(x, y)
+}
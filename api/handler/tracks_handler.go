@@ -1,17 +1,22 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	_ "github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"log/slog"
 	"math"
 	"music-echo/api/domain/dao"
 	"music-echo/api/domain/dto"
+	"music-echo/api/middleware"
 	"music-echo/api/repository"
 	"music-echo/utils"
 	"net/http"
 	"sync"
+	"time"
 )
 
 var lock sync.Mutex
@@ -22,6 +27,7 @@ type TracksHandler interface {
 	UpdateTracks(e echo.Context) error
 	DeleteTracks(e echo.Context) error
 	GetAllTracks(e echo.Context) error
+	ExportTracks(e echo.Context) error
 	LikeTracks(e echo.Context) error
 }
 
@@ -29,14 +35,20 @@ type TracksHandlerImpl struct {
 	TracksRepository repository.TracksRepository
 	ArtistRepository repository.ArtistRepository
 	LikesRepository  repository.LikesRepository
+	GenresRepository repository.GenresRepository
+	AuditRepository  repository.AuditRepository
+	TxManager        repository.TxManager
 	Validators       *validator.Validate
 }
 
-func NewTracksHandlerImpl(tracksRepository repository.TracksRepository, artistRepository repository.ArtistRepository, likesRepository repository.LikesRepository, validators *validator.Validate) TracksHandler {
+func NewTracksHandlerImpl(tracksRepository repository.TracksRepository, artistRepository repository.ArtistRepository, likesRepository repository.LikesRepository, genresRepository repository.GenresRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, validators *validator.Validate) TracksHandler {
 	return &TracksHandlerImpl{
 		TracksRepository: tracksRepository,
 		ArtistRepository: artistRepository,
 		LikesRepository:  likesRepository,
+		GenresRepository: genresRepository,
+		AuditRepository:  auditRepository,
+		TxManager:        txManager,
 		Validators:       validators,
 	}
 }
@@ -52,7 +64,7 @@ func (t *TracksHandlerImpl) GetTracksByID(e echo.Context) error {
 
 	id, err = utils.ReadIdParam(e)
 	if err != nil {
-		echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
 	}
 
 	// Get Tracks
@@ -62,14 +74,17 @@ func (t *TracksHandlerImpl) GetTracksByID(e echo.Context) error {
 
 	tracksGet, artistGet, likeGet, err = t.TracksRepository.GetId(e.Request().Context(), id)
 	if err != nil {
-		e.JSON(http.StatusNotFound, "theres no track that match an id")
+		return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
 	}
 
 	// Response
 	var trackResponse = dto.TrackGetResponse{
-		Track:  tracksGet,
-		Artist: artistGet,
-		Likes:  likeGet,
+		Track:        tracksGet,
+		Artist:       artistGet,
+		Likes:        likeGet,
+		Plays:        tracksGet.PlayCount,
+		Images:       imageURLs(tracksGet.ImageHash),
+		ArtistImages: imageURLs(artistGet.ImageHash),
 	}
 	var webResponse = dto.WebResponse{
 		Message: fmt.Sprintf("get tracks %d", trackResponse.Track.Id),
@@ -96,25 +111,68 @@ func (t *TracksHandlerImpl) CreateTracks(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotAcceptable, err)
 	}
 
+	// Genres must be in the taxonomy, stored as slugs
+	genres, unknown, err := resolveGenres(e.Request().Context(), t.GenresRepository, tracksRequest.Genre)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+	if len(unknown) > 0 {
+		return unknownGenresError(unknown)
+	}
+
 	// DAO
 	var tracks = &dao.Tracks{
 		Title:    tracksRequest.Title,
 		Duration: tracksRequest.Duration,
 		Year:     tracksRequest.Year,
-		Genre:    tracksRequest.Genre,
+		Genre:    genres,
 	}
 
-	// Get Artist ID by Name
-	var artistGet *dao.Artists
-	artistGet, err = t.ArtistRepository.GetByName(e.Request().Context(), tracksRequest.Artist.Name)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "artist not found!")
-	}
-	tracks.IdArtist = artistGet.Id
+	// Artists, track, credits and the audit event are written together or not at all
+	err = t.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		// Get Artist ID by Name
+		artistGet, err := t.ArtistRepository.GetByName(ctx, tracksRequest.Artist.Name)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "artist not found!")
+		}
+		tracks.IdArtist = artistGet.Id
+
+		// Resolve credited artists by name
+		var credits []dao.TrackCredit
+		for _, credit := range tracksRequest.Credits {
+			artistGet, err = t.ArtistRepository.GetByName(ctx, credit.Name)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("credited artist %q not found!", credit.Name))
+			}
+			credits = append(credits, dao.TrackCredit{IdArtist: artistGet.Id, Role: credit.Role})
+		}
+
+		// Create Track
+		err = t.TracksRepository.Insert(ctx, tracks)
+		if err != nil {
+			return err
+		}
 
-	// Create Track
-	err = t.TracksRepository.Insert(e.Request().Context(), tracks)
+		if len(credits) > 0 {
+			err = t.TracksRepository.InsertCredits(ctx, tracks.Id, credits)
+			if err != nil {
+				return err
+			}
+		}
+		return recordAudit(ctx, e, t.AuditRepository, auditChange{
+			Action:       AuditTrackCreate,
+			Entity:       AuditEntityTrack,
+			EntityId:     tracks.Id,
+			VersionAfter: auditVersion(tracks.Version),
+			After:        tracks,
+		})
+	})
 	if err != nil {
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			return httpError
+		}
+		slog.ErrorContext(e.Request().Context(), "create track", "error", err)
 		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
 
@@ -147,7 +205,7 @@ func (t *TracksHandlerImpl) UpdateTracks(e echo.Context) error {
 	// Read ID of Tracks
 	id, err = utils.ReadIdParam(e)
 	if err != nil {
-		echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
 	}
 
 	// Read Request Body
@@ -163,35 +221,74 @@ func (t *TracksHandlerImpl) UpdateTracks(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotAcceptable, err)
 	}
 
-	// Get Copy of Current Track Version
-	trackGet, artistGet, likeGet, err = t.TracksRepository.GetId(e.Request().Context(), id)
-
-	// Update Track
-	if tracksRequest.Artist != nil && tracksRequest.Artist.Name != nil {
-		artistGet, err = t.ArtistRepository.GetByName(e.Request().Context(), *tracksRequest.Artist.Name)
+	// Genres must be in the taxonomy, stored as slugs
+	var genres []string
+	if tracksRequest.Genre != nil {
+		var unknown []string
+		genres, unknown, err = resolveGenres(e.Request().Context(), t.GenresRepository, *tracksRequest.Genre)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusNotFound, "artist doesnt exist")
+			return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+		}
+		if len(unknown) > 0 {
+			return unknownGenresError(unknown)
 		}
-		trackGet.IdArtist = artistGet.Id
-	}
-	if tracksRequest.Title != nil {
-		trackGet.Title = *tracksRequest.Title
-	}
-	if tracksRequest.Duration != nil {
-		trackGet.Duration = *tracksRequest.Duration
-	}
-	if tracksRequest.Year != nil {
-		trackGet.Year = *tracksRequest.Year
-	}
-	if tracksRequest.Genre != nil {
-		trackGet.Genre = *tracksRequest.Genre
 	}
 
-	err = t.TracksRepository.Update(e.Request().Context(), trackGet)
+	// The update and its audit event, diffed against the current track, are written together
+	err = t.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		var err error
+
+		// Get Copy of Current Track Version
+		trackGet, artistGet, likeGet, err = t.TracksRepository.GetId(ctx, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+		}
+		before := *trackGet
+
+		// Update Track
+		if tracksRequest.Artist != nil && tracksRequest.Artist.Name != nil {
+			artistGet, err = t.ArtistRepository.GetByName(ctx, *tracksRequest.Artist.Name)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusNotFound, "artist doesnt exist")
+			}
+			trackGet.IdArtist = artistGet.Id
+		}
+		if tracksRequest.Title != nil {
+			trackGet.Title = *tracksRequest.Title
+		}
+		if tracksRequest.Duration != nil {
+			trackGet.Duration = *tracksRequest.Duration
+		}
+		if tracksRequest.Year != nil {
+			trackGet.Year = *tracksRequest.Year
+		}
+		if tracksRequest.Genre != nil {
+			trackGet.Genre = genres
+		}
+
+		err = t.TracksRepository.Update(ctx, trackGet)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, t.AuditRepository, auditChange{
+			Action:        AuditTrackUpdate,
+			Entity:        AuditEntityTrack,
+			EntityId:      id,
+			VersionBefore: auditVersion(before.Version),
+			VersionAfter:  auditVersion(trackGet.Version),
+			Before:        &before,
+			After:         trackGet,
+		})
+	})
 	if err != nil {
-		if err.Error() == "trackGet doesnt exist" {
+		var httpError *echo.HTTPError
+		if errors.As(err, &httpError) {
+			return httpError
+		}
+		if err.Error() == "track doesnt exist" {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
 		}
+		slog.ErrorContext(e.Request().Context(), "update track", "track_id", id, "error", err)
 		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
 
@@ -224,12 +321,30 @@ func (t *TracksHandlerImpl) DeleteTracks(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
 	}
 
-	// Delete
-	err = t.TracksRepository.Delete(e.Request().Context(), id)
+	// Delete, the audit event keep the track as it was
+	err = t.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		trackGet, _, _, err := t.TracksRepository.GetId(ctx, id)
+		if err != nil {
+			return errors.New("track doesnt exist")
+		}
+
+		err = t.TracksRepository.Delete(ctx, id)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, t.AuditRepository, auditChange{
+			Action:        AuditTrackDelete,
+			Entity:        AuditEntityTrack,
+			EntityId:      id,
+			VersionBefore: auditVersion(trackGet.Version),
+			Before:        trackGet,
+		})
+	})
 	if err != nil {
 		if err.Error() == "track doesnt exist" {
 			return echo.NewHTTPError(http.StatusNotFound, err.Error())
 		}
+		slog.ErrorContext(e.Request().Context(), "delete track", "track_id", id, "error", err)
 		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
 
@@ -243,8 +358,7 @@ func (t *TracksHandlerImpl) DeleteTracks(e echo.Context) error {
 
 func (t *TracksHandlerImpl) GetAllTracks(e echo.Context) error {
 	var err error
-	var title, artist string
-	var genre []string
+	var filters dto.TrackFilters
 	var sorting utils.Sortings
 	var paginating utils.Paginatings
 	var tracksGetAll []*dao.Tracks
@@ -260,27 +374,36 @@ func (t *TracksHandlerImpl) GetAllTracks(e echo.Context) error {
 	defer lock.Unlock()
 
 	// Query Parameter
-	title = utils.ReadStrQuery(e, "title", "")
-
-	artist = utils.ReadStrQuery(e, "artist", "")
-
-	genre = utils.ReadCSVQuery(e, "genres", []string{})
+	filters, err = t.readTrackFilters(e)
+	if err != nil {
+		return err
+	}
 
-	paginating.Page = utils.ReadIntQuery(e, "page", 1)
-	paginating.PageSize = utils.ReadIntQuery(e, "page_size", 5)
+	paginating.Page, err = utils.ReadIntQuery(e, "page", 1)
+	if err != nil {
+		return invalidQuery(err)
+	}
+	paginating.PageSize, err = utils.ReadIntQuery(e, "page_size", 5)
+	if err != nil {
+		return invalidQuery(err)
+	}
 	err = paginating.Validate(t.Validators)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
 	sorting.Sorts = utils.ReadStrQuery(e, "sort", "id")
-	sorting.SafeSortLists = []string{
-		"id", "title", "year",
-		"-id", "-title", "-year",
+	if filters.Query != "" {
+		sorting.Sorts = utils.ReadStrQuery(e, "sort", "-relevance")
+	}
+	sorting.SafeSortLists = repository.TrackSortLists
+	err = sorting.Validate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	// Get all tracks
-	tracksGetAll, artistGetAll, likes, totalRecord, err = t.TracksRepository.GetAll(e.Request().Context(), title, artist, sorting, paginating, genre)
+	tracksGetAll, artistGetAll, likes, totalRecord, err = t.TracksRepository.GetAll(e.Request().Context(), filters, sorting, paginating)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
@@ -297,17 +420,190 @@ func (t *TracksHandlerImpl) GetAllTracks(e echo.Context) error {
 		tracksResponse[i].Track = tracksGetAll[i]
 		tracksResponse[i].Artist = artistGetAll[i]
 		tracksResponse[i].Likes = likes[i]
+		tracksResponse[i].Plays = tracksGetAll[i].PlayCount
+		tracksResponse[i].Images = imageURLs(tracksGetAll[i].ImageHash)
+		tracksResponse[i].ArtistImages = imageURLs(artistGetAll[i].ImageHash)
 	}
 
 	response = dto.WebResponse{
-		Message:  fmt.Sprintf("Title:%s Artist:%s Genre:%s Page:%d PageSize:%d Sort:%s", title, artist, genre, paginating.Page, paginating.PageSize, sorting.Sorts),
+		Message:  fmt.Sprintf("%s Page:%d PageSize:%d Sort:%s", filtersMessage(filters), paginating.Page, paginating.PageSize, sorting.Sorts),
 		Metadata: metadata,
 		Data:     tracksResponse,
 	}
 	return e.JSON(http.StatusOK, response)
 }
 
+func (t *TracksHandlerImpl) ExportTracks(e echo.Context) error {
+	var err error
+	var format string
+	var filters dto.TrackFilters
+	var sorting utils.Sortings
+	var writer trackWriter
+
+	// Query Parameter, same filters as GetAllTracks without paging
+	filters, err = t.readTrackFilters(e)
+	if err != nil {
+		return err
+	}
+
+	sorting.Sorts = utils.ReadStrQuery(e, "sort", "id")
+	sorting.SafeSortLists = repository.TrackSortLists
+	err = sorting.Validate()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	format, err = exportFormat(e)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotAcceptable, err.Error())
+	}
+
+	// Stream
+	writer = newTrackWriter(format, e.Response())
+	e.Response().Header().Set(echo.HeaderContentType, writer.ContentType())
+	e.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"tracks.%s\"", format))
+	e.Response().WriteHeader(http.StatusOK)
+
+	err = writer.Begin()
+	if err == nil {
+		var rows int
+		err = t.TracksRepository.Export(e.Request().Context(), filters, sorting, func(track *dao.Tracks, artist *dao.Artists, likes int64) error {
+			err := writer.Write(track, artist, likes)
+			if err != nil {
+				return err
+			}
+
+			rows++
+			if rows%exportFlushEvery == 0 {
+				e.Response().Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = writer.End()
+	}
+	if err != nil {
+		// Headers are gone already, all we can do is cut the stream short
+		slog.ErrorContext(e.Request().Context(), "export tracks", "error", err)
+		return nil
+	}
+
+	e.Response().Flush()
+	return nil
+}
+
 func (t *TracksHandlerImpl) LikeTracks(e echo.Context) error {
-	//TODO implement me
-	panic("implement me")
+	var err error
+	var id int64
+	var liked bool
+	var likes int64
+
+	// Read ID of Tracks
+	id, err = utils.ReadIdParam(e)
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	// Toggle and count in one transaction so the count include the toggle
+	user := middleware.ContextGetUser(e)
+	err = t.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		liked, err = t.LikesRepository.Toggle(ctx, user.Id, id)
+		if err != nil {
+			return err
+		}
+		likes, err = t.LikesRepository.CountLikes(ctx, id)
+		return err
+	})
+	if err != nil {
+		if err.Error() == "track doesnt exist" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+		}
+		slog.ErrorContext(e.Request().Context(), "toggle like", "track_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	message := fmt.Sprintf("unlike tracks %d", id)
+	if liked {
+		message = fmt.Sprintf("like tracks %d", id)
+	}
+	response := dto.WebResponse{
+		Message: message,
+		Data: dto.LikeResponse{
+			TrackId: id,
+			Liked:   liked,
+			Likes:   likes,
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// readTrackFilters read and validate the filter query parameters shared by track listings
+func (t *TracksHandlerImpl) readTrackFilters(e echo.Context) (dto.TrackFilters, error) {
+	var filters dto.TrackFilters
+	var err error
+
+	filters.Query = utils.ReadStrQuery(e, "q", "")
+	filters.Title = utils.ReadStrQuery(e, "title", "")
+	filters.Artist = utils.ReadStrQuery(e, "artist", "")
+	filters.Genre = utils.ReadCSVQuery(e, "genres", []string{})
+	filters.GenreMode = utils.ReadStrQuery(e, "genre_mode", "all")
+	filters.YearMin, err = utils.ReadIntQuery(e, "year_min", 0)
+	if err != nil {
+		return filters, invalidQuery(err)
+	}
+	filters.YearMax, err = utils.ReadIntQuery(e, "year_max", 0)
+	if err != nil {
+		return filters, invalidQuery(err)
+	}
+	filters.MinLikes, err = utils.ReadIntQuery(e, "min_likes", 0)
+	if err != nil {
+		return filters, invalidQuery(err)
+	}
+
+	filters.DurationMin, err = utils.ReadDurationQuery(e, "duration_min", 0)
+	if err != nil {
+		return filters, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filters.DurationMax, err = utils.ReadDurationQuery(e, "duration_max", 0)
+	if err != nil {
+		return filters, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filters.CreatedAfter, err = utils.ReadTimeQuery(e, "created_after", time.Time{})
+	if err != nil {
+		return filters, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = t.Validators.Struct(filters)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+
+		ok := errors.As(err, &validationErrors)
+		if !ok {
+			return filters, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		errorMap := make(map[string]string)
+		for i := 0; i < len(validationErrors); i++ {
+			errorMap[validationErrors[i].Field()] = getValidationMessage(validationErrors[i])
+		}
+
+		return filters, echo.NewHTTPError(http.StatusBadRequest, errorMap)
+	}
+
+	return filters, nil
+}
+
+// filtersMessage echo the applied filters back into WebResponse.Message
+func filtersMessage(filters dto.TrackFilters) string {
+	var createdAfter string
+	if !filters.CreatedAfter.IsZero() {
+		createdAfter = filters.CreatedAfter.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("Q:%s Title:%s Artist:%s Genre:%s GenreMode:%s YearMin:%d YearMax:%d DurationMin:%d DurationMax:%d MinLikes:%d CreatedAfter:%s",
+		filters.Query, filters.Title, filters.Artist, filters.Genre, filters.GenreMode,
+		filters.YearMin, filters.YearMax, filters.DurationMin, filters.DurationMax,
+		filters.MinLikes, createdAfter)
 }
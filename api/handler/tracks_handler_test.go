@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"github.com/go-playground/validator/v10"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"testing"
+)
+
+type tracksHandlerTest struct {
+	handler *TracksHandlerImpl
+	log     *writeLog
+	tx      *fakeTxManager
+	tracks  *fakeTracks
+	likes   *fakeLikes
+	audit   *fakeAudit
+}
+
+func newTracksHandlerTest() *tracksHandlerTest {
+	log := &writeLog{}
+	test := &tracksHandlerTest{
+		log:    log,
+		tx:     &fakeTxManager{},
+		tracks: &fakeTracks{log: log},
+		likes:  &fakeLikes{log: log, liked: make(map[int64]bool)},
+		audit:  &fakeAudit{log: log},
+	}
+	test.handler = NewTracksHandlerImpl(test.tracks, &fakeArtists{}, test.likes, &fakeGenres{}, test.audit, test.tx, validator.New()).(*TracksHandlerImpl)
+	return test
+}
+
+func (tr *tracksHandlerTest) create(body string) int {
+	e, recorder := newContext(http.MethodPost, "/v1/tracks", body)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return statusOf(tr.handler.CreateTracks(e), recorder)
+}
+
+func (tr *tracksHandlerTest) like(id string) int {
+	e, recorder := newContext(http.MethodPatch, "/v1/tracks/"+id+"/like", "")
+	e.SetParamNames("tracksId")
+	e.SetParamValues(id)
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	return statusOf(tr.handler.LikeTracks(e), recorder)
+}
+
+const createTrackBody = `{"artist":{"name":"Queen"},"title":"Bohemian Rhapsody","duration":"354 seconds","year":1975,
+	"genre":["rock"],"credits":[{"name":"Freddie Mercury","role":"writer"}]}`
+
+func TestCreateTracksWithinTx(t *testing.T) {
+	test := newTracksHandlerTest()
+
+	if status := test.create(createTrackBody); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	test.log.assertAllInTx(t, "tracks.Insert", "tracks.InsertCredits", "audit.Insert")
+	if test.tx.commits != 1 || test.tx.rollbacks != 0 {
+		t.Fatalf("commits = %d rollbacks = %d, want 1 and 0", test.tx.commits, test.tx.rollbacks)
+	}
+	if event := test.audit.events[0]; event.Action != AuditTrackCreate || event.ActorId != 9 {
+		t.Fatalf("audit event = %+v", event)
+	}
+}
+
+func TestCreateTracksRollbackWhenAuditFail(t *testing.T) {
+	test := newTracksHandlerTest()
+	test.log.failOn = "audit.Insert"
+
+	if status := test.create(createTrackBody); status != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", status)
+	}
+	test.log.assertAllInTx(t, "tracks.Insert", "tracks.InsertCredits", "audit.Insert")
+	if test.tx.commits != 0 || test.tx.rollbacks != 1 {
+		t.Fatalf("commits = %d rollbacks = %d, want 0 and 1", test.tx.commits, test.tx.rollbacks)
+	}
+}
+
+func TestLikeTracksWithinTx(t *testing.T) {
+	test := newTracksHandlerTest()
+
+	if status := test.like("7"); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	test.log.assertAllInTx(t, "likes.Toggle", "likes.CountLikes")
+	if test.tx.commits != 1 || test.tx.rollbacks != 0 {
+		t.Fatalf("commits = %d rollbacks = %d, want 1 and 0", test.tx.commits, test.tx.rollbacks)
+	}
+}
+
+func TestLikeTracksRollbackWhenCountFail(t *testing.T) {
+	test := newTracksHandlerTest()
+	test.log.failOn = "likes.CountLikes"
+
+	if status := test.like("7"); status != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", status)
+	}
+	test.log.assertAllInTx(t, "likes.Toggle", "likes.CountLikes")
+	if test.tx.commits != 0 || test.tx.rollbacks != 1 {
+		t.Fatalf("commits = %d rollbacks = %d, want 0 and 1", test.tx.commits, test.tx.rollbacks)
+	}
+}
+
+func (tr *tracksHandlerTest) get(id string) (int, string) {
+	e, recorder := newContext(http.MethodGet, "/v1/tracks/"+id, "")
+	e.SetParamNames("tracksId")
+	e.SetParamValues(id)
+	return statusOf(tr.handler.GetTracksByID(e), recorder), recorder.Body.String()
+}
+
+func TestGetTracksByID(t *testing.T) {
+	test := newTracksHandlerTest()
+	if status := test.create(createTrackBody); status != http.StatusOK {
+		t.Fatalf("create: status = %d, want 200", status)
+	}
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"1", http.StatusOK},
+		{"2", http.StatusNotFound},
+		{"abc", http.StatusBadRequest},
+		{"0", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status, body := test.get(tt.id); status != tt.status {
+			t.Errorf("GET /v1/tracks/%s: status = %d, want %d (%s)", tt.id, status, tt.status, body)
+		}
+	}
+}
+
+func TestUpdateTracksInvalidID(t *testing.T) {
+	test := newTracksHandlerTest()
+
+	e, recorder := newContext(http.MethodPatch, "/v1/tracks/abc", `{"title":"Innuendo"}`)
+	e.SetParamNames("tracksId")
+	e.SetParamValues("abc")
+	withUser(e, &dao.Users{Id: 9, Activated: true})
+	if status := statusOf(test.handler.UpdateTracks(e), recorder); status != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", status)
+	}
+	if len(test.log.writes) != 0 {
+		t.Fatalf("writes = %q, want none", test.log.writes)
+	}
+}
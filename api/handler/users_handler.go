@@ -1,37 +1,55 @@
 package handler
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
-	"log"
+	"log/slog"
+	"math"
 	"music-echo/api/domain/dao"
 	"music-echo/api/domain/dto"
 	"music-echo/api/repository"
 	"music-echo/utils"
+	"music-echo/utils/lockout"
 	"music-echo/utils/token"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type UserHandler interface {
 	CreateUser(e echo.Context) error
 	ActivateUser(e echo.Context) error
+	CreateAuthenticationToken(e echo.Context) error
+	UnlockUser(e echo.Context) error
 }
 
 type UserHandlerImpl struct {
 	Validators      *validator.Validate
 	UsersRepository repository.UsersRepository
 	TokenRepository repository.TokenRepository
+	AuditRepository repository.AuditRepository
+	TxManager       repository.TxManager
 	Mailer          utils.Mailer
+	// AccountGuard count failed logins per email, IPGuard failed logins and activations per client IP
+	AccountGuard *lockout.Guard
+	IPGuard      *lockout.Guard
 }
 
-func NewUserHandlerImpl(validators *validator.Validate, usersRepository repository.UsersRepository, tokenRepository repository.TokenRepository, mailer utils.Mailer) UserHandler {
+func NewUserHandlerImpl(validators *validator.Validate, usersRepository repository.UsersRepository, tokenRepository repository.TokenRepository, auditRepository repository.AuditRepository, txManager repository.TxManager, mailer utils.Mailer, accountGuard *lockout.Guard, ipGuard *lockout.Guard) UserHandler {
 	return UserHandlerImpl{
 		Validators:      validators,
 		UsersRepository: usersRepository,
 		TokenRepository: tokenRepository,
+		AuditRepository: auditRepository,
+		TxManager:       txManager,
 		Mailer:          mailer,
+		AccountGuard:    accountGuard,
+		IPGuard:         ipGuard,
 	}
 }
 
@@ -71,36 +89,51 @@ func (u UserHandlerImpl) CreateUser(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	err = u.UsersRepository.Insert(e.Request().Context(), users)
-	if err != nil {
-		switch {
-		case err.Error() == "duplicate email":
-			return echo.NewHTTPError(http.StatusNotAcceptable, "email already exist")
-		default:
-			return echo.NewHTTPError(http.StatusConflict, err)
+	// A user is only created along with its activation token and audit event, registering
+	// users are their own actor
+	var plainText string
+	err = u.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		err := u.UsersRepository.Insert(ctx, users)
+		if err != nil {
+			return err
 		}
-	}
-
-	tokens, plainText, err := token.GenerateToken(users.Id, 3*24*time.Hour, token.ScopeActivation)
-	if err != nil {
-		return err
-	}
 
-	err = u.TokenRepository.Insert(e.Request().Context(), tokens)
+		var tokens *dao.Token
+		tokens, plainText, err = token.GenerateToken(users.Id, 3*24*time.Hour, token.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		err = u.TokenRepository.Insert(ctx, tokens)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, u.AuditRepository, auditChange{
+			Action:       AuditUserCreate,
+			Entity:       AuditEntityUser,
+			EntityId:     users.Id,
+			ActorId:      users.Id,
+			VersionAfter: auditVersion(users.Version),
+			After:        users,
+		})
+	})
 	if err != nil {
-		return err
+		if err.Error() == "duplicate email" {
+			return echo.NewHTTPError(http.StatusNotAcceptable, "email already exist")
+		}
+		slog.ErrorContext(e.Request().Context(), "create user", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
 
 	// Send email
-	utils.Background(func() {
+	utils.Background(e.Request().Context(), func(ctx context.Context) {
 		data := map[string]any{
 			"Id":              users.Id,
 			"Name":            users.Name,
 			"activationToken": plainText,
 		}
-		err = u.Mailer.Send(u.Mailer.Sender, "user_welcome.tmpl", data)
+		err := u.Mailer.Send(ctx, u.Mailer.Sender, "user_welcome.tmpl", data)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "send welcome email", "user_id", users.Id, "error", err)
 		}
 	})
 
@@ -145,26 +178,223 @@ func (u UserHandlerImpl) ActivateUser(e echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotAcceptable, errorMap)
 	}
 
+	// Guessing activation tokens lock the client IP out
+	ipKey := ipLockoutKey(e.RealIP())
+	if delay := u.IPGuard.Locked(ipKey); delay > 0 {
+		return tooManyAttempts(e, delay)
+	}
+
 	// Activate user
 	user, err := u.UsersRepository.GetByToken(e.Request().Context(), userRequest.Token, token.ScopeActivation)
 	if err != nil {
-		log.Println(err)
+		if err.Error() == "no record" {
+			u.IPGuard.Fail(ipKey)
+			return echo.NewHTTPError(http.StatusNotAcceptable, map[string]string{"token": "invalid or expired activation token"})
+		}
+		slog.ErrorContext(e.Request().Context(), "get user by activation token", "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
 	}
+	before := *user
 	user.Activated = true
 
-	err = u.UsersRepository.Update(e.Request().Context(), user)
+	// Activate, delete activation token for corresponding user (since it is no longer needed)
+	// and audit it, the user being its own actor
+	err = u.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		err := u.UsersRepository.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+		err = u.TokenRepository.Delete(ctx, user.Id, token.ScopeActivation)
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, e, u.AuditRepository, auditChange{
+			Action:        AuditUserActivate,
+			Entity:        AuditEntityUser,
+			EntityId:      user.Id,
+			ActorId:       user.Id,
+			VersionBefore: auditVersion(before.Version),
+			VersionAfter:  auditVersion(user.Version),
+			Before:        &before,
+			After:         user,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "activate user", "user_id", user.Id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// Response
+	return e.JSON(http.StatusOK, user)
+}
+
+func (u UserHandlerImpl) CreateAuthenticationToken(e echo.Context) error {
+	// Read and bind json request
+	userRequest := new(dto.UserLoginRequest)
+	err := utils.ReadJSON(e, userRequest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	// Validate request body
+	err = u.Validators.Struct(userRequest)
+	if err != nil {
+		var validationErrors validator.ValidationErrors
+
+		ok := errors.As(err, &validationErrors)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		errorMap := make(map[string]string)
+		for i := 0; i < len(validationErrors); i++ {
+			errorMap[validationErrors[i].Field()] = getValidationMessage(validationErrors[i])
+		}
+
+		return echo.NewHTTPError(http.StatusNotAcceptable, errorMap)
+	}
+
+	// A locked account or client IP is refused before the password is even checked
+	accountKey := accountLockoutKey(userRequest.Email)
+	ipKey := ipLockoutKey(e.RealIP())
+	if delay := max(u.AccountGuard.Locked(accountKey), u.IPGuard.Locked(ipKey)); delay > 0 {
+		return tooManyAttempts(e, delay)
+	}
+
+	// Check credentials, same answer for unknown email and wrong password
+	user, err := u.UsersRepository.GetByEmail(e.Request().Context(), userRequest.Email)
 	if err != nil {
-		log.Println(err)
+		if err.Error() == "record not found" {
+			utils.MatchesNothing(userRequest.Password)
+			u.loginFailed(e.Request().Context(), nil, accountKey, ipKey)
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid authentication credentials")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// Delete activation token for corresponding user (since it is no longer needed)
-	err = u.TokenRepository.Delete(e.Request().Context(), user.Id, token.ScopeActivation)
+	match, err := user.Password.Matches(userRequest.Password)
 	if err != nil {
-		log.Println(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !match {
+		u.loginFailed(e.Request().Context(), user, accountKey, ipKey)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid authentication credentials")
+	}
+	u.AccountGuard.Succeed(accountKey)
+	if user.Banned {
+		return echo.NewHTTPError(http.StatusForbidden, "your user account has been banned")
+	}
+	if !user.Activated {
+		return echo.NewHTTPError(http.StatusForbidden, "your user account must be activated to access this resource")
+	}
+
+	// Insert authentication token
+	tokens, plainText, err := token.GenerateToken(user.Id, 24*time.Hour, token.ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	err = u.TokenRepository.Insert(e.Request().Context(), tokens)
+	if err != nil {
+		return err
 	}
 
 	// Response
-	return e.JSON(http.StatusOK, user)
+	response := dto.WebResponse{
+		Message: "success create authentication token",
+		Data: dto.TokenResponse{
+			Token:  plainText,
+			Expiry: tokens.Expiry,
+		},
+	}
+
+	return e.JSON(http.StatusCreated, response)
+}
+
+func (u UserHandlerImpl) UnlockUser(e echo.Context) error {
+	// Read ID of Users
+	id, err := utils.ReadIdParamByName(e, "userId")
+	if err != nil || id == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id parameter")
+	}
+
+	user, err := u.UsersRepository.GetById(e.Request().Context(), id)
+	if err != nil {
+		if err.Error() == "record not found" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no user that match an id")
+		}
+		slog.ErrorContext(e.Request().Context(), "get user", "user_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	// The client IP the user sign in from may be locked out as well, the unlock is only
+	// done once it's audited
+	ip := utils.ReadStrQuery(e, "ip", "")
+	change := auditChange{
+		Action:   AuditUserUnlock,
+		Entity:   AuditEntityUser,
+		EntityId: user.Id,
+	}
+	if ip != "" {
+		change.Details = map[string]any{"ip": ip}
+	}
+	err = u.TxManager.WithinTx(e.Request().Context(), func(ctx context.Context) error {
+		return recordAudit(ctx, e, u.AuditRepository, change)
+	})
+	if err != nil {
+		slog.ErrorContext(e.Request().Context(), "unlock user", "user_id", id, "error", err)
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	}
+
+	u.AccountGuard.Unlock(accountLockoutKey(user.Email))
+	if ip != "" {
+		u.IPGuard.Unlock(ipLockoutKey(ip))
+	}
+
+	// Response
+	response := dto.WebResponse{
+		Message: fmt.Sprintf("unlock user %d", id),
+		Data: dto.UnlockResponse{
+			UserId: id,
+			Ip:     ip,
+		},
+	}
+	return e.JSON(http.StatusOK, response)
+}
+
+// loginFailed count a failed login against the account and the client IP, the owner of an
+// account that just got locked is told by email
+func (u UserHandlerImpl) loginFailed(ctx context.Context, user *dao.Users, accountKey, ipKey string) {
+	u.IPGuard.Fail(ipKey)
+	delay := u.AccountGuard.Fail(accountKey)
+	if delay == 0 || user == nil {
+		return
+	}
+
+	utils.Background(ctx, func(ctx context.Context) {
+		data := map[string]any{
+			"Name":    user.Name,
+			"Minutes": int(delay.Minutes()),
+		}
+		err := u.Mailer.Send(ctx, user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			slog.ErrorContext(ctx, "send account locked email", "user_id", user.Id, "error", err)
+		}
+	})
+}
+
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// tooManyAttempts refuse a locked out request and tell the client when to try again
+func tooManyAttempts(e echo.Context, delay time.Duration) error {
+	e.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed attempts, try again later")
 }
 
 func getValidationMessage(fieldError validator.FieldError) string {
@@ -174,10 +404,25 @@ func getValidationMessage(fieldError validator.FieldError) string {
 	case "email":
 		return "is not a valid email address"
 	case "min":
+		if fieldError.Kind() != reflect.String {
+			return "must be at least " + fieldError.Param()
+		}
 		return "must be at least " + fieldError.Param() + " characters long"
 	case "max":
+		if fieldError.Kind() != reflect.String {
+			return "must be at most " + fieldError.Param()
+		}
 		return "must be at most " + fieldError.Param() + " characters long"
+	case "oneof":
+		return "must be one of: " + fieldError.Param()
+	case "gtefield":
+		return "must be greater than or equal to " + fieldError.Param()
 	default:
 		return "is invalid"
 	}
 }
+
+// invalidQuery refuse a request whose query parameter could not be parsed, the error name it
+func invalidQuery(err error) error {
+	return echo.NewHTTPError(http.StatusBadRequest, dto.WebResponse{Message: err.Error()})
+}
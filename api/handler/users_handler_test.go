@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/go-playground/validator/v10"
+	"music-echo/api/domain/dao"
+	"music-echo/utils/lockout"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type userHandlerTest struct {
+	handler UserHandlerImpl
+	log     *writeLog
+	tx      *fakeTxManager
+	users   *fakeUsers
+	tokens  *fakeTokens
+	audit   *fakeAudit
+	dialer  *fakeDialer
+	clock   *fakeClock
+}
+
+// newUserHandlerTest build a handler whose accounts lock after 3 failures and client IPs after 5
+func newUserHandlerTest() *userHandlerTest {
+	log := &writeLog{}
+	test := &userHandlerTest{
+		log:    log,
+		tx:     &fakeTxManager{},
+		users:  newFakeUsers(log),
+		tokens: &fakeTokens{log: log},
+		audit:  &fakeAudit{log: log},
+		clock:  &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	accountGuard := lockout.NewGuard(3, time.Minute, 10*time.Minute, time.Hour)
+	accountGuard.Now = test.clock.Now
+	ipGuard := lockout.NewGuard(5, time.Minute, 10*time.Minute, time.Hour)
+	ipGuard.Now = test.clock.Now
+
+	test.handler.Mailer, test.dialer = newFakeMailer()
+	test.handler.Validators = validator.New()
+	test.handler.UsersRepository = test.users
+	test.handler.TokenRepository = test.tokens
+	test.handler.AuditRepository = test.audit
+	test.handler.TxManager = test.tx
+	test.handler.AccountGuard = accountGuard
+	test.handler.IPGuard = ipGuard
+	return test
+}
+
+func (u *userHandlerTest) login(email, password string) (int, http.Header) {
+	e, recorder := newContext(http.MethodPost, "/v1/tokens/authentication", `{"email":"`+email+`","password":"`+password+`"}`)
+	err := u.handler.CreateAuthenticationToken(e)
+	return statusOf(err, recorder), e.Response().Header()
+}
+
+func (u *userHandlerTest) activate(token string) int {
+	e, recorder := newContext(http.MethodPut, "/v1/users/activated", `{"token":"`+token+`"}`)
+	return statusOf(u.handler.ActivateUser(e), recorder)
+}
+
+func TestCreateAuthenticationTokenLockout(t *testing.T) {
+	test := newUserHandlerTest()
+	test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com", Activated: true}, "correct horse")
+
+	for i := 0; i < 2; i++ {
+		if status, _ := test.login("someone@example.com", "wrong horse"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status = %d, want 401", i+1, status)
+		}
+	}
+	test.dialer.assertNoneSent(t)
+
+	// The failure that lock the account tell its owner
+	if status, _ := test.login("someone@example.com", "wrong horse"); status != http.StatusUnauthorized {
+		t.Fatalf("locking failure: status = %d, want 401", status)
+	}
+	message := test.dialer.wait(t)
+	if to := message.GetHeader("To"); len(to) != 1 || to[0] != "someone@example.com" {
+		t.Fatalf("email sent to %q", to)
+	}
+	if subject := message.GetHeader("Subject"); len(subject) != 1 || !strings.Contains(subject[0], "locked") {
+		t.Fatalf("subject = %q", subject)
+	}
+	var body bytes.Buffer
+	if _, err := message.WriteTo(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.String(), "Hi, Someone") || !strings.Contains(body.String(), "for 1 minutes") {
+		t.Fatalf("account_locked.tmpl body = %s", body.String())
+	}
+
+	// Even the right password is refused while locked
+	status, header := test.login("someone@example.com", "correct horse")
+	if status != http.StatusTooManyRequests || header.Get("Retry-After") != "60" {
+		t.Fatalf("locked: status = %d Retry-After = %q, want 429 and 60", status, header.Get("Retry-After"))
+	}
+
+	test.clock.Advance(time.Minute + time.Second)
+	if status, _ := test.login("someone@example.com", "correct horse"); status != http.StatusCreated {
+		t.Fatalf("after the lockout: status = %d, want 201", status)
+	}
+	if len(test.tokens.tokens) != 1 {
+		t.Fatalf("tokens = %d, want 1", len(test.tokens.tokens))
+	}
+}
+
+func TestCreateAuthenticationTokenLockoutUnknownEmail(t *testing.T) {
+	test := newUserHandlerTest()
+
+	for i := 0; i < 3; i++ {
+		if status, _ := test.login("nobody@example.com", "wrong horse"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status = %d, want 401", i+1, status)
+		}
+	}
+	// Locked like a known account, without anyone to tell
+	if status, _ := test.login("nobody@example.com", "wrong horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+	test.dialer.assertNoneSent(t)
+}
+
+func TestCreateAuthenticationTokenLockoutClientIP(t *testing.T) {
+	test := newUserHandlerTest()
+	test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com", Activated: true}, "correct horse")
+
+	// Spread over accounts so none of them lock, the client IP does
+	for i := 0; i < 5; i++ {
+		email := string(rune('a'+i)) + "@example.com"
+		if status, _ := test.login(email, "wrong horse"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status = %d, want 401", i+1, status)
+		}
+	}
+	if status, _ := test.login("someone@example.com", "correct horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+}
+
+func TestActivateUserLockout(t *testing.T) {
+	test := newUserHandlerTest()
+	user := test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com"}, "correct horse")
+	test.users.activations["VALIDTOKEN"] = user.Id
+
+	for i := 0; i < 5; i++ {
+		if status := test.activate("GUESSEDTOKEN"); status != http.StatusNotAcceptable {
+			t.Fatalf("guess %d: status = %d, want 406", i+1, status)
+		}
+	}
+	if status := test.activate("VALIDTOKEN"); status != http.StatusTooManyRequests {
+		t.Fatalf("locked: status = %d, want 429", status)
+	}
+	if len(test.log.writes) != 0 {
+		t.Fatalf("writes while locked: %q", test.log.writes)
+	}
+
+	test.clock.Advance(time.Minute + time.Second)
+	if status := test.activate("VALIDTOKEN"); status != http.StatusOK {
+		t.Fatalf("after the lockout: status = %d, want 200", status)
+	}
+	if !test.users.users[user.Id].Activated {
+		t.Fatal("user was not activated")
+	}
+	test.log.assertAllInTx(t, "users.Update", "tokens.Delete", "audit.Insert")
+}
+
+func TestUnlockUser(t *testing.T) {
+	test := newUserHandlerTest()
+	user := test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com", Activated: true}, "correct horse")
+
+	for i := 0; i < 3; i++ {
+		test.login("someone@example.com", "wrong horse")
+	}
+	test.dialer.wait(t)
+	if status, _ := test.login("someone@example.com", "correct horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+
+	e, recorder := newContext(http.MethodPost, "/v1/admin/users/1/unlock?ip=192.0.2.1", "")
+	e.SetParamNames("userId")
+	e.SetParamValues("1")
+	withUser(e, &dao.Users{Id: 2, Activated: true})
+	if status := statusOf(test.handler.UnlockUser(e), recorder); status != http.StatusOK {
+		t.Fatalf("unlock: status = %d, want 200", status)
+	}
+	if !strings.Contains(recorder.Body.String(), `"user_id":1`) {
+		t.Fatalf("unlock response = %s", recorder.Body.String())
+	}
+	test.log.assertAllInTx(t, "audit.Insert")
+	event := test.audit.events[0]
+	if event.Action != AuditUserUnlock || event.EntityId != user.Id || event.ActorId != 2 || string(event.Details) != `{"ip":"192.0.2.1"}` {
+		t.Fatalf("audit event = %+v", event)
+	}
+
+	if status, _ := test.login(user.Email, "correct horse"); status != http.StatusCreated {
+		t.Fatalf("after unlock: status = %d, want 201", status)
+	}
+}
+
+func (u *userHandlerTest) register(body string) int {
+	e, recorder := newContext(http.MethodPost, "/v1/users", body)
+	return statusOf(u.handler.CreateUser(e), recorder)
+}
+
+func TestCreateUserWithinTx(t *testing.T) {
+	test := newUserHandlerTest()
+
+	status := test.register(`{"name":"Someone","email":"someone@example.com","password":"correct horse"}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	test.log.assertAllInTx(t, "users.Insert", "tokens.Insert", "audit.Insert")
+	if test.tx.commits != 1 || test.tx.rollbacks != 0 {
+		t.Fatalf("commits = %d rollbacks = %d, want 1 and 0", test.tx.commits, test.tx.rollbacks)
+	}
+	if event := test.audit.events[0]; event.Action != AuditUserCreate || event.ActorId != 1 {
+		t.Fatalf("audit event = %+v", event)
+	}
+	test.dialer.wait(t)
+}
+
+func TestCreateUserRollbackWhenTokenFail(t *testing.T) {
+	test := newUserHandlerTest()
+	test.log.failOn = "tokens.Insert"
+
+	status := test.register(`{"name":"Someone","email":"someone@example.com","password":"correct horse"}`)
+	if status != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", status)
+	}
+	test.log.assertAllInTx(t, "users.Insert", "tokens.Insert")
+	if test.tx.commits != 0 || test.tx.rollbacks != 1 {
+		t.Fatalf("commits = %d rollbacks = %d, want 0 and 1", test.tx.commits, test.tx.rollbacks)
+	}
+	test.dialer.assertNoneSent(t)
+}
+
+func TestUnlockUserKeepLockWhenAuditFail(t *testing.T) {
+	test := newUserHandlerTest()
+	test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com", Activated: true}, "correct horse")
+	test.log.failOn = "audit.Insert"
+
+	for i := 0; i < 3; i++ {
+		test.login("someone@example.com", "wrong horse")
+	}
+	test.dialer.wait(t)
+
+	e, recorder := newContext(http.MethodPost, "/v1/admin/users/1/unlock", "")
+	e.SetParamNames("userId")
+	e.SetParamValues("1")
+	withUser(e, &dao.Users{Id: 2, Activated: true})
+	if status := statusOf(test.handler.UnlockUser(e), recorder); status != http.StatusConflict {
+		t.Fatalf("unlock: status = %d, want 409", status)
+	}
+	if status, _ := test.login("someone@example.com", "correct horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 while the unlock wasn't audited", status)
+	}
+}
+
+func TestCreateAuthenticationTokenRefuseUnactivated(t *testing.T) {
+	test := newUserHandlerTest()
+	test.users.add(t, dao.Users{Name: "Someone", Email: "someone@example.com"}, "correct horse")
+
+	if status, _ := test.login("someone@example.com", "correct horse"); status != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", status)
+	}
+	if len(test.tokens.tokens) != 0 {
+		t.Fatalf("tokens = %d, want 0", len(test.tokens.tokens))
+	}
+}
@@ -0,0 +1,56 @@
+// Package job hold the periodic background work of the server
+package job
+
+import (
+	"context"
+	"log/slog"
+	"music-echo/api/repository"
+	"sort"
+	"time"
+)
+
+// ChartsJob recompute the chart snapshot of every window on an interval
+type ChartsJob struct {
+	ChartsRepository repository.ChartsRepository
+	Interval         time.Duration
+}
+
+func NewChartsJob(chartsRepository repository.ChartsRepository, interval time.Duration) *ChartsJob {
+	return &ChartsJob{
+		ChartsRepository: chartsRepository,
+		Interval:         interval,
+	}
+}
+
+// Run refresh once right away then every Interval, until ctx is done
+func (c *ChartsJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ChartsJob) refresh(ctx context.Context) {
+	var windows []string
+	for window := range repository.ChartWindows {
+		windows = append(windows, window)
+	}
+	sort.Strings(windows)
+
+	// Every window share the same end so the snapshots line up
+	now := time.Now().Truncate(time.Second)
+	for _, window := range windows {
+		err := c.ChartsRepository.Refresh(ctx, window, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "refresh chart", "window", window, "error", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"music-echo/api/domain/dao"
+	"music-echo/api/repository"
+	"music-echo/utils/token"
+	"net/http"
+	"strings"
+)
+
+const userContextKey = "user"
+
+type Middleware struct {
+	UsersRepository       repository.UsersRepository
+	PermissionsRepository repository.PermissionsRepository
+	RateLimiters          map[string]*RateLimiter
+}
+
+func NewMiddleware(usersRepository repository.UsersRepository, permissionsRepository repository.PermissionsRepository, rateLimiters map[string]*RateLimiter) *Middleware {
+	return &Middleware{
+		UsersRepository:       usersRepository,
+		PermissionsRepository: permissionsRepository,
+		RateLimiters:          rateLimiters,
+	}
+}
+
+// Authenticate resolve the bearer token into the request user, requests without one are anonymous
+func (m *Middleware) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		e.Response().Header().Add(echo.HeaderVary, echo.HeaderAuthorization)
+
+		authorization := e.Request().Header.Get(echo.HeaderAuthorization)
+		if authorization == "" {
+			e.Set(userContextKey, dao.AnonymousUser)
+			return next(e)
+		}
+
+		// ex: Bearer Y3QMGX3PJ3WLRL2YRTQGQ6KRHU
+		headerParts := strings.Split(authorization, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" || len(headerParts[1]) != 26 {
+			return invalidAuthenticationToken(e)
+		}
+
+		user, err := m.UsersRepository.GetByToken(e.Request().Context(), headerParts[1], token.ScopeAuthentication)
+		if err != nil {
+			if err.Error() == "no record" {
+				return invalidAuthenticationToken(e)
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		// Banning revoke the tokens, a request racing the ban is refused still
+		if user.Banned {
+			return echo.NewHTTPError(http.StatusForbidden, "your user account has been banned")
+		}
+
+		e.Set(userContextKey, user)
+		return next(e)
+	}
+}
+
+// RequireAuthenticatedUser reject anonymous requests
+func (m *Middleware) RequireAuthenticatedUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		if ContextGetUser(e).IsAnonymous() {
+			return echo.NewHTTPError(http.StatusUnauthorized, "you must be authenticated to access this resource")
+		}
+		return next(e)
+	}
+}
+
+// RequireActivatedUser reject anonymous requests and users who haven't activated their account
+func (m *Middleware) RequireActivatedUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return m.RequireAuthenticatedUser(func(e echo.Context) error {
+		if !ContextGetUser(e).Activated {
+			return echo.NewHTTPError(http.StatusForbidden, "your user account must be activated to access this resource")
+		}
+		return next(e)
+	})
+}
+
+// RequirePermission reject activated users who lack the permission code
+func (m *Middleware) RequirePermission(code string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return m.RequireActivatedUser(func(e echo.Context) error {
+			permissions, err := m.PermissionsRepository.GetAllForUser(e.Request().Context(), ContextGetUser(e).Id)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, err)
+			}
+			if !permissions.Include(code) {
+				return echo.NewHTTPError(http.StatusForbidden, "your user account doesn't have the necessary permissions to access this resource")
+			}
+			return next(e)
+		})
+	}
+}
+
+// ContextGetUser return the user set by Authenticate, anonymous when there's none
+func ContextGetUser(e echo.Context) *dao.Users {
+	user, ok := e.Get(userContextKey).(*dao.Users)
+	if !ok {
+		return dao.AnonymousUser
+	}
+	return user
+}
+
+func invalidAuthenticationToken(e echo.Context) error {
+	e.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing authentication token")
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"net/http"
+)
+
+// corsMaxAge is how long, in seconds, a browser may cache a preflight answer
+const corsMaxAge = 3600
+
+// CORS let the trusted origins call the API from a browser, requests from any other origin get
+// no CORS headers at all and no origin is trusted unless configured
+func CORS(origins []string) echo.MiddlewareFunc {
+	if len(origins) == 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: origins,
+		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowHeaders: []string{echo.HeaderAuthorization, echo.HeaderContentType, "If-Match", echo.HeaderAccept},
+		ExposeHeaders: []string{
+			"ETag",
+			echo.HeaderRetryAfter,
+			"X-RateLimit-Limit",
+			"X-RateLimit-Remaining",
+			"X-RateLimit-Reset",
+		},
+		MaxAge: corsMaxAge,
+	})
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const trustedOrigin = "https://player.example.com"
+
+func newTestCORSServer(origins []string) *echo.Echo {
+	e := echo.New()
+	e.Use(CORS(origins))
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/v1/tracks/:tracksId", ok)
+	e.PATCH("/v1/tracks/:tracksId", ok)
+	e.DELETE("/v1/tracks/:tracksId", ok)
+	return e
+}
+
+func serveCORS(e *echo.Echo, method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/v1/tracks/1", nil)
+	if origin != "" {
+		req.Header.Set(echo.HeaderOrigin, origin)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func hasVary(rec *httptest.ResponseRecorder, value string) bool {
+	for _, vary := range rec.Header().Values(echo.HeaderVary) {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.TrimSpace(v) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	e := newTestCORSServer([]string{trustedOrigin})
+
+	rec := serveCORS(e, http.MethodGet, trustedOrigin, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != trustedOrigin {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, trustedOrigin)
+	}
+	if !hasVary(rec, echo.HeaderOrigin) {
+		t.Fatalf("Vary = %q, want Origin", rec.Header().Values(echo.HeaderVary))
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	e := newTestCORSServer([]string{trustedOrigin})
+
+	for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+		rec := serveCORS(e, http.MethodOptions, trustedOrigin, map[string]string{
+			echo.HeaderAccessControlRequestMethod:  method,
+			echo.HeaderAccessControlRequestHeaders: "Authorization, If-Match",
+		})
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("%s preflight: status = %d, want 204", method, rec.Code)
+		}
+		if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != trustedOrigin {
+			t.Fatalf("%s preflight: Access-Control-Allow-Origin = %q, want %q", method, got, trustedOrigin)
+		}
+		if got := rec.Header().Get(echo.HeaderAccessControlAllowMethods); !strings.Contains(got, method) {
+			t.Fatalf("%s preflight: Access-Control-Allow-Methods = %q", method, got)
+		}
+		allowHeaders := rec.Header().Get(echo.HeaderAccessControlAllowHeaders)
+		for _, header := range []string{"Authorization", "If-Match"} {
+			if !strings.Contains(allowHeaders, header) {
+				t.Fatalf("%s preflight: Access-Control-Allow-Headers = %q, want %s", method, allowHeaders, header)
+			}
+		}
+		if !hasVary(rec, echo.HeaderOrigin) {
+			t.Fatalf("%s preflight: Vary = %q, want Origin", method, rec.Header().Values(echo.HeaderVary))
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	e := newTestCORSServer([]string{trustedOrigin})
+
+	rec := serveCORS(e, http.MethodGet, "https://evil.example.com", nil)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+	// The answer still depend on the origin so caches must not share it across origins
+	if !hasVary(rec, echo.HeaderOrigin) {
+		t.Fatalf("Vary = %q, want Origin", rec.Header().Values(echo.HeaderVary))
+	}
+
+	rec = serveCORS(e, http.MethodOptions, "https://evil.example.com", map[string]string{
+		echo.HeaderAccessControlRequestMethod:  http.MethodDelete,
+		echo.HeaderAccessControlRequestHeaders: "Authorization",
+	})
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "" {
+		t.Fatalf("preflight: Access-Control-Allow-Origin = %q, want none", got)
+	}
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowMethods); got != "" {
+		t.Fatalf("preflight: Access-Control-Allow-Methods = %q, want none", got)
+	}
+}
+
+func TestCORSNoTrustedOrigins(t *testing.T) {
+	e := newTestCORSServer(nil)
+
+	rec := serveCORS(e, http.MethodGet, trustedOrigin, nil)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "" {
+		t.Fatalf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
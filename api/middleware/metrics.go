@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"music-echo/utils/metrics"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = metrics.Default.Counter("http_requests_total", "HTTP requests served by method, route and status.", "method", "route", "status")
+	httpDuration = metrics.Default.Histogram("http_request_duration_seconds", "HTTP request latency by method, route and status.", metrics.DefaultBuckets, "method", "route", "status")
+	httpInFlight = metrics.Default.Gauge("http_requests_in_flight", "HTTP requests currently being served.")
+)
+
+// Metrics count and time every request, labelled by the route pattern ex: /v1/tracks/:tracksId
+// rather than the path so the number of series stay bounded
+func Metrics(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		err := next(e)
+		// Write the error now so the status it maps to is the one counted, the error is still
+		// returned for the middlewares around this one to log and trace
+		if err != nil {
+			e.Error(err)
+		}
+
+		route := e.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(e.Response().Status)
+		method := e.Request().Method
+
+		httpRequests.Inc(method, route, status)
+		httpDuration.Observe(time.Since(start).Seconds(), method, route, status)
+		return err
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"music-echo/utils/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsLabelRoutePattern(t *testing.T) {
+	e := echo.New()
+	e.Use(Metrics)
+	e.GET("/v1/metrics-test/:tracksId", func(c echo.Context) error {
+		if c.Param("tracksId") == "404" {
+			return echo.NewHTTPError(http.StatusNotFound, "theres no track that match an id")
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, path := range []string{"/v1/metrics-test/1", "/v1/metrics-test/2", "/v1/metrics-test/404"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var b strings.Builder
+	if _, err := metrics.Default.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	scrape := b.String()
+
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/v1/metrics-test/:tracksId",status="200"} 2`,
+		`http_requests_total{method="GET",route="/v1/metrics-test/:tracksId",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/v1/metrics-test/:tracksId",status="200"} 2`,
+		"http_requests_in_flight 0",
+	} {
+		if !strings.Contains(scrape, want) {
+			t.Fatalf("scrape is missing %q:\n%s", want, scrape)
+		}
+	}
+	if strings.Contains(scrape, `route="/v1/metrics-test/1"`) {
+		t.Fatalf("route label use the path instead of the pattern:\n%s", scrape)
+	}
+}
+
+func TestMetricsReturnError(t *testing.T) {
+	var seen error
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			seen = next(c)
+			return seen
+		}
+	})
+	e.Use(Metrics)
+	e.GET("/v1/metrics-error-test", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusConflict, "conflicting database")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/metrics-error-test", nil))
+
+	var httpError *echo.HTTPError
+	if !errors.As(seen, &httpError) || httpError.Code != http.StatusConflict {
+		t.Fatalf("outer middleware saw %v, want the handler error", seen)
+	}
+	// The error is answered once
+	if rec.Code != http.StatusConflict || strings.Count(rec.Body.String(), "conflicting database") != 1 {
+		t.Fatalf("response = %d %q, want a single 409", rec.Code, rec.Body.String())
+	}
+
+	var b strings.Builder
+	if _, err := metrics.Default.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := `http_requests_total{method="GET",route="/v1/metrics-error-test",status="409"} 1`; !strings.Contains(b.String(), want) {
+		t.Fatalf("scrape is missing %q:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepEvery is how often idle limiters are looked for
+const rateLimitSweepEvery = time.Minute
+
+// RateLimiter is a token bucket per client, keyed by the authenticated user or else by client IP
+type RateLimiter struct {
+	Name  string
+	Limit rate.Limit
+	Burst int
+	// Idle is how long a client may go without requests before its bucket is dropped
+	Idle time.Duration
+
+	lock      sync.Mutex
+	clients   map[string]*rateLimitClient
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewRateLimiter(name string, limit rate.Limit, burst int, idle time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Name:    name,
+		Limit:   limit,
+		Burst:   burst,
+		Idle:    idle,
+		clients: make(map[string]*rateLimitClient),
+		now:     time.Now,
+	}
+}
+
+// Middleware reject the request with 429 once the client bucket is empty, every answer carry
+// the X-RateLimit-* headers of the bucket
+func (r *RateLimiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		allowed, remaining, reset, retryAfter := r.take(rateLimitKey(e))
+
+		header := e.Response().Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(r.Burst))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(reset)))
+
+		if !allowed {
+			header.Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+		}
+		return next(e)
+	}
+}
+
+// take spend one token of the client bucket, reporting the tokens left, how long until the
+// bucket is full again and, when refused, how long until a token is available
+func (r *RateLimiter) take(key string) (bool, int, time.Duration, time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	client, ok := r.clients[key]
+	if !ok {
+		client = &rateLimitClient{limiter: rate.NewLimiter(r.Limit, r.Burst)}
+		r.clients[key] = client
+	}
+	client.lastSeen = now
+
+	var retryAfter time.Duration
+	reservation := client.limiter.ReserveN(now, 1)
+	allowed := reservation.OK()
+	if allowed {
+		retryAfter = reservation.DelayFrom(now)
+		if retryAfter > 0 {
+			reservation.CancelAt(now)
+			allowed = false
+		}
+	}
+
+	tokens := client.limiter.TokensAt(now)
+	remaining := max(int(math.Floor(tokens)), 0)
+
+	var reset time.Duration
+	if r.Limit > 0 {
+		reset = time.Duration((float64(r.Burst) - tokens) / float64(r.Limit) * float64(time.Second))
+	}
+
+	return allowed, remaining, reset, retryAfter
+}
+
+// sweep drop the buckets of clients idle for longer than Idle, at most once per rateLimitSweepEvery
+func (r *RateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < rateLimitSweepEvery {
+		return
+	}
+	r.lastSweep = now
+
+	for key, client := range r.clients {
+		if now.Sub(client.lastSeen) > r.Idle {
+			delete(r.clients, key)
+		}
+	}
+}
+
+// rateLimitKey share the bucket of an authenticated user across IPs, anonymous clients are
+// told apart by the IP that echo.IPExtractor trust
+func rateLimitKey(e echo.Context) string {
+	user := ContextGetUser(e)
+	if !user.IsAnonymous() {
+		return fmt.Sprintf("user:%d", user.Id)
+	}
+	return "ip:" + e.RealIP()
+}
+
+// RateLimit apply the named limiter, a group without limiter is not limited
+func (m *Middleware) RateLimit(name string) echo.MiddlewareFunc {
+	limiter, ok := m.RateLimiters[name]
+	if !ok {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+	return limiter.Middleware
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
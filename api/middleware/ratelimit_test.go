@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+	"music-echo/api/domain/dao"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+func newTestRateLimiter(limit rate.Limit, burst int) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter("test", limit, burst, 10*time.Minute)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func serve(limiter *RateLimiter, remoteAddr string, user *dao.Users) *httptest.ResponseRecorder {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if user != nil {
+		c.Set(userContextKey, user)
+	}
+
+	err := limiter.Middleware(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})(c)
+	if err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func TestRateLimiterBurstThenRefuse(t *testing.T) {
+	limiter, clock := newTestRateLimiter(1, 2)
+
+	for i := 0; i < 2; i++ {
+		rec := serve(limiter, "10.0.0.1:1234", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, rec.Code)
+		}
+	}
+
+	rec := serve(limiter, "10.0.0.1:1234", nil)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("X-RateLimit-Limit = %q, want 2", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Another client has its own bucket
+	rec = serve(limiter, "10.0.0.2:1234", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("other client status = %d, want 200", rec.Code)
+	}
+
+	// A token is back after a second
+	clock.Advance(time.Second)
+	rec = serve(limiter, "10.0.0.1:1234", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("after refill status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterKeyByUser(t *testing.T) {
+	limiter, _ := newTestRateLimiter(1, 1)
+	user := &dao.Users{Id: 7}
+
+	rec := serve(limiter, "10.0.0.1:1234", user)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	// Same user from another IP share the bucket
+	rec = serve(limiter, "10.0.0.2:1234", user)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+}
+
+func TestRateLimiterEvictIdle(t *testing.T) {
+	limiter, clock := newTestRateLimiter(1, 1)
+
+	serve(limiter, "10.0.0.1:1234", nil)
+	clock.Advance(5 * time.Minute)
+	serve(limiter, "10.0.0.2:1234", nil)
+	if len(limiter.clients) != 2 {
+		t.Fatalf("clients = %d, want 2", len(limiter.clients))
+	}
+
+	clock.Advance(6 * time.Minute)
+	serve(limiter, "10.0.0.3:1234", nil)
+	if _, ok := limiter.clients["ip:10.0.0.1"]; ok {
+		t.Error("idle client was not evicted")
+	}
+	if len(limiter.clients) != 2 {
+		t.Errorf("clients = %d, want 2", len(limiter.clients))
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"music-echo/utils"
+	"net/http"
+	"time"
+)
+
+// requestIDMaxLength bound the X-Request-Id a client or proxy may hand us
+const requestIDMaxLength = 64
+
+// RequestID reuse the X-Request-Id of the request when it look sane or generate one, echo it in
+// the response and put it in the request context so every log of the request carry it
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		req := e.Request()
+		id := req.Header.Get(echo.HeaderXRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		e.Response().Header().Set(echo.HeaderXRequestID, id)
+		e.SetRequest(req.WithContext(utils.WithRequestID(req.Context(), id)))
+		return next(e)
+	}
+}
+
+// RequestLogger write one record per request, the query string and headers are left out since
+// they may carry credentials. The error is returned once written for Tracing to record it
+func RequestLogger(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		start := time.Now()
+		err := next(e)
+		if err != nil {
+			e.Error(err)
+		}
+
+		req := e.Request()
+		res := e.Response()
+		level := slog.LevelInfo
+		switch {
+		case res.Status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case res.Status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("route", e.Path()),
+			slog.String("path", req.URL.Path),
+			slog.Int("status", res.Status),
+			slog.Int64("bytes", res.Size),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", e.RealIP()),
+		}
+		if user := ContextGetUser(e); !user.IsAnonymous() {
+			attrs = append(attrs, slog.Int64("user_id", user.Id))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		slog.LogAttrs(req.Context(), level, "request", attrs...)
+		return err
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"music-echo/utils/tracing"
+	"net/http"
+)
+
+// Tracing open a server span per request named after the route pattern, ex: GET /v1/tracks/:tracksId,
+// it continue the trace of an incoming traceparent header
+func Tracing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(e echo.Context) error {
+		req := e.Request()
+		ctx := req.Context()
+		if parent, ok := tracing.ParseTraceparent(req.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		route := e.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Start(ctx, req.Method+" "+route,
+			tracing.String("http.method", req.Method),
+			tracing.String("http.route", route),
+			tracing.String("http.target", req.URL.Path),
+		)
+		span.SetKind(tracing.KindServer)
+		defer span.End()
+		e.SetRequest(req.WithContext(ctx))
+
+		err := next(e)
+		if err != nil {
+			e.Error(err)
+		}
+
+		status := e.Response().Status
+		span.SetAttributes(tracing.Int("http.status_code", int64(status)))
+		if status >= http.StatusInternalServerError {
+			span.RecordError(err)
+			if err == nil {
+				span.RecordError(echo.NewHTTPError(status))
+			}
+		}
+		return nil
+	}
+}
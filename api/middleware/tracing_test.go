@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"music-echo/utils/tracing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useInMemoryTracer(t *testing.T) *tracing.InMemoryExporter {
+	exporter := tracing.NewInMemoryExporter()
+	previous := tracing.Default()
+	tracing.SetDefault(tracing.NewTracer(exporter))
+	t.Cleanup(func() { tracing.SetDefault(previous) })
+	return exporter
+}
+
+func TestTracingSpanTree(t *testing.T) {
+	exporter := useInMemoryTracer(t)
+
+	e := echo.New()
+	e.Use(Tracing)
+	e.GET("/v1/tracks/:tracksId", func(c echo.Context) error {
+		ctx, span := tracing.Start(c.Request().Context(), "tracks.GetId")
+		_, child := tracing.Start(ctx, "likes.CountLikes")
+		child.End()
+		span.SetRows(1)
+		span.End()
+		return echo.NewHTTPError(http.StatusInternalServerError, "conflicting database")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/tracks/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	want := "GET /v1/tracks/:tracksId\n  tracks.GetId\n    likes.CountLikes\n"
+	if got := exporter.Tree(); got != want {
+		t.Fatalf("tree =\n%s\nwant\n%s", got, want)
+	}
+
+	spans := exporter.Spans()
+	server := spans[len(spans)-1]
+	if server.Kind != tracing.KindServer || server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span = %+v", server)
+	}
+	if server.Error == "" {
+		t.Fatal("500 answer not recorded as an error")
+	}
+
+	var status any
+	for _, attr := range server.Attributes {
+		if attr.Key == "http.status_code" {
+			status = attr.Value
+		}
+	}
+	if status != int64(http.StatusInternalServerError) {
+		t.Fatalf("http.status_code = %v, want 500", status)
+	}
+}
+
+func TestTracingRecordErrorThroughLoggerAndMetrics(t *testing.T) {
+	exporter := useInMemoryTracer(t)
+
+	e := echo.New()
+	e.Use(Tracing)
+	e.Use(RequestLogger)
+	e.Use(Metrics)
+	e.GET("/v1/tracing-error-test", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusInternalServerError, "conflicting database")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/tracing-error-test", nil))
+
+	// The handler error, not one rebuilt from the status
+	spans := exporter.Spans()
+	if server := spans[len(spans)-1]; !strings.Contains(server.Error, "conflicting database") {
+		t.Fatalf("server span error = %q, want the handler error", server.Error)
+	}
+	if rec.Code != http.StatusInternalServerError || strings.Count(rec.Body.String(), "conflicting database") != 1 {
+		t.Fatalf("response = %d %q, want a single 500", rec.Code, rec.Body.String())
+	}
+}
@@ -3,12 +3,14 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"music-echo/api/domain/dao"
 )
 
 type ArtistRepository interface {
 	GetByName(ctx context.Context, name string) (*dao.Artists, error)
 	GetById(ctx context.Context, id int64) (*dao.Artists, error)
+	UpdateImage(ctx context.Context, id int64, hash string) error
 }
 
 type ArtistRepositoryImpl struct {
@@ -20,11 +22,14 @@ func NewArtistRepositoryImpl(db *sql.DB) ArtistRepository {
 }
 
 func (a ArtistRepositoryImpl) GetByName(ctx context.Context, name string) (*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "artist.GetByName")
+	defer span.End()
+
 	var artist dao.Artists
-	script := "SELECT * FROM artist WHERE name=$1"
+	script := "SELECT id, name, COALESCE(image_hash, '') FROM artist WHERE name=$1"
 	args := []any{name}
-	row := a.Db.QueryRowContext(ctx, script, args...)
-	err := row.Scan(&artist.Id, &artist.Name)
+	row := conn(ctx, a.Db).QueryRowContext(ctx, script, args...)
+	err := row.Scan(&artist.Id, &artist.Name, &artist.ImageHash)
 	if err != nil {
 		return nil, err
 	}
@@ -33,14 +38,40 @@ func (a ArtistRepositoryImpl) GetByName(ctx context.Context, name string) (*dao.
 }
 
 func (a ArtistRepositoryImpl) GetById(ctx context.Context, id int64) (*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "artist.GetById")
+	defer span.End()
+
 	var artist dao.Artists
-	script := "SELECT * FROM artist WHERE id=$1"
+	script := "SELECT id, name, COALESCE(image_hash, '') FROM artist WHERE id=$1"
 	args := []any{id}
-	row := a.Db.QueryRowContext(ctx, script, args...)
-	err := row.Scan(&artist.Id, &artist.Name)
+	row := conn(ctx, a.Db).QueryRowContext(ctx, script, args...)
+	err := row.Scan(&artist.Id, &artist.Name, &artist.ImageHash)
 	if err != nil {
 		return nil, err
 	}
 
+	span.SetRows(1)
 	return &artist, nil
 }
+
+func (a ArtistRepositoryImpl) UpdateImage(ctx context.Context, id int64, hash string) error {
+	ctx, span := startQuery(ctx, "artist.UpdateImage")
+	defer span.End()
+
+	script := "UPDATE artist SET image_hash=$1 WHERE id=$2"
+	row, err := conn(ctx, a.Db).ExecContext(ctx, script, hash, id)
+	if err != nil {
+		return err
+	}
+
+	rowAffected, err := row.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowAffected == 0 {
+		return errors.New("artist doesnt exist")
+	}
+
+	span.SetRows(int(rowAffected))
+	return nil
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/utils"
+	"music-echo/utils/tracing"
+)
+
+type AuditRepository interface {
+	Insert(ctx context.Context, event *dao.AuditEvent) error
+	GetAll(ctx context.Context, filters dto.AuditFilters, paginating utils.Paginatings) ([]*dao.AuditEvent, int64, error)
+}
+
+type AuditRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewAuditRepositoryImpl(db *sql.DB) AuditRepository {
+	return &AuditRepositoryImpl{Db: db}
+}
+
+// Insert record the event, run it within the transaction of the action it audit so neither is
+// kept without the other
+func (a AuditRepositoryImpl) Insert(ctx context.Context, event *dao.AuditEvent) error {
+	ctx, span := startQuery(ctx, "audit.Insert")
+	defer span.End()
+
+	if event.Details == nil {
+		event.Details = json.RawMessage(`{}`)
+	}
+	if event.Diff == nil {
+		event.Diff = json.RawMessage(`{}`)
+	}
+
+	script := `
+		INSERT INTO audit_events (actor_id, action, entity, entity_id, version_before, version_after, details, diff)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+	args := []any{
+		event.ActorId, event.Action, event.Entity, event.EntityId,
+		event.VersionBefore, event.VersionAfter, []byte(event.Details), []byte(event.Diff),
+	}
+	return conn(ctx, a.Db).QueryRowContext(ctx, script, args...).Scan(&event.Id, &event.CreatedAt)
+}
+
+// GetAll return the events matching the filters, latest first
+func (a AuditRepositoryImpl) GetAll(ctx context.Context, filters dto.AuditFilters, paginating utils.Paginatings) ([]*dao.AuditEvent, int64, error) {
+	ctx, span := startQuery(ctx, "audit.GetAll",
+		tracing.String("filter.entity", filters.Entity),
+		tracing.Bool("filter.actor", filters.ActorId != 0),
+		tracing.Int("page_size", paginating.PageSize),
+	)
+	defer span.End()
+
+	script := `
+		SELECT COUNT(*) OVER(), id, created_at, COALESCE(actor_id, 0), action, entity, entity_id,
+		       version_before, version_after, details, diff
+		FROM audit_events
+		WHERE (entity = $1 OR $1 = '')
+				AND (entity_id = $2 OR $2 = 0)
+				AND (actor_id = $3 OR $3 = 0)
+				AND (created_at >= $4 OR $4 IS NULL)
+				AND (created_at < $5 OR $5 IS NULL)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6 OFFSET $7`
+	args := []any{
+		filters.Entity, filters.EntityId, filters.ActorId,
+		sql.NullTime{Time: filters.From, Valid: !filters.From.IsZero()},
+		sql.NullTime{Time: filters.To, Valid: !filters.To.IsZero()},
+		paginating.Limit(), paginating.Offset(),
+	}
+	rows, err := conn(ctx, a.Db).QueryContext(ctx, script, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []*dao.AuditEvent
+	var totalRecords int64
+	for rows.Next() {
+		var event dao.AuditEvent
+		err = rows.Scan(
+			&totalRecords,
+			&event.Id,
+			&event.CreatedAt,
+			&event.ActorId,
+			&event.Action,
+			&event.Entity,
+			&event.EntityId,
+			&event.VersionBefore,
+			&event.VersionAfter,
+			(*[]byte)(&event.Details),
+			(*[]byte)(&event.Diff),
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	span.SetRows(len(events))
+	return events, totalRecords, nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+	"time"
+)
+
+// ChartWindows map each chart window to the length of its period
+var ChartWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// ChartLikeWeight is how many plays a like is worth in a chart score
+const ChartLikeWeight = 5
+
+type ChartsRepository interface {
+	Refresh(ctx context.Context, window string, now time.Time) error
+	Get(ctx context.Context, window string, genre string, limit int) ([]*dao.ChartEntry, []*dao.Tracks, []*dao.Artists, error)
+}
+
+type ChartsRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewChartsRepositoryImpl(db *sql.DB) ChartsRepository {
+	return &ChartsRepositoryImpl{Db: db}
+}
+
+// Refresh score every track played or liked over the window ending now, and over the window
+// before it, into a new snapshot then drop the older snapshots of that window. Both run in one
+// transaction, the caller's when there is one
+func (c ChartsRepositoryImpl) Refresh(ctx context.Context, window string, now time.Time) error {
+	ctx, span := startQuery(ctx, "charts.Refresh")
+	defer span.End()
+
+	return TxManagerImpl{Db: c.Db}.WithinTx(ctx, func(ctx context.Context) error {
+		db := conn(ctx, c.Db)
+
+		script := `
+			WITH period AS (
+				SELECT $2::timestamptz AS period_end,
+					   $2::timestamptz - $3 * INTERVAL '1 second' AS period_start,
+					   $2::timestamptz - 2 * $3 * INTERVAL '1 second' AS previous_start
+			), plays_count AS (
+				SELECT	p.id_tracks,
+						COUNT(*) FILTER (WHERE p.played_at > period.period_start) AS current,
+						COUNT(*) FILTER (WHERE p.played_at <= period.period_start) AS previous
+				FROM plays p, period
+				WHERE p.played_at > period.previous_start AND p.played_at <= period.period_end
+				GROUP BY p.id_tracks
+			), likes_count AS (
+				SELECT	l.id_tracks,
+						COUNT(*) FILTER (WHERE l.created_at > period.period_start) AS current,
+						COUNT(*) FILTER (WHERE l.created_at <= period.period_start) AS previous
+				FROM likes l, period
+				WHERE l.created_at > period.previous_start AND l.created_at <= period.period_end
+				GROUP BY l.id_tracks
+			)
+			INSERT INTO chart_snapshots (chart_window, computed_at, id_tracks, score, previous_score)
+			SELECT	$1, $2, t.id,
+					COALESCE(p.current, 0) + $4 * COALESCE(l.current, 0),
+					COALESCE(p.previous, 0) + $4 * COALESCE(l.previous, 0)
+			FROM tracks t
+				LEFT JOIN plays_count p ON p.id_tracks = t.id
+				LEFT JOIN likes_count l ON l.id_tracks = t.id
+			WHERE p.id_tracks IS NOT NULL OR l.id_tracks IS NOT NULL
+			ON CONFLICT DO NOTHING
+		`
+		args := []any{window, now, ChartWindows[window].Seconds(), ChartLikeWeight}
+		_, err := db.ExecContext(ctx, script, args...)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `DELETE FROM chart_snapshots WHERE chart_window=$1 AND computed_at < $2`, window, now)
+		return err
+	})
+}
+
+// Get rank the latest snapshot of a window, optionally within a genre and its descendants,
+// the previous rank is ranked the same way over the previous period so the two stay comparable
+func (c ChartsRepositoryImpl) Get(ctx context.Context, window string, genre string, limit int) ([]*dao.ChartEntry, []*dao.Tracks, []*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "charts.Get")
+	defer span.End()
+
+	script := `
+		WITH snapshot AS (
+			SELECT s.id_tracks, s.score, s.previous_score, s.computed_at
+			FROM chart_snapshots s
+				JOIN tracks t ON t.id = s.id_tracks
+			WHERE s.chart_window=$1
+			  AND s.computed_at = (SELECT MAX(computed_at) FROM chart_snapshots WHERE chart_window=$1)
+			  AND ($2 = '' OR t.genre && genre_descendants($2))
+		), current_rank AS (
+			SELECT id_tracks, score, computed_at, ROW_NUMBER() OVER (ORDER BY score DESC, id_tracks) AS rank
+			FROM snapshot
+			WHERE score > 0
+		), previous_rank AS (
+			SELECT id_tracks, ROW_NUMBER() OVER (ORDER BY previous_score DESC, id_tracks) AS rank
+			FROM snapshot
+			WHERE previous_score > 0
+		)
+		SELECT	c.rank, p.rank, c.score, c.computed_at,
+				t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version, COALESCE(t.image_hash, ''), t.play_count,
+				a.id, a.name, COALESCE(a.image_hash, '')
+		FROM current_rank c
+			JOIN tracks t ON t.id = c.id_tracks
+			JOIN artist a ON a.id = t.idartist
+			LEFT JOIN previous_rank p ON p.id_tracks = c.id_tracks
+		ORDER BY c.rank
+		LIMIT $3
+	`
+	rows, err := conn(ctx, c.Db).QueryContext(ctx, script, window, genre, limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	var entries []*dao.ChartEntry
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for rows.Next() {
+		var entry dao.ChartEntry
+		var track dao.Tracks
+		var artist dao.Artists
+		var previousRank sql.NullInt64
+		err = rows.Scan(
+			&entry.Rank,
+			&previousRank,
+			&entry.Score,
+			&entry.ComputedAt,
+			&track.Id,
+			&track.CreatedAt,
+			&track.IdArtist,
+			&track.Title,
+			&track.Duration,
+			&track.Year,
+			pq.Array(&track.Genre),
+			&track.Version,
+			&track.ImageHash,
+			&track.PlayCount,
+			&artist.Id,
+			&artist.Name,
+			&artist.ImageHash,
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if previousRank.Valid {
+			delta := previousRank.Int64 - entry.Rank
+			entry.PreviousRank = &previousRank.Int64
+			entry.Delta = &delta
+		}
+		entries = append(entries, &entry)
+		tracks = append(tracks, &track)
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	span.SetRows(len(entries))
+	return entries, tracks, artists, nil
+}
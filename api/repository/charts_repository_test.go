@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChartsRefreshSnapshotThenPruneWithinTx(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	now := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+
+	if err := NewChartsRepositoryImpl(db).Refresh(context.Background(), "week", now); err != nil {
+		t.Fatal(err)
+	}
+	assertAllInTx(t, r, 2, 1, 0)
+	if args := r.args[0]; !strings.Contains(r.statements[0], "INSERT INTO chart_snapshots") || args[1] != now || args[2] != ChartWindows["week"].Seconds() {
+		t.Fatalf("snapshot = %q %v, want the week ending now", r.statements[0], args)
+	}
+	// Only the snapshots of the refreshed window older than the new one are dropped
+	if args := r.args[1]; !strings.Contains(r.statements[1], "DELETE FROM chart_snapshots WHERE chart_window=$1 AND computed_at < $2") || args[0] != "week" || args[1] != now {
+		t.Fatalf("prune = %q %v, want the week snapshots before now", r.statements[1], args)
+	}
+}
+
+func TestChartsRefreshKeepSnapshotsWhenSnapshotFail(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO chart_snapshots")
+
+	if err := NewChartsRepositoryImpl(db).Refresh(context.Background(), "day", time.Now()); err == nil {
+		t.Fatal("snapshot failure was not returned")
+	}
+	assertAllInTx(t, r, 1, 0, 1)
+}
+
+func TestChartsRefreshJoinOuter(t *testing.T) {
+	db, r := openRecordDB(t, "")
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		if err := NewChartsRepositoryImpl(db).Refresh(ctx, "day", time.Now()); err != nil {
+			return err
+		}
+		return errors.New("outer failure")
+	})
+	if err == nil || err.Error() != "outer failure" {
+		t.Fatalf("err = %v, want outer failure", err)
+	}
+	assertAllInTx(t, r, 2, 0, 1)
+}
+
+// chartRow is a row of the charts.Get query, previousRank is nil for a track new to the chart
+func chartRow(rank int64, previousRank any, trackId int64) []driver.Value {
+	return []driver.Value{rank, previousRank, int64(10), time.Now(),
+		trackId, time.Now(), int64(3), "Title", int64(200), int64(1997), []byte("{rock}"), int64(1), "", int64(0),
+		int64(3), "Artist", ""}
+}
+
+func TestChartsGetRankDeltas(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	r.rows = map[string][][]driver.Value{"FROM current_rank c": {
+		chartRow(1, int64(3), 7),
+		chartRow(2, int64(1), 8),
+		chartRow(3, int64(3), 9),
+		chartRow(4, nil, 10),
+	}}
+
+	entries, tracks, _, err := NewChartsRepositoryImpl(db).Get(context.Background(), "week", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || tracks[0].Id != 7 || tracks[0].Genre[0] != "rock" {
+		t.Fatalf("entries = %d, tracks[0] = %+v", len(entries), tracks[0])
+	}
+
+	// Climbing is a positive delta, a new entry has neither a previous rank nor a delta
+	for i, want := range []int64{2, -1, 0} {
+		if entries[i].Delta == nil || *entries[i].Delta != want {
+			t.Errorf("entries[%d] delta = %v, want %d", i, entries[i].Delta, want)
+		}
+	}
+	if entries[3].PreviousRank != nil || entries[3].Delta != nil {
+		t.Errorf("new entry = %+v, want no previous rank", entries[3])
+	}
+
+	// Both ranks are taken from the latest snapshot of the window
+	if !strings.Contains(r.statements[0], "s.computed_at = (SELECT MAX(computed_at) FROM chart_snapshots WHERE chart_window=$1)") {
+		t.Fatalf("charts = %q, want the latest snapshot", r.statements[0])
+	}
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+)
+
+type FollowsRepository interface {
+	Follow(ctx context.Context, userId int64, artistId int64) (bool, error)
+	Unfollow(ctx context.Context, userId int64, artistId int64) (bool, error)
+	CountFollowers(ctx context.Context, artistId int64) (int64, error)
+	IsFollowing(ctx context.Context, userId int64, artistId int64) (bool, error)
+	Feed(ctx context.Context, userId int64, before *dao.Tracks, limit int) ([]*dao.Tracks, []*dao.Artists, error)
+}
+
+type FollowsRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewFollowsRepositoryImpl(db *sql.DB) FollowsRepository {
+	return &FollowsRepositoryImpl{Db: db}
+}
+
+// Follow report whether the follow is new, following twice is not an error
+func (f FollowsRepositoryImpl) Follow(ctx context.Context, userId int64, artistId int64) (bool, error) {
+	ctx, span := startQuery(ctx, "follows.Follow")
+	defer span.End()
+
+	script := `
+		INSERT INTO follows (id_users, id_artist)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	row, err := conn(ctx, f.Db).ExecContext(ctx, script, userId, artistId)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return false, errors.New("artist doesnt exist")
+		}
+		return false, err
+	}
+
+	rowAffected, err := row.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	span.SetRows(int(rowAffected))
+	return rowAffected > 0, nil
+}
+
+// Unfollow report whether there was a follow to remove
+func (f FollowsRepositoryImpl) Unfollow(ctx context.Context, userId int64, artistId int64) (bool, error) {
+	ctx, span := startQuery(ctx, "follows.Unfollow")
+	defer span.End()
+
+	script := "DELETE FROM follows WHERE id_users=$1 AND id_artist=$2"
+	row, err := conn(ctx, f.Db).ExecContext(ctx, script, userId, artistId)
+	if err != nil {
+		return false, err
+	}
+
+	rowAffected, err := row.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	span.SetRows(int(rowAffected))
+	return rowAffected > 0, nil
+}
+
+func (f FollowsRepositoryImpl) CountFollowers(ctx context.Context, artistId int64) (int64, error) {
+	ctx, span := startQuery(ctx, "follows.CountFollowers")
+	defer span.End()
+
+	var followers int64
+	script := "SELECT COUNT(*) FROM follows WHERE id_artist=$1"
+	err := conn(ctx, f.Db).QueryRowContext(ctx, script, artistId).Scan(&followers)
+	if err != nil {
+		return -1, err
+	}
+	span.SetRows(1)
+	return followers, nil
+}
+
+func (f FollowsRepositoryImpl) IsFollowing(ctx context.Context, userId int64, artistId int64) (bool, error) {
+	ctx, span := startQuery(ctx, "follows.IsFollowing")
+	defer span.End()
+
+	var following bool
+	script := "SELECT EXISTS (SELECT 1 FROM follows WHERE id_users=$1 AND id_artist=$2)"
+	err := conn(ctx, f.Db).QueryRowContext(ctx, script, userId, artistId).Scan(&following)
+	if err != nil {
+		return false, err
+	}
+	span.SetRows(1)
+	return following, nil
+}
+
+// Feed list the tracks of followed artists newest first, credited appearances included,
+// keyset paged on (created_at, id) after the given track
+func (f FollowsRepositoryImpl) Feed(ctx context.Context, userId int64, before *dao.Tracks, limit int) ([]*dao.Tracks, []*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "follows.Feed")
+	defer span.End()
+
+	var beforeAt sql.NullTime
+	var beforeId int64
+	if before != nil {
+		beforeAt = sql.NullTime{Time: before.CreatedAt, Valid: true}
+		beforeId = before.Id
+	}
+
+	script := `
+		SELECT	t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version, COALESCE(t.image_hash, ''), t.play_count,
+				a.id, a.name, COALESCE(a.image_hash, '')
+		FROM tracks t
+			JOIN artist a ON a.id = t.idartist
+		WHERE (t.idartist IN (SELECT id_artist FROM follows WHERE id_users=$1)
+			OR EXISTS (
+				SELECT 1
+				FROM track_credits c
+					JOIN follows fo ON fo.id_artist = c.id_artist AND fo.id_users=$1
+				WHERE c.id_tracks = t.id
+			))
+		  AND ($2::timestamptz IS NULL OR (t.created_at, t.id) < ($2, $3))
+		ORDER BY t.created_at DESC, t.id DESC
+		LIMIT $4
+	`
+	rows, err := conn(ctx, f.Db).QueryContext(ctx, script, userId, beforeAt, beforeId, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for rows.Next() {
+		var track dao.Tracks
+		var artist dao.Artists
+		err = rows.Scan(
+			&track.Id,
+			&track.CreatedAt,
+			&track.IdArtist,
+			&track.Title,
+			&track.Duration,
+			&track.Year,
+			pq.Array(&track.Genre),
+			&track.Version,
+			&track.ImageHash,
+			&track.PlayCount,
+			&artist.Id,
+			&artist.Name,
+			&artist.ImageHash,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		tracks = append(tracks, &track)
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	span.SetRows(len(tracks))
+	return tracks, artists, nil
+}
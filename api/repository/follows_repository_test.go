@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowsFollowTwice(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	follows := NewFollowsRepositoryImpl(db)
+
+	r.affected = map[string]int64{"INSERT INTO follows": 1}
+	followed, err := follows.Follow(context.Background(), 9, 3)
+	if err != nil || !followed {
+		t.Fatalf("followed = %t err = %v, want a new follow", followed, err)
+	}
+
+	// The second follow hit the conflict and insert nothing
+	r.affected = nil
+	followed, err = follows.Follow(context.Background(), 9, 3)
+	if err != nil || followed {
+		t.Fatalf("followed = %t err = %v, want no error and no new follow", followed, err)
+	}
+	if !strings.Contains(r.statements[1], "ON CONFLICT DO NOTHING") {
+		t.Fatalf("follow = %q, want a duplicate follow ignored", r.statements[1])
+	}
+}
+
+func TestFollowsUnfollowTwice(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	follows := NewFollowsRepositoryImpl(db)
+
+	r.affected = map[string]int64{"DELETE FROM follows": 1}
+	unfollowed, err := follows.Unfollow(context.Background(), 9, 3)
+	if err != nil || !unfollowed {
+		t.Fatalf("unfollowed = %t err = %v, want the follow removed", unfollowed, err)
+	}
+
+	r.affected = nil
+	unfollowed, err = follows.Unfollow(context.Background(), 9, 3)
+	if err != nil || unfollowed {
+		t.Fatalf("unfollowed = %t err = %v, want no error and nothing removed", unfollowed, err)
+	}
+}
+
+func TestFollowsFollowUnknownArtist(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO follows")
+	r.failErr = &pq.Error{Code: "23503", Constraint: "fk_artist_follows"}
+
+	_, err := NewFollowsRepositoryImpl(db).Follow(context.Background(), 9, 99)
+	if err == nil || err.Error() != "artist doesnt exist" {
+		t.Fatalf("err = %v, want artist doesnt exist", err)
+	}
+}
+
+// feedRow is a row of the follows.Feed query
+func feedRow(trackId int64, createdAt time.Time, artistId int64) []driver.Value {
+	return []driver.Value{trackId, createdAt, artistId, "Title", int64(200), int64(1997), []byte("{rock}"), int64(1), "", int64(0),
+		artistId, "Artist", ""}
+}
+
+func TestFollowsFeed(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.rows = map[string][][]driver.Value{"FROM tracks t": {
+		feedRow(8, createdAt, 3),
+		feedRow(7, createdAt, 4),
+	}}
+	follows := NewFollowsRepositoryImpl(db)
+
+	tracks, artists, err := follows.Feed(context.Background(), 9, nil, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 || tracks[1].Id != 7 || artists[1].Id != 4 {
+		t.Fatalf("tracks = %v artists = %v", tracks, artists)
+	}
+	// Tracks of followed artists and tracks they are credited on
+	if !strings.Contains(r.statements[0], "t.idartist IN (SELECT id_artist FROM follows WHERE id_users=$1)") ||
+		!strings.Contains(r.statements[0], "JOIN follows fo ON fo.id_artist = c.id_artist AND fo.id_users=$1") {
+		t.Fatalf("feed = %q, want own and credited tracks of the followed artists", r.statements[0])
+	}
+	if args := r.args[0]; args[0] != int64(9) || args[1] != nil || args[3] != int64(20) {
+		t.Fatalf("first page args = %v, want no keyset", args)
+	}
+
+	if _, _, err = follows.Feed(context.Background(), 9, &dao.Tracks{Id: 7, CreatedAt: createdAt}, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.statements[1], "(t.created_at, t.id) < ($2, $3)") || !strings.Contains(r.statements[1], "ORDER BY t.created_at DESC, t.id DESC") {
+		t.Fatalf("feed = %q, want keyset paged newest first", r.statements[1])
+	}
+	if args := r.args[1]; args[1] != createdAt || args[2] != int64(7) {
+		t.Fatalf("next page args = %v, want after the track created at %v with id 7", args, createdAt)
+	}
+}
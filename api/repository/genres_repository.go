@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+)
+
+type GenresRepository interface {
+	GetAll(ctx context.Context) ([]*dao.Genre, error)
+	Resolve(ctx context.Context, genres []string) (map[string]string, error)
+}
+
+type GenresRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewGenresRepositoryImpl(db *sql.DB) GenresRepository {
+	return &GenresRepositoryImpl{Db: db}
+}
+
+// GetAll list every genre, the track count include the tracks of its descendants
+func (g GenresRepositoryImpl) GetAll(ctx context.Context) ([]*dao.Genre, error) {
+	ctx, span := startQuery(ctx, "genres.GetAll")
+	defer span.End()
+
+	script := `
+		SELECT	g.id, g.slug, g.name, p.slug, g.aliases,
+				(SELECT COUNT(*) FROM tracks t WHERE t.genre && genre_descendants(g.slug))
+		FROM genres g
+			LEFT JOIN genres p ON p.id = g.parent_id
+		ORDER BY g.slug
+	`
+	rows, err := conn(ctx, g.Db).QueryContext(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []*dao.Genre
+	for rows.Next() {
+		var genre dao.Genre
+		var parent sql.NullString
+		err = rows.Scan(&genre.Id, &genre.Slug, &genre.Name, &parent, pq.Array(&genre.Aliases), &genre.TrackCount)
+		if err != nil {
+			return nil, err
+		}
+		if parent.Valid {
+			genre.Parent = &parent.String
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(genres))
+	return genres, nil
+}
+
+// Resolve map each given slug, display name or alias to its slug, unknown genres are left out
+func (g GenresRepositoryImpl) Resolve(ctx context.Context, genres []string) (map[string]string, error) {
+	ctx, span := startQuery(ctx, "genres.Resolve")
+	defer span.End()
+
+	script := `
+		SELECT raw, genre_slug(raw)
+		FROM unnest($1::text[]) AS raw
+		WHERE genre_slug(raw) IS NOT NULL
+	`
+	rows, err := conn(ctx, g.Db).QueryContext(ctx, script, pq.Array(genres))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slugs := make(map[string]string, len(genres))
+	for rows.Next() {
+		var raw, slug string
+		err = rows.Scan(&raw, &slug)
+		if err != nil {
+			return nil, err
+		}
+		slugs[raw] = slug
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(slugs))
+	return slugs, nil
+}
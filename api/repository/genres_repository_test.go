@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestGenresResolve(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	r.rows = map[string][][]driver.Value{"genre_slug(raw)": {
+		{"Hip Hop", "hip-hop"},
+		{"rock", "rock"},
+	}}
+
+	slugs, err := NewGenresRepositoryImpl(db).Resolve(context.Background(), []string{"Hip Hop", "rock", "vaporwave"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slugs) != 2 || slugs["Hip Hop"] != "hip-hop" || slugs["rock"] != "rock" {
+		t.Fatalf("slugs = %v, want the known genres only", slugs)
+	}
+	// Every genre is resolved in a single query
+	if len(r.statements) != 1 || r.args[0][0] != `{"Hip Hop","rock","vaporwave"}` {
+		t.Fatalf("statements = %q args = %v, want one query over every genre", r.statements, r.args)
+	}
+}
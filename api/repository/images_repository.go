@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"music-echo/api/domain/dao"
+)
+
+type ImagesRepository interface {
+	Insert(ctx context.Context, image *dao.Image) (bool, error)
+	Get(ctx context.Context, hash string, size string) (*dao.Image, error)
+}
+
+type ImagesRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewImagesRepositoryImpl(db *sql.DB) ImagesRepository {
+	return &ImagesRepositoryImpl{Db: db}
+}
+
+// Insert store an image rendition, images are keyed by content hash so the same
+// upload twice is reported as not inserted rather than an error
+func (i ImagesRepositoryImpl) Insert(ctx context.Context, image *dao.Image) (bool, error) {
+	ctx, span := startQuery(ctx, "images.Insert")
+	defer span.End()
+
+	script := `
+		INSERT INTO images (hash, size, location, content_type, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (hash, size) DO NOTHING
+		RETURNING created_at
+	`
+	args := []any{image.Hash, image.Size, image.Location, image.ContentType, image.Width, image.Height}
+	err := conn(ctx, i.Db).QueryRowContext(ctx, script, args...).Scan(&image.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (i ImagesRepositoryImpl) Get(ctx context.Context, hash string, size string) (*dao.Image, error) {
+	ctx, span := startQuery(ctx, "images.Get")
+	defer span.End()
+
+	var image dao.Image
+	script := `
+		SELECT hash, size, location, content_type, width, height, created_at
+		FROM images
+		WHERE hash=$1 AND size=$2
+	`
+	err := conn(ctx, i.Db).QueryRowContext(ctx, script, hash, size).Scan(
+		&image.Hash,
+		&image.Size,
+		&image.Location,
+		&image.ContentType,
+		&image.Width,
+		&image.Height,
+		&image.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("image doesnt exist")
+		}
+		return nil, err
+	}
+
+	span.SetRows(1)
+	return &image, nil
+}
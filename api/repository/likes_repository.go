@@ -3,10 +3,12 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
 type LikesRepository interface {
 	CountLikes(ctx context.Context, id int64) (int64, error)
+	Toggle(ctx context.Context, userId int64, trackId int64) (bool, error)
 }
 
 type LikesRepositoryImpl struct {
@@ -20,6 +22,9 @@ func NewLikeRepositoryImpl(db *sql.DB) LikesRepository {
 }
 
 func (l LikesRepositoryImpl) CountLikes(ctx context.Context, id int64) (int64, error) {
+	ctx, span := startQuery(ctx, "likes.CountLikes")
+	defer span.End()
+
 	var likes int64
 
 	script := `
@@ -28,7 +33,7 @@ func (l LikesRepositoryImpl) CountLikes(ctx context.Context, id int64) (int64, e
 		WHERE t.id = $1;
 	`
 	args := []any{id}
-	row := l.Db.QueryRowContext(ctx, script, args...)
+	row := conn(ctx, l.Db).QueryRowContext(ctx, script, args...)
 
 	err := row.Scan(&likes)
 	if err != nil {
@@ -36,3 +41,41 @@ func (l LikesRepositoryImpl) CountLikes(ctx context.Context, id int64) (int64, e
 	}
 	return likes, err
 }
+
+// Toggle unlike a liked track or like it otherwise, it report whether the track is now liked.
+// The track row is locked first so toggles of the same track run one after the other when
+// called within a transaction
+func (l LikesRepositoryImpl) Toggle(ctx context.Context, userId int64, trackId int64) (bool, error) {
+	ctx, span := startQuery(ctx, "likes.Toggle")
+	defer span.End()
+
+	db := conn(ctx, l.Db)
+	var id int64
+	err := db.QueryRowContext(ctx, `SELECT id FROM tracks WHERE id=$1 FOR UPDATE`, trackId).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, errors.New("track doesnt exist")
+		}
+		return false, err
+	}
+
+	row, err := db.ExecContext(ctx, `DELETE FROM likes WHERE id_users=$1 AND id_tracks=$2`, userId, trackId)
+	if err != nil {
+		return false, err
+	}
+	rowAffected, err := row.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowAffected > 0 {
+		span.SetRows(int(rowAffected))
+		return false, nil
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO likes (id_users, id_tracks) VALUES ($1, $2)`, userId, trackId)
+	if err != nil {
+		return false, err
+	}
+	span.SetRows(1)
+	return true, nil
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"slices"
+)
+
+// PermissionAdmin grant the admin endpoints
+const PermissionAdmin = "admin"
+
+// PermissionCatalog grant creating, editing, importing and deleting tracks and their media
+const PermissionCatalog = "catalog"
+
+type Permissions []string
+
+// Include report whether code is one of the permissions
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionsRepository interface {
+	GetAllForUser(ctx context.Context, userId int64) (Permissions, error)
+	AddForUser(ctx context.Context, userId int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userId int64, codes ...string) error
+	GetAll(ctx context.Context) (Permissions, error)
+}
+
+type PermissionsRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewPermissionsRepositoryImpl(db *sql.DB) PermissionsRepository {
+	return &PermissionsRepositoryImpl{Db: db}
+}
+
+func (p PermissionsRepositoryImpl) GetAllForUser(ctx context.Context, userId int64) (Permissions, error) {
+	ctx, span := startQuery(ctx, "permissions.GetAllForUser")
+	defer span.End()
+
+	script := `
+		SELECT p.code
+		FROM permissions p
+			INNER JOIN users_permissions up ON up.permission_id = p.id
+		WHERE up.user_id = $1
+	`
+	rows, err := conn(ctx, p.Db).QueryContext(ctx, script, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var code string
+		err = rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(permissions))
+	return permissions, nil
+}
+
+func (p PermissionsRepositoryImpl) AddForUser(ctx context.Context, userId int64, codes ...string) error {
+	ctx, span := startQuery(ctx, "permissions.AddForUser")
+	defer span.End()
+
+	script := `
+		INSERT INTO users_permissions (user_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := conn(ctx, p.Db).ExecContext(ctx, script, userId, pq.Array(codes))
+	return err
+}
+
+func (p PermissionsRepositoryImpl) RemoveForUser(ctx context.Context, userId int64, codes ...string) error {
+	ctx, span := startQuery(ctx, "permissions.RemoveForUser")
+	defer span.End()
+
+	script := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+			AND users_permissions.user_id = $1
+			AND permissions.code = ANY($2)
+	`
+	_, err := conn(ctx, p.Db).ExecContext(ctx, script, userId, pq.Array(codes))
+	return err
+}
+
+// GetAll return every permission code that can be granted
+func (p PermissionsRepositoryImpl) GetAll(ctx context.Context) (Permissions, error) {
+	ctx, span := startQuery(ctx, "permissions.GetAll")
+	defer span.End()
+
+	script := `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+	`
+	rows, err := conn(ctx, p.Db).QueryContext(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var code string
+		err = rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(permissions))
+	return permissions, nil
+}
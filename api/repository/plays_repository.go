@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+	"time"
+)
+
+// PlayDedupWindow is how close two plays of the same track from the same client
+// must be to count as one scrobble sent twice
+const PlayDedupWindow = 30 * time.Second
+
+type PlaysRepository interface {
+	Insert(ctx context.Context, play *dao.Play) (bool, error)
+	History(ctx context.Context, userId int64, before *dao.Play, limit int) ([]*dao.Play, []*dao.Tracks, []*dao.Artists, error)
+}
+
+type PlaysRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewPlaysRepositoryImpl(db *sql.DB) PlaysRepository {
+	return &PlaysRepositoryImpl{Db: db}
+}
+
+// Insert record a play and bump the play count of its track in one transaction, the caller's
+// when there is one. A duplicate within PlayDedupWindow is dropped and reported as not inserted
+func (p PlaysRepositoryImpl) Insert(ctx context.Context, play *dao.Play) (bool, error) {
+	ctx, span := startQuery(ctx, "plays.Insert")
+	defer span.End()
+
+	var inserted bool
+	err := TxManagerImpl{Db: p.Db}.WithinTx(ctx, func(ctx context.Context) error {
+		db := conn(ctx, p.Db)
+
+		// Serialize plays of the same user and track so two concurrent duplicates can't both pass the check
+		_, err := db.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, play.IdUsers, play.IdTracks)
+		if err != nil {
+			return err
+		}
+
+		script := `
+			INSERT INTO plays (id_users, id_tracks, played_at, seconds, client_id)
+			SELECT $1, $2, $3, $4, $5
+			WHERE NOT EXISTS (
+				SELECT 1
+				FROM plays
+				WHERE id_users=$1 AND id_tracks=$2 AND client_id=$5
+				  AND played_at BETWEEN $3::timestamptz - $6 * INTERVAL '1 second' AND $3::timestamptz + $6 * INTERVAL '1 second'
+			)
+			RETURNING id, created_at
+		`
+		args := []any{play.IdUsers, play.IdTracks, play.PlayedAt, play.Seconds, play.ClientId, PlayDedupWindow.Seconds()}
+		err = db.QueryRowContext(ctx, script, args...).Scan(&play.Id, &play.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return errors.New("track doesnt exist")
+			}
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, `UPDATE tracks SET play_count = play_count + 1 WHERE id=$1`, play.IdTracks)
+		if err != nil {
+			return err
+		}
+		inserted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// History list the plays of a user newest first, keyset paged on (played_at, id) after the given play
+func (p PlaysRepositoryImpl) History(ctx context.Context, userId int64, before *dao.Play, limit int) ([]*dao.Play, []*dao.Tracks, []*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "plays.History")
+	defer span.End()
+
+	var beforeAt sql.NullTime
+	var beforeId int64
+	if before != nil {
+		beforeAt = sql.NullTime{Time: before.PlayedAt, Valid: true}
+		beforeId = before.Id
+	}
+
+	script := `
+		SELECT	p.id, p.id_tracks, p.played_at, p.seconds, p.client_id, p.created_at,
+				t.id, t.title, t.duration, t.year, t.genre,
+				a.id, a.name
+		FROM plays p
+			JOIN tracks t ON t.id = p.id_tracks
+			JOIN artist a ON a.id = t.idartist
+		WHERE p.id_users=$1
+		  AND ($2::timestamptz IS NULL OR (p.played_at, p.id) < ($2, $3))
+		ORDER BY p.played_at DESC, p.id DESC
+		LIMIT $4
+	`
+	rows, err := conn(ctx, p.Db).QueryContext(ctx, script, userId, beforeAt, beforeId, limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	var plays []*dao.Play
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for rows.Next() {
+		var play dao.Play
+		var track dao.Tracks
+		var artist dao.Artists
+		err = rows.Scan(
+			&play.Id,
+			&play.IdTracks,
+			&play.PlayedAt,
+			&play.Seconds,
+			&play.ClientId,
+			&play.CreatedAt,
+			&track.Id,
+			&track.Title,
+			&track.Duration,
+			&track.Year,
+			pq.Array(&track.Genre),
+			&artist.Id,
+			&artist.Name,
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		play.IdUsers = userId
+		track.IdArtist = artist.Id
+		plays = append(plays, &play)
+		tracks = append(tracks, &track)
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	span.SetRows(len(plays))
+	return plays, tracks, artists, nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"music-echo/api/domain/dao"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaysInsertLockThenInsertWithinTx(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	playedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	inserted, err := NewPlaysRepositoryImpl(db).Insert(context.Background(), &dao.Play{IdUsers: 9, IdTracks: 1, PlayedAt: playedAt, ClientId: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inserted {
+		t.Fatal("play was not inserted")
+	}
+	// Lock the user and track, insert unless a duplicate, then bump the play count
+	assertAllInTx(t, r, 3, 1, 0)
+	if !strings.Contains(r.statements[0], "pg_advisory_xact_lock") || r.args[0][0] != int64(9) || r.args[0][1] != int64(1) {
+		t.Fatalf("lock = %q %v, want the user and track locked first", r.statements[0], r.args[0])
+	}
+	if args := r.args[1]; args[2] != playedAt || args[4] != "web" || args[5] != PlayDedupWindow.Seconds() {
+		t.Fatalf("insert args = %v, want the play deduplicated within %v", args, PlayDedupWindow)
+	}
+}
+
+func TestPlaysInsertDuplicate(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	r.emptyOn = "INSERT INTO plays"
+
+	inserted, err := NewPlaysRepositoryImpl(db).Insert(context.Background(), &dao.Play{IdUsers: 9, IdTracks: 1, PlayedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted {
+		t.Fatal("duplicate play was inserted")
+	}
+	// The play count is left as is
+	assertAllInTx(t, r, 2, 1, 0)
+}
+
+func TestPlaysInsertRollbackWhenCountFail(t *testing.T) {
+	db, r := openRecordDB(t, "UPDATE tracks SET play_count")
+
+	if _, err := NewPlaysRepositoryImpl(db).Insert(context.Background(), &dao.Play{IdUsers: 9, IdTracks: 1}); err == nil {
+		t.Fatal("play count failure was not returned")
+	}
+	assertAllInTx(t, r, 3, 0, 1)
+}
+
+func TestPlaysInsertJoinOuter(t *testing.T) {
+	db, r := openRecordDB(t, "")
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		if _, err := NewPlaysRepositoryImpl(db).Insert(ctx, &dao.Play{IdUsers: 9, IdTracks: 1}); err != nil {
+			return err
+		}
+		return errors.New("outer failure")
+	})
+	if err == nil || err.Error() != "outer failure" {
+		t.Fatalf("err = %v, want outer failure", err)
+	}
+	// No transaction of its own, the play is rolled back with the outer one
+	assertAllInTx(t, r, 3, 0, 1)
+}
+
+func TestPlaysHistoryKeyset(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	r.emptyOn = "FROM plays p"
+	plays := NewPlaysRepositoryImpl(db)
+
+	if _, _, _, err := plays.History(context.Background(), 9, nil, 20); err != nil {
+		t.Fatal(err)
+	}
+	if args := r.args[0]; args[0] != int64(9) || args[1] != nil || args[3] != int64(20) {
+		t.Fatalf("first page args = %v, want no keyset", args)
+	}
+
+	playedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, _, _, err := plays.History(context.Background(), 9, &dao.Play{Id: 41, PlayedAt: playedAt}, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.statements[1], "(p.played_at, p.id) < ($2, $3)") || !strings.Contains(r.statements[1], "ORDER BY p.played_at DESC, p.id DESC") {
+		t.Fatalf("history = %q, want keyset paged newest first", r.statements[1])
+	}
+	if args := r.args[1]; args[1] != playedAt || args[2] != int64(41) {
+		t.Fatalf("next page args = %v, want after the play at %v with id 41", args, playedAt)
+	}
+}
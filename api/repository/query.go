@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"music-echo/utils/tracing"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// QueryTimeouts bound how long a repository method may run, Operations override Default per query
+// name ex: "tracks.GetAll", a zero timeout leave the method bounded by the caller context only
+type QueryTimeouts struct {
+	Default    time.Duration
+	Operations map[string]time.Duration
+}
+
+// For return the timeout of the query
+func (q QueryTimeouts) For(query string) time.Duration {
+	if timeout, ok := q.Operations[query]; ok {
+		return timeout
+	}
+	return q.Default
+}
+
+// DefaultQueryTimeouts leave the long running methods to their caller: an export stream as long as
+// the client read and the charts job has its own ctx
+var DefaultQueryTimeouts = QueryTimeouts{
+	Default: 3 * time.Second,
+	Operations: map[string]time.Duration{
+		"tracks.Export":  0,
+		"charts.Refresh": 0,
+	},
+}
+
+var queryTimeouts atomic.Pointer[QueryTimeouts]
+
+func init() {
+	SetQueryTimeouts(DefaultQueryTimeouts)
+}
+
+// SetQueryTimeouts replace the timeouts of every repository
+func SetQueryTimeouts(timeouts QueryTimeouts) {
+	queryTimeouts.Store(&timeouts)
+}
+
+// querySpan end the span and release the timeout of a repository method together
+type querySpan struct {
+	*tracing.Span
+	cancel context.CancelFunc
+}
+
+func (q querySpan) End() {
+	q.Span.End()
+	q.cancel()
+}
+
+// startQuery open the span of a repository method and bound it by the query timeout, both derived
+// from the caller context so a client gone or a server shutting down cancel the query. The query
+// name it after the method ex: "tracks.GetAll"
+func startQuery(ctx context.Context, query string, attrs ...tracing.Attribute) (context.Context, querySpan) {
+	cancel := context.CancelFunc(func() {})
+	if timeout := queryTimeouts.Load().For(query); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, span := tracing.Start(ctx, query, append([]tracing.Attribute{
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.operation", query),
+	}, attrs...)...)
+	span.SetKind(tracing.KindClient)
+	return ctx, querySpan{Span: span, cancel: cancel}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escape the LIKE wildcards of s so it match literally, the pattern must declare ESCAPE '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowDriver is a database whose every query block until its context is done
+type slowDriver struct {
+	started chan struct{}
+}
+
+func (d slowDriver) Open(string) (driver.Conn, error) {
+	return slowConn(d), nil
+}
+
+type slowConn slowDriver
+
+func (c slowConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c slowConn) Close() error {
+	return nil
+}
+
+func (c slowConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+func (c slowConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c slowConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	c.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+var slowDriverStarted = make(chan struct{}, 1)
+
+func init() {
+	sql.Register("slowdb", slowDriver{started: slowDriverStarted})
+}
+
+func openSlowDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("slowdb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func setTestQueryTimeouts(t *testing.T, timeouts QueryTimeouts) {
+	previous := *queryTimeouts.Load()
+	SetQueryTimeouts(timeouts)
+	t.Cleanup(func() { SetQueryTimeouts(previous) })
+}
+
+func TestCancelledRequestAbortSlowQuery(t *testing.T) {
+	setTestQueryTimeouts(t, QueryTimeouts{Default: time.Minute})
+	users := NewUserRepositoryImpl(openSlowDB(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-slowDriverStarted
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := users.GetByEmail(ctx, "someone@example.com")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query still running after the request was cancelled")
+	}
+}
+
+func TestQueryTimeoutAbortSlowQuery(t *testing.T) {
+	setTestQueryTimeouts(t, QueryTimeouts{
+		Default:    time.Minute,
+		Operations: map[string]time.Duration{"token.Delete": 20 * time.Millisecond},
+	})
+	tokens := NewTokenRepositoryImpl(openSlowDB(t))
+
+	start := time.Now()
+	err := tokens.Delete(context.Background(), 1, "activation")
+	<-slowDriverStarted
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("query ran for %v past its 20ms timeout", elapsed)
+	}
+}
+
+func TestQueryTimeoutsFor(t *testing.T) {
+	timeouts := QueryTimeouts{
+		Default:    3 * time.Second,
+		Operations: map[string]time.Duration{"tracks.Export": 0, "tracks.GetAll": 5 * time.Second},
+	}
+
+	for query, want := range map[string]time.Duration{
+		"tracks.Export": 0,
+		"tracks.GetAll": 5 * time.Second,
+		"users.Insert":  3 * time.Second,
+	} {
+		if got := timeouts.For(query); got != want {
+			t.Fatalf("For(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", ""},
+		{"someone", "someone"},
+		{"100%", `100\%`},
+		{"some_one", `some\_one`},
+		{`back\slash`, `back\\slash`},
+		{`%_\`, `\%\_\\`},
+	}
+	for _, test := range tests {
+		if got := escapeLike(test.s); got != test.want {
+			t.Fatalf("escapeLike(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
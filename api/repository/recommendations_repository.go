@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"music-echo/api/domain/dao"
+	"music-echo/utils/recommend"
+)
+
+type RecommendationsRepository interface {
+	Seeds(ctx context.Context, ids []int64) ([]recommend.Track, error)
+	UserSeeds(ctx context.Context, userId int64, limit int) ([]int64, []float64, error)
+	Liked(ctx context.Context, userId int64) ([]int64, error)
+	Candidates(ctx context.Context, seeds []int64, exclude []int64, limit int) ([]recommend.Candidate, []*dao.Tracks, []*dao.Artists, error)
+}
+
+type RecommendationsRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewRecommendationsRepositoryImpl(db *sql.DB) RecommendationsRepository {
+	return &RecommendationsRepositoryImpl{Db: db}
+}
+
+// Seeds load the scoring features of the given tracks, credited artists included
+func (r RecommendationsRepositoryImpl) Seeds(ctx context.Context, ids []int64) ([]recommend.Track, error) {
+	ctx, span := startQuery(ctx, "recommendations.Seeds")
+	defer span.End()
+
+	script := `
+		SELECT	t.id, t.genre, t.year,
+				t.idartist || ARRAY(SELECT c.id_artist FROM track_credits c WHERE c.id_tracks = t.id)
+		FROM tracks t
+		WHERE t.id = ANY($1)
+	`
+	rows, err := conn(ctx, r.Db).QueryContext(ctx, script, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seeds []recommend.Track
+	for rows.Next() {
+		var seed recommend.Track
+		err = rows.Scan(&seed.Id, pq.Array(&seed.Genre), &seed.Year, pq.Array(&seed.ArtistIds))
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, seed)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(seeds))
+	return seeds, nil
+}
+
+// UserSeeds pick the tracks a user like or played recently, a like weigh as much as three plays
+func (r RecommendationsRepositoryImpl) UserSeeds(ctx context.Context, userId int64, limit int) ([]int64, []float64, error) {
+	ctx, span := startQuery(ctx, "recommendations.UserSeeds")
+	defer span.End()
+
+	script := `
+		SELECT id_tracks, SUM(weight) AS weight
+		FROM (
+			SELECT id_tracks, 3 AS weight FROM likes WHERE id_users=$1
+			UNION ALL
+			SELECT id_tracks, 1 AS weight FROM plays WHERE id_users=$1 AND played_at > NOW() - INTERVAL '90 days'
+		) s
+		GROUP BY id_tracks
+		ORDER BY weight DESC, id_tracks
+		LIMIT $2
+	`
+	rows, err := conn(ctx, r.Db).QueryContext(ctx, script, userId, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var weights []float64
+	for rows.Next() {
+		var id int64
+		var weight float64
+		err = rows.Scan(&id, &weight)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		weights = append(weights, weight)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	span.SetRows(len(ids))
+	return ids, weights, nil
+}
+
+// Liked list every track a user like
+func (r RecommendationsRepositoryImpl) Liked(ctx context.Context, userId int64) ([]int64, error) {
+	ctx, span := startQuery(ctx, "recommendations.Liked")
+	defer span.End()
+
+	var ids []int64
+	script := `SELECT ARRAY(SELECT id_tracks FROM likes WHERE id_users=$1)`
+	err := conn(ctx, r.Db).QueryRowContext(ctx, script, userId).Scan(pq.Array(&ids))
+	if err != nil {
+		return nil, err
+	}
+	span.SetRows(len(ids))
+	return ids, nil
+}
+
+// Candidates gather tracks that share a genre or an artist with the seeds, or were liked by
+// someone who liked a seed, seeds and excluded tracks are left out
+func (r RecommendationsRepositoryImpl) Candidates(ctx context.Context, seeds []int64, exclude []int64, limit int) ([]recommend.Candidate, []*dao.Tracks, []*dao.Artists, error) {
+	ctx, span := startQuery(ctx, "recommendations.Candidates")
+	defer span.End()
+
+	script := `
+		WITH seed AS (
+			SELECT id, idartist, genre FROM tracks WHERE id = ANY($1)
+		), seed_artists AS (
+			SELECT idartist AS id_artist FROM seed
+			UNION
+			SELECT c.id_artist FROM track_credits c WHERE c.id_tracks = ANY($1)
+		), seed_genres AS (
+			SELECT ARRAY(SELECT DISTINCT unnest(genre) FROM seed) AS genre
+		), co_likes AS (
+			SELECT l2.id_tracks, COUNT(DISTINCT l2.id_users) AS likes
+			FROM likes l1
+				JOIN likes l2 ON l2.id_users = l1.id_users AND l2.id_tracks <> l1.id_tracks
+			WHERE l1.id_tracks = ANY($1)
+			GROUP BY l2.id_tracks
+		), credits AS (
+			SELECT id_tracks, ARRAY_AGG(id_artist) AS artists
+			FROM track_credits
+			GROUP BY id_tracks
+		)
+		SELECT	t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version, COALESCE(t.image_hash, ''), t.play_count,
+				a.id, a.name, COALESCE(a.image_hash, ''),
+				t.idartist || COALESCE(c.artists, '{}'), COALESCE(cl.likes, 0)
+		FROM tracks t
+			JOIN artist a ON a.id = t.idartist
+			LEFT JOIN credits c ON c.id_tracks = t.id
+			LEFT JOIN co_likes cl ON cl.id_tracks = t.id
+		WHERE NOT (t.id = ANY($1)) AND NOT (t.id = ANY($2))
+		  AND (t.genre && (SELECT genre FROM seed_genres)
+			OR (t.idartist || COALESCE(c.artists, '{}')) && ARRAY(SELECT id_artist FROM seed_artists)
+			OR cl.likes IS NOT NULL)
+		ORDER BY COALESCE(cl.likes, 0) DESC, t.play_count DESC, t.id
+		LIMIT $3
+	`
+	// A nil slice is sent as NULL and NOT (id = ANY(NULL)) would filter out every track
+	if exclude == nil {
+		exclude = []int64{}
+	}
+	rows, err := conn(ctx, r.Db).QueryContext(ctx, script, pq.Array(seeds), pq.Array(exclude), limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	var candidates []recommend.Candidate
+	var tracks []*dao.Tracks
+	var artists []*dao.Artists
+	for rows.Next() {
+		var candidate recommend.Candidate
+		var track dao.Tracks
+		var artist dao.Artists
+		err = rows.Scan(
+			&track.Id,
+			&track.CreatedAt,
+			&track.IdArtist,
+			&track.Title,
+			&track.Duration,
+			&track.Year,
+			pq.Array(&track.Genre),
+			&track.Version,
+			&track.ImageHash,
+			&track.PlayCount,
+			&artist.Id,
+			&artist.Name,
+			&artist.ImageHash,
+			pq.Array(&candidate.ArtistIds),
+			&candidate.CoLikes,
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		candidate.Id = track.Id
+		candidate.Genre = track.Genre
+		candidate.Year = track.Year
+		candidates = append(candidates, candidate)
+		tracks = append(tracks, &track)
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	span.SetRows(len(candidates))
+	return candidates, tracks, artists, nil
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"music-echo/api/domain/dao"
+)
+
+type SearchRepository interface {
+	Suggest(ctx context.Context, q string, limit int) ([]*dao.Suggestion, error)
+}
+
+type SearchRepositoryImpl struct {
+	Db *sql.DB
+}
+
+func NewSearchRepositoryImpl(db *sql.DB) SearchRepository {
+	return &SearchRepositoryImpl{Db: db}
+}
+
+func (s SearchRepositoryImpl) Suggest(ctx context.Context, q string, limit int) ([]*dao.Suggestion, error) {
+	ctx, span := startQuery(ctx, "search.Suggest")
+	defer span.End()
+
+	// <% use the trigram GIN index, each branch is capped before merging
+	script := `
+		(SELECT 'track' AS type, t.id, t.title AS text, word_similarity($1, t.title) AS score
+		FROM tracks t
+		WHERE $1 <% t.title
+		ORDER BY score DESC
+		LIMIT $2)
+		UNION ALL
+		(SELECT 'artist' AS type, a.id, a.name AS text, word_similarity($1, a.name) AS score
+		FROM artist a
+		WHERE $1 <% a.name
+		ORDER BY score DESC
+		LIMIT $2)
+		ORDER BY score DESC, type, id
+		LIMIT $2
+	`
+	args := []any{q, limit}
+	rows, err := conn(ctx, s.Db).QueryContext(ctx, script, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []*dao.Suggestion
+	for rows.Next() {
+		var suggestion dao.Suggestion
+		err = rows.Scan(&suggestion.Type, &suggestion.Id, &suggestion.Text, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(suggestions))
+	return suggestions, nil
+}
@@ -4,12 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"music-echo/api/domain/dao"
-	"time"
 )
 
 type TokenRepository interface {
 	Insert(ctx context.Context, tokens *dao.Token) error
 	Delete(ctx context.Context, userId int64, scope string) error
+	GetAllForUser(ctx context.Context, userId int64) ([]*dao.Token, error)
+	DeleteAllForUser(ctx context.Context, userId int64) (int64, error)
 }
 
 type TokenRepositoryImpl struct {
@@ -23,16 +24,16 @@ func NewTokenRepositoryImpl(db *sql.DB) TokenRepository {
 }
 
 func (t TokenRepositoryImpl) Insert(ctx context.Context, tokens *dao.Token) error {
+	ctx, span := startQuery(ctx, "token.Insert")
+	defer span.End()
+
 	script := `
 		INSERT INTO token(hash, user_id, expiry, scope)
     	VALUES($1, $2, $3, $4)
 	`
 	args := []any{tokens.Hash, tokens.UserId, tokens.Expiry, tokens.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := t.Db.ExecContext(ctx, script, args...)
+	_, err := conn(ctx, t.Db).ExecContext(ctx, script, args...)
 	if err != nil {
 		return err
 	}
@@ -41,6 +42,9 @@ func (t TokenRepositoryImpl) Insert(ctx context.Context, tokens *dao.Token) erro
 }
 
 func (t TokenRepositoryImpl) Delete(ctx context.Context, userId int64, scope string) error {
+	ctx, span := startQuery(ctx, "token.Delete")
+	defer span.End()
+
 	script := `
 		DELETE
 		FROM token
@@ -48,10 +52,7 @@ func (t TokenRepositoryImpl) Delete(ctx context.Context, userId int64, scope str
 	`
 	args := []any{userId, scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := t.Db.ExecContext(ctx, script, args...)
+	_, err := conn(ctx, t.Db).ExecContext(ctx, script, args...)
 	if err != nil {
 		return err
 	}
@@ -59,3 +60,60 @@ func (t TokenRepositoryImpl) Delete(ctx context.Context, userId int64, scope str
 	return nil
 
 }
+
+// GetAllForUser return the tokens of the user without their hash, soonest to expire first
+func (t TokenRepositoryImpl) GetAllForUser(ctx context.Context, userId int64) ([]*dao.Token, error) {
+	ctx, span := startQuery(ctx, "token.GetAllForUser")
+	defer span.End()
+
+	script := `
+		SELECT user_id, expiry, scope
+		FROM token
+		WHERE user_id = $1
+		ORDER BY expiry ASC
+	`
+	rows, err := conn(ctx, t.Db).QueryContext(ctx, script, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*dao.Token
+	for rows.Next() {
+		var token dao.Token
+		err = rows.Scan(&token.UserId, &token.Expiry, &token.Scope)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	span.SetRows(len(tokens))
+	return tokens, nil
+}
+
+// DeleteAllForUser revoke every token of the user whatever its scope, it return how many were revoked
+func (t TokenRepositoryImpl) DeleteAllForUser(ctx context.Context, userId int64) (int64, error) {
+	ctx, span := startQuery(ctx, "token.DeleteAllForUser")
+	defer span.End()
+
+	script := `
+		DELETE
+		FROM token
+		WHERE user_id = $1
+	`
+	result, err := conn(ctx, t.Db).ExecContext(ctx, script, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	span.SetRows(int(deleted))
+	return deleted, nil
+}
@@ -7,15 +7,40 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
 	"music-echo/utils"
+	"music-echo/utils/tracing"
+	"strings"
+	"unicode"
 )
 
+// TrackSortLists map the sort keys of track listings to the SQL expression they order by
+var TrackSortLists = map[string]string{
+	"id":         "t.id",
+	"title":      "t.title",
+	"year":       "t.year",
+	"duration":   "t.duration",
+	"created_at": "t.created_at",
+	"artist":     "a.name",
+	"likes":      "likes_count",
+	"relevance":  "ts_rank(t.search_vector, to_tsquery('simple', $13))",
+}
+
+// exportBatchSize is how many rows Export fetch from its cursor at a time
+const exportBatchSize = 500
+
 type TracksRepository interface {
 	GetId(ctx context.Context, id int64) (*dao.Tracks, *dao.Artists, *int64, error)
 	Insert(ctx context.Context, tracks *dao.Tracks) error
 	Update(ctx context.Context, tracks *dao.Tracks) error
 	Delete(ctx context.Context, id int64) error
-	GetAll(ctx context.Context, title, artist string, sorting utils.Sortings, paginating utils.Paginatings, genre []string) ([]*dao.Tracks, []*dao.Artists, []int64, int64, error)
+	GetAll(ctx context.Context, filters dto.TrackFilters, sorting utils.Sortings, paginating utils.Paginatings) ([]*dao.Tracks, []*dao.Artists, []int64, int64, error)
+	GetAudio(ctx context.Context, id int64) (*dao.TrackAudio, error)
+	UpdateAudio(ctx context.Context, audio *dao.TrackAudio) (int64, error)
+	UpdateImage(ctx context.Context, id int64, hash string) (int64, error)
+	InsertCredits(ctx context.Context, id int64, credits []dao.TrackCredit) error
+	Export(ctx context.Context, filters dto.TrackFilters, sorting utils.Sortings, fn func(*dao.Tracks, *dao.Artists, int64) error) error
+	ImportInsert(ctx context.Context, tracks *dao.Tracks) error
 }
 
 type TracksRepositoryImpl struct {
@@ -26,13 +51,17 @@ func NewTracksRepositoryImpl(db *sql.DB) TracksRepository {
 	return &TracksRepositoryImpl{Db: db}
 }
 
-func (t TracksRepositoryImpl) Insert(ctx context.Context, tracks *dao.Tracks) error {
-	script := `
-		INSERT INTO tracks(idartist, title, duration, year, genre) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at
+const insertTracksScript = `
+		INSERT INTO tracks(idartist, title, duration, year, genre) VALUES($1, $2, $3, $4, $5) RETURNING id, created_at, version
 	`
+
+func (t TracksRepositoryImpl) Insert(ctx context.Context, tracks *dao.Tracks) error {
+	ctx, span := startQuery(ctx, "tracks.Insert")
+	defer span.End()
+
 	args := []any{tracks.IdArtist, tracks.Title, tracks.Duration, tracks.Year, pq.Array(tracks.Genre)}
-	row := t.Db.QueryRowContext(ctx, script, args...)
-	err := row.Scan(&tracks.Id, &tracks.CreatedAt)
+	row := conn(ctx, t.Db).QueryRowContext(ctx, insertTracksScript, args...)
+	err := row.Scan(&tracks.Id, &tracks.CreatedAt, &tracks.Version)
 	if err != nil {
 		return err
 	}
@@ -40,6 +69,9 @@ func (t TracksRepositoryImpl) Insert(ctx context.Context, tracks *dao.Tracks) er
 }
 
 func (t TracksRepositoryImpl) Update(ctx context.Context, tracks *dao.Tracks) error {
+	ctx, span := startQuery(ctx, "tracks.Update")
+	defer span.End()
+
 	script := `
 		UPDATE tracks 
 		SET idartist=$1, title=$2, duration=$3, year=$4, genre=$5, version=version+1 
@@ -48,7 +80,7 @@ func (t TracksRepositoryImpl) Update(ctx context.Context, tracks *dao.Tracks) er
 
 	args := []interface{}{tracks.IdArtist, tracks.Title, tracks.Duration, tracks.Year, pq.Array(tracks.Genre), tracks.Id}
 
-	row := t.Db.QueryRowContext(ctx, script, args...)
+	row := conn(ctx, t.Db).QueryRowContext(ctx, script, args...)
 	err := row.Scan(&tracks.Version)
 	if errors.Is(err, sql.ErrNoRows) {
 		return errors.New("track doesnt exist")
@@ -61,12 +93,15 @@ func (t TracksRepositoryImpl) Update(ctx context.Context, tracks *dao.Tracks) er
 }
 
 func (t TracksRepositoryImpl) Delete(ctx context.Context, id int64) error {
+	ctx, span := startQuery(ctx, "tracks.Delete")
+	defer span.End()
+
 	script := `
 		DELETE
 		FROM tracks
 		WHERE id=$1;
 	`
-	row, err := t.Db.ExecContext(ctx, script, id)
+	row, err := conn(ctx, t.Db).ExecContext(ctx, script, id)
 	if err != nil {
 		return err
 	}
@@ -79,13 +114,17 @@ func (t TracksRepositoryImpl) Delete(ctx context.Context, id int64) error {
 		return errors.New("track doesnt exist")
 	}
 
+	span.SetRows(int(rowAffected))
 	return nil
 }
 
 func (t TracksRepositoryImpl) GetId(ctx context.Context, id int64) (*dao.Tracks, *dao.Artists, *int64, error) {
+	ctx, span := startQuery(ctx, "tracks.GetId")
+	defer span.End()
+
 	script := `
-		SELECT	t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version,
-       			a.id AS artist_id, a.name AS artist_name,
+		SELECT	t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version, COALESCE(t.image_hash, ''), t.play_count,
+       			a.id AS artist_id, a.name AS artist_name, COALESCE(a.image_hash, ''),
        			COUNT(l.id_tracks) AS like_count 
 		FROM tracks t 
 		    LEFT JOIN likes l ON t.id = l.id_tracks
@@ -94,7 +133,7 @@ func (t TracksRepositoryImpl) GetId(ctx context.Context, id int64) (*dao.Tracks,
 		GROUP BY t.id, a.id
     `
 	args := []interface{}{id}
-	row := t.Db.QueryRowContext(ctx, script, args...)
+	row := conn(ctx, t.Db).QueryRowContext(ctx, script, args...)
 
 	var track dao.Tracks
 	var artist dao.Artists
@@ -108,37 +147,178 @@ func (t TracksRepositoryImpl) GetId(ctx context.Context, id int64) (*dao.Tracks,
 		&track.Year,
 		pq.Array(&track.Genre),
 		&track.Version,
+		&track.ImageHash,
+		&track.PlayCount,
 		&artist.Id,
 		&artist.Name,
+		&artist.ImageHash,
 		&likes)
 
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	span.SetRows(1)
 	return &track, &artist, &likes, nil
 
 }
 
-func (t TracksRepositoryImpl) GetAll(ctx context.Context, title, artist string, sorting utils.Sortings, paginating utils.Paginatings, genre []string) ([]*dao.Tracks, []*dao.Artists, []int64, int64, error) {
-	var script = fmt.Sprintf(`
-		SELECT 	COUNT(*) OVER(), t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version,
-          		a.id AS artist_id, a.name AS artist_name,
+func (t TracksRepositoryImpl) GetAudio(ctx context.Context, id int64) (*dao.TrackAudio, error) {
+	ctx, span := startQuery(ctx, "tracks.GetAudio")
+	defer span.End()
+
+	script := `
+		SELECT id, audio_location, audio_format, audio_mime, audio_size, audio_checksum, audio_updated_at
+		FROM tracks
+		WHERE id=$1 AND audio_location IS NOT NULL
+	`
+	var audio dao.TrackAudio
+	row := conn(ctx, t.Db).QueryRowContext(ctx, script, id)
+	err := row.Scan(
+		&audio.TrackId,
+		&audio.Location,
+		&audio.Format,
+		&audio.MimeType,
+		&audio.Size,
+		&audio.Checksum,
+		&audio.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("audio doesnt exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetRows(1)
+	return &audio, nil
+}
+
+// UpdateAudio set the audio of the track, it return the version the track is now at
+func (t TracksRepositoryImpl) UpdateAudio(ctx context.Context, audio *dao.TrackAudio) (int64, error) {
+	ctx, span := startQuery(ctx, "tracks.UpdateAudio")
+	defer span.End()
+
+	script := `
+		UPDATE tracks
+		SET audio_location=$1, audio_format=$2, audio_mime=$3, audio_size=$4, audio_checksum=$5,
+		    audio_updated_at=NOW(), version=version+1
+		WHERE id=$6
+		RETURNING audio_updated_at, version`
+
+	args := []any{audio.Location, audio.Format, audio.MimeType, audio.Size, audio.Checksum, audio.TrackId}
+	var version int64
+	row := conn(ctx, t.Db).QueryRowContext(ctx, script, args...)
+	err := row.Scan(&audio.UpdatedAt, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("track doesnt exist")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetRows(1)
+	return version, nil
+}
+
+func (t TracksRepositoryImpl) InsertCredits(ctx context.Context, id int64, credits []dao.TrackCredit) error {
+	ctx, span := startQuery(ctx, "tracks.InsertCredits")
+	defer span.End()
+
+	script := `
+		INSERT INTO track_credits (id_tracks, id_artist, role)
+		SELECT $1, c.id_artist, c.role
+		FROM unnest($2::integer[], $3::text[]) AS c(id_artist, role)
+		ON CONFLICT DO NOTHING
+	`
+	var artistIds []int64
+	var roles []string
+	for _, credit := range credits {
+		artistIds = append(artistIds, credit.IdArtist)
+		roles = append(roles, credit.Role)
+	}
+
+	_, err := conn(ctx, t.Db).ExecContext(ctx, script, id, pq.Array(artistIds), pq.Array(roles))
+	return err
+}
+
+// UpdateImage set the image of the track, it return the version the track is now at
+func (t TracksRepositoryImpl) UpdateImage(ctx context.Context, id int64, hash string) (int64, error) {
+	ctx, span := startQuery(ctx, "tracks.UpdateImage")
+	defer span.End()
+
+	script := `
+		UPDATE tracks
+		SET image_hash=$1, version=version+1
+		WHERE id=$2
+		RETURNING version
+	`
+	var version int64
+	err := conn(ctx, t.Db).QueryRowContext(ctx, script, hash, id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("track doesnt exist")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetRows(1)
+	return version, nil
+}
+
+// tracksListScript is the query behind track listings, %s take the extra leading columns and the ORDER BY.
+// $4 and $5 are LIMIT and OFFSET, the rest are the filters bound by tracksListArgs
+const tracksListScript = `
+		SELECT 	%s t.id, t.created_at, t.idartist, t.title, t.duration, t.year, t.genre, t.version, COALESCE(t.image_hash, ''), t.play_count,
+          		a.id AS artist_id, a.name AS artist_name, COALESCE(a.image_hash, ''),
           		COUNT(l.id_tracks) AS likes_count
 		FROM tracks t
          		LEFT JOIN artist a ON t.idartist = a.id
          		LEFT JOIN likes l ON t.id = l.id_tracks
 		WHERE (to_tsvector('simple', t.title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
 		  		AND (to_tsvector('simple', a.name) @@ plainto_tsquery('simple', $2) OR $2 = '') 
-		  		AND(t.genre @> $3 OR $3='{}')
+		  		AND ($3 = '{}'
+		  			OR ($6 = 'all' AND NOT EXISTS (SELECT 1 FROM unnest($3::text[]) g WHERE NOT t.genre && genre_descendants(g)))
+		  			OR ($6 = 'any' AND EXISTS (SELECT 1 FROM unnest($3::text[]) g WHERE t.genre && genre_descendants(g))))
+		  		AND (t.year >= $7 OR $7 = 0)
+		  		AND (t.year <= $8 OR $8 = 0)
+		  		AND (t.duration >= $9 OR $9 = 0)
+		  		AND (t.duration <= $10 OR $10 = 0)
+		  		AND (t.created_at > $12 OR $12 IS NULL)
+		  		AND (t.search_vector @@ to_tsquery('simple', $13) OR $13 = '')
 		GROUP BY t.id, a.id
-		ORDER BY %s %s, t.id ASC
-		LIMIT $4 OFFSET $5`, sorting.SortName(), sorting.SortDirection())
+		HAVING COUNT(l.id_tracks) >= $11
+		ORDER BY %s, t.id ASC
+		LIMIT $4 OFFSET $5`
+
+// tracksListArgs bind the filters of tracksListScript, a nil limit means LIMIT ALL
+func tracksListArgs(filters dto.TrackFilters, limit any, offset int) []any {
+	var createdAfter = sql.NullTime{Time: filters.CreatedAfter, Valid: !filters.CreatedAfter.IsZero()}
+	return []any{
+		filters.Title, filters.Artist, pq.Array(filters.Genre), limit, offset,
+		filters.GenreMode, filters.YearMin, filters.YearMax, filters.DurationMin, filters.DurationMax,
+		filters.MinLikes, createdAfter, prefixTSQuery(filters.Query),
+	}
+}
 
-	var args = []interface{}{title, artist, pq.Array(genre), paginating.Limit(), paginating.Offset()}
-	var rows, err = t.Db.QueryContext(ctx, script, args...)
+func (t TracksRepositoryImpl) GetAll(ctx context.Context, filters dto.TrackFilters, sorting utils.Sortings, paginating utils.Paginatings) ([]*dao.Tracks, []*dao.Artists, []int64, int64, error) {
+	// Which filters were set tell apart a slow full text match from a slow count or join
+	ctx, span := startQuery(ctx, "tracks.GetAll",
+		tracing.Bool("filter.query", filters.Query != ""),
+		tracing.Bool("filter.title", filters.Title != ""),
+		tracing.Bool("filter.artist", filters.Artist != ""),
+		tracing.Int("filter.genres", int64(len(filters.Genre))),
+		tracing.String("sort", sorting.Sorts),
+		tracing.Int("page_size", paginating.PageSize),
+	)
+	defer span.End()
+
+	var script = fmt.Sprintf(tracksListScript, "COUNT(*) OVER(),", sorting.OrderBy())
+	var args = tracksListArgs(filters, paginating.Limit(), paginating.Offset())
+	var rows, err = conn(ctx, t.Db).QueryContext(ctx, script, args...)
 	if err != nil {
 		return nil, nil, nil, 0, err
 	}
+	defer rows.Close()
 	var tracks []*dao.Tracks
 	var artists []*dao.Artists
 	var likes []int64
@@ -157,8 +337,11 @@ func (t TracksRepositoryImpl) GetAll(ctx context.Context, title, artist string,
 			&track.Year,
 			pq.Array(&track.Genre),
 			&track.Version,
+			&track.ImageHash,
+			&track.PlayCount,
 			&artist.Id,
 			&artist.Name,
+			&artist.ImageHash,
 			&like,
 		)
 		if err != nil {
@@ -169,7 +352,130 @@ func (t TracksRepositoryImpl) GetAll(ctx context.Context, title, artist string,
 		artists = append(artists, &artist)
 		likes = append(likes, like)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, 0, err
+	}
 
+	span.SetRows(len(tracks))
 	return tracks, artists, likes, totalRecords, nil
 
 }
+
+func (t TracksRepositoryImpl) Export(ctx context.Context, filters dto.TrackFilters, sorting utils.Sortings, fn func(*dao.Tracks, *dao.Artists, int64) error) error {
+	ctx, span := startQuery(ctx, "tracks.Export")
+	defer span.End()
+
+	// Cursor only live inside a transaction, read only since nothing is written
+	tx, err := t.Db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var script = "DECLARE tracks_export NO SCROLL CURSOR FOR " + fmt.Sprintf(tracksListScript, "", sorting.OrderBy())
+	var args = tracksListArgs(filters, nil, 0)
+	_, err = tx.ExecContext(ctx, script, args...)
+	if err != nil {
+		return err
+	}
+
+	// Fetch in batches so memory stays flat no matter how many rows match
+	var exported int
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM tracks_export", exportBatchSize))
+		if err != nil {
+			return err
+		}
+
+		var fetched int
+		for rows.Next() {
+			var track dao.Tracks
+			var artist dao.Artists
+			var like int64
+			err = rows.Scan(
+				&track.Id,
+				&track.CreatedAt,
+				&track.IdArtist,
+				&track.Title,
+				&track.Duration,
+				&track.Year,
+				pq.Array(&track.Genre),
+				&track.Version,
+				&track.ImageHash,
+				&track.PlayCount,
+				&artist.Id,
+				&artist.Name,
+				&artist.ImageHash,
+				&like,
+			)
+			if err == nil {
+				err = fn(&track, &artist, like)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+		exported += fetched
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if fetched < exportBatchSize {
+			break
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "CLOSE tracks_export")
+	if err != nil {
+		return err
+	}
+	span.SetRows(exported)
+
+	return tx.Commit()
+}
+
+// ImportInsert insert one row of an import behind a savepoint so a failing row doesn't abort the rows
+// around it, it must run within the import transaction of TxManager
+func (t TracksRepositoryImpl) ImportInsert(ctx context.Context, tracks *dao.Tracks) error {
+	ctx, span := startQuery(ctx, "tracks.ImportInsert")
+	defer span.End()
+
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	if !ok {
+		return errors.New("import insert outside of a transaction")
+	}
+
+	_, err := tx.ExecContext(ctx, "SAVEPOINT import_row")
+	if err != nil {
+		return err
+	}
+
+	args := []any{tracks.IdArtist, tracks.Title, tracks.Duration, tracks.Year, pq.Array(tracks.Genre)}
+	row := tx.QueryRowContext(ctx, insertTracksScript, args...)
+	err = row.Scan(&tracks.Id, &tracks.CreatedAt, &tracks.Version)
+	if err != nil {
+		_, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row")
+		if rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+	return err
+}
+
+// prefixTSQuery turn free text into a tsquery matching every word as a prefix, ex: "bohem rhap" -> "bohem:* & rhap:*"
+func prefixTSQuery(q string) string {
+	var terms []string
+	var words = strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		terms = append(terms, word+":*")
+	}
+	return strings.Join(terms, " & ")
+}
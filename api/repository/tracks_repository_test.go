@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"music-echo/api/domain/dto"
+	"music-echo/utils"
+	"strings"
+	"testing"
+)
+
+func TestTracksGetAllFilterGenreDescendants(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"any", "($6 = 'any' AND EXISTS (SELECT 1 FROM unnest($3::text[]) g WHERE t.genre && genre_descendants(g)))"},
+		{"all", "($6 = 'all' AND NOT EXISTS (SELECT 1 FROM unnest($3::text[]) g WHERE NOT t.genre && genre_descendants(g)))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			db, r := openRecordDB(t, "")
+			r.emptyOn = "FROM tracks t"
+			filters := dto.TrackFilters{Genre: []string{"metal", "rock"}, GenreMode: tt.mode}
+
+			_, _, _, _, err := NewTracksRepositoryImpl(db).GetAll(context.Background(), filters, utils.Sortings{Sorts: "id", SafeSortLists: map[string]string{"id": "t.id"}}, utils.Paginatings{Page: 1, PageSize: 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A genre match its descendants too, ex: metal match death-metal
+			if !strings.Contains(r.statements[0], tt.want) {
+				t.Fatalf("query = %q, want the genres matched with their descendants", r.statements[0])
+			}
+			if args := r.args[0]; args[2] != `{"metal","rock"}` || args[5] != tt.mode {
+				t.Fatalf("args = %v, want the genres and mode %q", args, tt.mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// DBTX is what *sql.DB and *sql.Tx both offer, repositories query through it so they run inside
+// the transaction of the context when there is one
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type TxManager interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type TxManagerImpl struct {
+	Db *sql.DB
+}
+
+func NewTxManagerImpl(db *sql.DB) TxManager {
+	return &TxManagerImpl{Db: db}
+}
+
+type txKey struct{}
+
+// WithinTx run fn in one transaction shared by every repository called with the ctx given to fn,
+// an error or a panic from fn roll it back otherwise it is committed. Inside a transaction
+// already, fn join it and the outer WithinTx decide
+func (t TxManagerImpl) WithinTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := t.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// conn return the transaction of ctx, or db outside of one
+func conn(ctx context.Context, db *sql.DB) DBTX {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// Conn is conn for the stores outside of this package, ex: storage.LargeObjectStore
+func Conn(ctx context.Context, db *sql.DB) DBTX {
+	return conn(ctx, db)
+}
@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"music-echo/api/domain/dao"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordDriver is a database that record every statement with its arguments, whether it ran in a
+// transaction, and how transactions ended. Statements containing failOn fail, with failErr when set,
+// queries containing emptyOn return no rows and those containing a key of rows return its rows.
+// Statements executed affect the rows of the first key of affected they contain, none otherwise
+type recordDriver struct{}
+
+type recording struct {
+	lock       sync.Mutex
+	failOn     string
+	failErr    error
+	emptyOn    string
+	rows       map[string][][]driver.Value
+	affected   map[string]int64
+	statements []string
+	args       [][]driver.Value
+	commits    int
+	rollbacks  int
+}
+
+var (
+	recordingsLock sync.Mutex
+	recordings     = map[string]*recording{}
+)
+
+func init() {
+	sql.Register("recorddb", recordDriver{})
+}
+
+func (recordDriver) Open(name string) (driver.Conn, error) {
+	recordingsLock.Lock()
+	defer recordingsLock.Unlock()
+
+	return &recordConn{recording: recordings[name]}, nil
+}
+
+func openRecordDB(t *testing.T, failOn string) (*sql.DB, *recording) {
+	r := &recording{failOn: failOn}
+	recordingsLock.Lock()
+	recordings[t.Name()] = r
+	recordingsLock.Unlock()
+
+	db, err := sql.Open("recorddb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, r
+}
+
+type recordConn struct {
+	recording *recording
+	inTx      bool
+}
+
+func (c *recordConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.inTx = true
+	return recordTx{c}, nil
+}
+
+// record the statement, it fail when it contain failOn
+func (c *recordConn) record(query string, args []driver.NamedValue) error {
+	c.recording.lock.Lock()
+	defer c.recording.lock.Unlock()
+
+	where := "db: "
+	if c.inTx {
+		where = "tx: "
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	c.recording.statements = append(c.recording.statements, where+query)
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.recording.args = append(c.recording.args, values)
+	if c.recording.failOn != "" && strings.Contains(query, c.recording.failOn) {
+		if c.recording.failErr != nil {
+			return c.recording.failErr
+		}
+		return errors.New("statement failed: " + c.recording.failOn)
+	}
+	return nil
+}
+
+func (c *recordConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.record(query, args); err != nil {
+		return nil, err
+	}
+	for key, affected := range c.recording.affected {
+		if strings.Contains(query, key) {
+			return driver.RowsAffected(affected), nil
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *recordConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.record(query, args); err != nil {
+		return nil, err
+	}
+
+	for key, rows := range c.recording.rows {
+		if strings.Contains(query, key) {
+			return &recordRows{rows: rows}, nil
+		}
+	}
+
+	// One row shaped after what each statement scan
+	switch {
+	case c.recording.emptyOn != "" && strings.Contains(query, c.recording.emptyOn):
+		return &recordRows{}, nil
+	case strings.Contains(query, "INSERT INTO plays"):
+		return &recordRows{rows: [][]driver.Value{{int64(1), time.Now()}}}, nil
+	case strings.Contains(query, "INSERT INTO users"):
+		return &recordRows{rows: [][]driver.Value{{int64(1), time.Now(), int64(1)}}}, nil
+	case strings.Contains(query, "INSERT INTO tracks"):
+		return &recordRows{rows: [][]driver.Value{{int64(7), time.Now(), int64(1)}}}, nil
+	case strings.Contains(query, "INSERT INTO audit_events"):
+		return &recordRows{rows: [][]driver.Value{{int64(1), time.Now()}}}, nil
+	case strings.Contains(query, "FROM artist"):
+		return &recordRows{rows: [][]driver.Value{{int64(3), "Artist", ""}}}, nil
+	default:
+		return &recordRows{rows: [][]driver.Value{{int64(7)}}}, nil
+	}
+}
+
+type recordTx struct {
+	c *recordConn
+}
+
+func (t recordTx) Commit() error {
+	t.c.inTx = false
+	t.c.recording.lock.Lock()
+	defer t.c.recording.lock.Unlock()
+
+	t.c.recording.commits++
+	return nil
+}
+
+func (t recordTx) Rollback() error {
+	t.c.inTx = false
+	t.c.recording.lock.Lock()
+	defer t.c.recording.lock.Unlock()
+
+	t.c.recording.rollbacks++
+	return nil
+}
+
+type recordRows struct {
+	rows [][]driver.Value
+}
+
+func (r *recordRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *recordRows) Close() error {
+	return nil
+}
+
+func (r *recordRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// assertAllInTx check that every recorded statement ran in the transaction and how it ended
+func assertAllInTx(t *testing.T, r *recording, statements int, commits int, rollbacks int) {
+	t.Helper()
+	if len(r.statements) != statements {
+		t.Fatalf("ran %d statements, want %d: %q", len(r.statements), statements, r.statements)
+	}
+	for _, statement := range r.statements {
+		if !strings.HasPrefix(statement, "tx: ") {
+			t.Fatalf("statement ran outside the transaction: %q", statement)
+		}
+	}
+	if r.commits != commits || r.rollbacks != rollbacks {
+		t.Fatalf("commits = %d rollbacks = %d, want %d and %d", r.commits, r.rollbacks, commits, rollbacks)
+	}
+}
+
+func createUser(ctx context.Context, db *sql.DB) error {
+	users := NewUserRepositoryImpl(db)
+	tokens := NewTokenRepositoryImpl(db)
+	return NewTxManagerImpl(db).WithinTx(ctx, func(ctx context.Context) error {
+		user := &dao.Users{Name: "someone", Email: "someone@example.com"}
+		if err := users.Insert(ctx, user); err != nil {
+			return err
+		}
+		return tokens.Insert(ctx, &dao.Token{UserId: user.Id, Scope: "activation"})
+	})
+}
+
+func TestWithinTxCommitUserAndToken(t *testing.T) {
+	db, r := openRecordDB(t, "")
+
+	if err := createUser(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	assertAllInTx(t, r, 2, 1, 0)
+}
+
+func TestWithinTxRollbackUserWhenTokenFail(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO token")
+
+	if err := createUser(context.Background(), db); err == nil {
+		t.Fatal("token insert failure was not returned")
+	}
+	assertAllInTx(t, r, 2, 0, 1)
+}
+
+func TestWithinTxRollbackTrackWhenCreditsFail(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO track_credits")
+	artists := NewArtistRepositoryImpl(db)
+	tracks := NewTracksRepositoryImpl(db)
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		artist, err := artists.GetByName(ctx, "Artist")
+		if err != nil {
+			return err
+		}
+		track := &dao.Tracks{IdArtist: artist.Id, Title: "Title"}
+		if err = tracks.Insert(ctx, track); err != nil {
+			return err
+		}
+		return tracks.InsertCredits(ctx, track.Id, []dao.TrackCredit{{IdArtist: artist.Id, Role: "featured"}})
+	})
+	if err == nil {
+		t.Fatal("credits insert failure was not returned")
+	}
+	assertAllInTx(t, r, 3, 0, 1)
+}
+
+func TestWithinTxRollbackLikeWhenCountFail(t *testing.T) {
+	db, r := openRecordDB(t, "COUNT(l.id_tracks)")
+	likes := NewLikeRepositoryImpl(db)
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		liked, err := likes.Toggle(ctx, 1, 7)
+		if err != nil {
+			return err
+		}
+		if !liked {
+			t.Fatal("toggle of a track not liked yet did not like it")
+		}
+		_, err = likes.CountLikes(ctx, 7)
+		return err
+	})
+	if err == nil {
+		t.Fatal("count failure was not returned")
+	}
+	// Lock the track, try to unlike, like then count
+	assertAllInTx(t, r, 4, 0, 1)
+}
+
+func TestWithinTxRollbackOnPanic(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	users := NewUserRepositoryImpl(db)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		_ = NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+			_ = users.Insert(ctx, &dao.Users{})
+			panic("boom")
+		})
+	}()
+	assertAllInTx(t, r, 1, 0, 1)
+}
+
+func TestWithinTxNestedJoinOuter(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO token")
+	manager := NewTxManagerImpl(db)
+
+	err := manager.WithinTx(context.Background(), func(ctx context.Context) error {
+		// The inner failure is left to the outer transaction to roll back
+		_ = createUser(ctx, db)
+		return errors.New("outer failure")
+	})
+	if err == nil || err.Error() != "outer failure" {
+		t.Fatalf("err = %v, want outer failure", err)
+	}
+	assertAllInTx(t, r, 2, 0, 1)
+}
+
+func TestWithinTxRollbackBanWhenAuditFail(t *testing.T) {
+	db, r := openRecordDB(t, "INSERT INTO audit_events")
+	users := NewUserRepositoryImpl(db)
+	tokens := NewTokenRepositoryImpl(db)
+	audit := NewAuditRepositoryImpl(db)
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		user := &dao.Users{Id: 1, Banned: true}
+		if err := users.Update(ctx, user); err != nil {
+			return err
+		}
+		if _, err := tokens.DeleteAllForUser(ctx, user.Id); err != nil {
+			return err
+		}
+		return audit.Insert(ctx, &dao.AuditEvent{ActorId: 2, Action: "user.ban", Entity: "user", EntityId: user.Id})
+	})
+	if err == nil {
+		t.Fatal("audit insert failure was not returned")
+	}
+	assertAllInTx(t, r, 3, 0, 1)
+}
+
+func TestWithinTxImportInsertJoinOuter(t *testing.T) {
+	db, r := openRecordDB(t, "")
+	tracks := NewTracksRepositoryImpl(db)
+	audit := NewAuditRepositoryImpl(db)
+
+	err := NewTxManagerImpl(db).WithinTx(context.Background(), func(ctx context.Context) error {
+		track := &dao.Tracks{IdArtist: 3, Title: "Title"}
+		if err := tracks.ImportInsert(ctx, track); err != nil {
+			return err
+		}
+		return audit.Insert(ctx, &dao.AuditEvent{ActorId: 2, Action: "track.create", Entity: "track", EntityId: track.Id})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Savepoint, insert and release, then the audit event
+	assertAllInTx(t, r, 4, 1, 0)
+}
+
+func TestImportInsertOutsideTx(t *testing.T) {
+	db, r := openRecordDB(t, "")
+
+	if err := NewTracksRepositoryImpl(db).ImportInsert(context.Background(), &dao.Tracks{}); err == nil {
+		t.Fatal("import insert ran outside of a transaction")
+	}
+	assertAllInTx(t, r, 0, 0, 0)
+}
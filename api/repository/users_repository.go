@@ -5,15 +5,29 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"music-echo/api/domain/dao"
+	"music-echo/api/domain/dto"
+	"music-echo/utils"
+	"music-echo/utils/tracing"
 	"time"
 )
 
 type UsersRepository interface {
 	Insert(ctx context.Context, users *dao.Users) error
 	GetByEmail(ctx context.Context, email string) (*dao.Users, error)
+	GetById(ctx context.Context, id int64) (*dao.Users, error)
 	Update(ctx context.Context, users *dao.Users) error
 	GetByToken(ctx context.Context, plainText, tokenScope string) (*dao.Users, error)
+	GetAll(ctx context.Context, filters dto.UserFilters, sorting utils.Sortings, paginating utils.Paginatings) ([]*dao.Users, int64, error)
+}
+
+// UserSortLists map the sort keys of user listings to the SQL expression they order by
+var UserSortLists = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"email":      "email",
+	"created_at": "created_at",
 }
 
 type UsersRepositoryImpl struct {
@@ -27,16 +41,16 @@ func NewUserRepositoryImpl(db *sql.DB) UsersRepository {
 }
 
 func (u UsersRepositoryImpl) Insert(ctx context.Context, users *dao.Users) error {
+	ctx, span := startQuery(ctx, "users.Insert")
+	defer span.End()
+
 	script := `
 		INSERT INTO users(name, email, password_hash)
     		VALUES ($1, $2, $3)
 		RETURNING id, created_at, version;
 	`
 	args := []any{users.Name, users.Email, users.Password.Hash}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	row := u.Db.QueryRowContext(ctx, script, args...)
+	row := conn(ctx, u.Db).QueryRowContext(ctx, script, args...)
 	err := row.Scan(&users.Id, &users.CreatedAt, &users.Version)
 
 	if err != nil {
@@ -52,23 +66,58 @@ func (u UsersRepositoryImpl) Insert(ctx context.Context, users *dao.Users) error
 }
 
 func (u UsersRepositoryImpl) GetByEmail(ctx context.Context, email string) (*dao.Users, error) {
+	ctx, span := startQuery(ctx, "users.GetByEmail")
+	defer span.End()
+
 	script := `
-		SELECT id, created_at, name, email, password_hash, activated, version 
+		SELECT id, created_at, name, email, password_hash, activated, banned, version
 		FROM users
 		WHERE email=$1;
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var users dao.Users
+	row := conn(ctx, u.Db).QueryRowContext(ctx, script, email)
+	err := row.Scan(
+		&users.Id,
+		&users.CreatedAt,
+		&users.Name,
+		&users.Email,
+		&users.Password.Hash,
+		&users.Activated,
+		&users.Banned,
+		&users.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, errors.New("record not found")
+		default:
+			return nil, err
+		}
+	}
 
+	span.SetRows(1)
+	return &users, nil
+}
+
+func (u UsersRepositoryImpl) GetById(ctx context.Context, id int64) (*dao.Users, error) {
+	ctx, span := startQuery(ctx, "users.GetById")
+	defer span.End()
+
+	script := `
+		SELECT id, created_at, name, email, password_hash, activated, banned, version
+		FROM users
+		WHERE id=$1;
+	`
 	var users dao.Users
-	row := u.Db.QueryRowContext(ctx, script, email)
+	row := conn(ctx, u.Db).QueryRowContext(ctx, script, id)
 	err := row.Scan(
 		&users.Id,
 		&users.CreatedAt,
 		&users.Name,
 		&users.Email,
-		&users.Password,
+		&users.Password.Hash,
 		&users.Activated,
+		&users.Banned,
 		&users.Version,
 	)
 	if err != nil {
@@ -80,21 +129,22 @@ func (u UsersRepositoryImpl) GetByEmail(ctx context.Context, email string) (*dao
 		}
 	}
 
+	span.SetRows(1)
 	return &users, nil
 }
 
 func (u UsersRepositoryImpl) Update(ctx context.Context, users *dao.Users) error {
+	ctx, span := startQuery(ctx, "users.Update")
+	defer span.End()
+
 	script := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, banned = $5, version = version + 1
+		WHERE id = $6 AND version = $7
 		RETURNING version;
 	`
-	args := []any{users.Name, users.Email, users.Password.Hash, users.Activated, users.Id, users.Version}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	row := u.Db.QueryRowContext(ctx, script, args...)
+	args := []any{users.Name, users.Email, users.Password.Hash, users.Activated, users.Banned, users.Id, users.Version}
+	row := conn(ctx, u.Db).QueryRowContext(ctx, script, args...)
 	err := row.Scan(&users.Version)
 	if err != nil {
 		switch {
@@ -112,24 +162,28 @@ func (u UsersRepositoryImpl) Update(ctx context.Context, users *dao.Users) error
 }
 
 func (u UsersRepositoryImpl) GetByToken(ctx context.Context, plainText, tokenScope string) (*dao.Users, error) {
+	ctx, span := startQuery(ctx, "users.GetByToken")
+	defer span.End()
+
 	var user dao.Users
 	hash := sha256.Sum256([]byte(plainText))
 
 	script := `
-	SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version
+	SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.banned, u.version
 	FROM users u INNER JOIN token t ON u.id = t.user_id
 	WHERE t.hash= $1 AND t.expiry > $2 AND t.scope=$3
 	`
 
 	args := []any{hash[:], time.Now(), tokenScope}
 
-	err := u.Db.QueryRowContext(ctx, script, args...).Scan(
+	err := conn(ctx, u.Db).QueryRowContext(ctx, script, args...).Scan(
 		&user.Id,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.Hash,
 		&user.Activated,
+		&user.Banned,
 		&user.Version,
 	)
 
@@ -143,5 +197,61 @@ func (u UsersRepositoryImpl) GetByToken(ctx context.Context, plainText, tokenSco
 
 	}
 
+	span.SetRows(1)
 	return &user, nil
 }
+
+func (u UsersRepositoryImpl) GetAll(ctx context.Context, filters dto.UserFilters, sorting utils.Sortings, paginating utils.Paginatings) ([]*dao.Users, int64, error) {
+	ctx, span := startQuery(ctx, "users.GetAll",
+		tracing.Bool("filter.query", filters.Query != ""),
+		tracing.String("filter.status", filters.Status),
+		tracing.String("sort", sorting.Sorts),
+		tracing.Int("page_size", paginating.PageSize),
+	)
+	defer span.End()
+
+	// Name or email contain the query taken literally, a banned user only match the banned status
+	script := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, banned, version
+		FROM users
+		WHERE (name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR email ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+				AND ($2 = 'all'
+					OR ($2 = 'activated' AND activated AND NOT banned)
+					OR ($2 = 'unactivated' AND NOT activated AND NOT banned)
+					OR ($2 = 'banned' AND banned))
+		ORDER BY %s, id ASC
+		LIMIT $3 OFFSET $4`, sorting.OrderBy())
+	args := []any{escapeLike(filters.Query), filters.Status, paginating.Limit(), paginating.Offset()}
+	rows, err := conn(ctx, u.Db).QueryContext(ctx, script, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*dao.Users
+	var totalRecords int64
+	for rows.Next() {
+		var user dao.Users
+		err = rows.Scan(
+			&totalRecords,
+			&user.Id,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.Hash,
+			&user.Activated,
+			&user.Banned,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	span.SetRows(len(users))
+	return users, totalRecords, nil
+}
@@ -4,26 +4,91 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"music-echo/api/handler"
+	mw "music-echo/api/middleware"
+	"music-echo/api/repository"
+	"music-echo/utils/metrics"
 	"net/http"
 )
 
-func Init(e *echo.Echo, tracksHandler handler.TracksHandler, userHandler handler.UserHandler) {
-	e.Use(middleware.Logger())
+func Init(e *echo.Echo, tracksHandler handler.TracksHandler, userHandler handler.UserHandler, searchHandler handler.SearchHandler, importHandler handler.ImportHandler, audioHandler handler.AudioHandler, imageHandler handler.ImageHandler, playsHandler handler.PlaysHandler, chartsHandler handler.ChartsHandler, recommendationsHandler handler.RecommendationsHandler, artistHandler handler.ArtistHandler, genresHandler handler.GenresHandler, adminHandler handler.AdminHandler, m *mw.Middleware, trustedOrigins []string) {
+	e.Use(mw.RequestID)
+	e.Use(mw.Tracing)
+	e.Use(mw.RequestLogger)
+	// outside Recover so a panic is counted as the 500 it turn into
+	e.Use(mw.Metrics)
 	e.Use(middleware.Recover())
+	// before Authenticate, preflight requests carry no credentials
+	e.Use(mw.CORS(trustedOrigins))
+	e.Use(m.Authenticate)
+	e.Use(m.RateLimit("default"))
+
+	e.GET("/metrics", echo.WrapHandler(metrics.Default.Handler()))
 
 	e.GET("/v1/healthcheck", func(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadGateway)
 	})
 
+	// catalog writes are audited, only users granted the catalog permission make them
+	catalog := m.RequirePermission(repository.PermissionCatalog)
+
 	// tracks
 	e.GET("/v1/tracks", tracksHandler.GetAllTracks)
-	e.POST("/v1/tracks", tracksHandler.CreateTracks)
+	e.POST("/v1/tracks", tracksHandler.CreateTracks, catalog)
 	e.GET("/v1/tracks/:tracksId", tracksHandler.GetTracksByID)
-	e.PATCH("/v1/tracks/:tracksId", tracksHandler.UpdateTracks)
-	e.DELETE("/v1/tracks/:tracksId", tracksHandler.DeleteTracks)
-	e.PATCH("/v1/tracks/:tracksId/like", tracksHandler.LikeTracks)
+	e.PATCH("/v1/tracks/:tracksId", tracksHandler.UpdateTracks, catalog)
+	e.DELETE("/v1/tracks/:tracksId", tracksHandler.DeleteTracks, catalog)
+	e.PATCH("/v1/tracks/:tracksId/like", tracksHandler.LikeTracks, m.RequireActivatedUser)
+	e.POST("/v1/tracks/:tracksId/audio", audioHandler.UploadAudio, catalog)
+	e.GET("/v1/tracks/:tracksId/stream", audioHandler.StreamAudio, m.RequireActivatedUser)
+	e.POST("/v1/tracks/:tracksId/image", imageHandler.UploadTrackImage, catalog)
+	e.GET("/v1/tracks/:tracksId/similar", recommendationsHandler.GetSimilarTracks)
+	e.GET("/v1/tracks/export", tracksHandler.ExportTracks)
+	e.POST("/v1/tracks/import", importHandler.ImportTracks, catalog)
+	e.GET("/v1/tracks/import/:jobId", importHandler.GetImportJob, catalog)
+
+	// artists
+	e.GET("/v1/artists/:artistId", artistHandler.GetArtist)
+	e.POST("/v1/artists/:artistId/follow", artistHandler.FollowArtist, m.RequireActivatedUser)
+	e.DELETE("/v1/artists/:artistId/follow", artistHandler.UnfollowArtist, m.RequireActivatedUser)
+	e.POST("/v1/artists/:artistId/image", imageHandler.UploadArtistImage, catalog)
+
+	// images
+	e.GET("/v1/images/:hash/:file", imageHandler.GetImage)
+
+	// genres
+	e.GET("/v1/genres", genresHandler.GetAllGenres)
+
+	// charts
+	e.GET("/v1/charts/tracks", chartsHandler.GetTrackChart)
+
+	// search
+	e.GET("/v1/search/suggest", searchHandler.Suggest, m.RateLimit("search"))
 
 	// users
-	e.POST("/v1/users", userHandler.CreateUser)
-	e.PUT("v1/users/activated", userHandler.ActivateUser)
+	e.POST("/v1/users", userHandler.CreateUser, m.RateLimit("strict"))
+	e.PUT("v1/users/activated", userHandler.ActivateUser, m.RateLimit("strict"))
+
+	// me
+	e.POST("/v1/me/plays", playsHandler.RecordPlay, m.RequireActivatedUser)
+	e.GET("/v1/me/history", playsHandler.GetHistory, m.RequireActivatedUser)
+	e.GET("/v1/me/recommendations", recommendationsHandler.GetRecommendations, m.RequireActivatedUser)
+	e.GET("/v1/me/feed", artistHandler.GetFeed, m.RequireActivatedUser)
+
+	// tokens
+	e.POST("/v1/tokens/authentication", userHandler.CreateAuthenticationToken, m.RateLimit("strict"))
+
+	// admin
+	admin := e.Group("/v1/admin", m.RequirePermission(repository.PermissionAdmin))
+	admin.GET("/users", adminHandler.GetAllUsers)
+	admin.GET("/users/:userId", adminHandler.GetUser)
+	admin.POST("/users/:userId/activate", adminHandler.ActivateUser)
+	admin.POST("/users/:userId/deactivate", adminHandler.DeactivateUser)
+	admin.POST("/users/:userId/ban", adminHandler.BanUser)
+	admin.POST("/users/:userId/unban", adminHandler.UnbanUser)
+	admin.DELETE("/users/:userId/tokens", adminHandler.RevokeTokens)
+	admin.POST("/users/:userId/welcome", adminHandler.ResendWelcome)
+	admin.POST("/users/:userId/permissions", adminHandler.GrantPermissions)
+	admin.DELETE("/users/:userId/permissions", adminHandler.RevokePermissions)
+	admin.POST("/users/:userId/unlock", userHandler.UnlockUser)
+	admin.GET("/audit", adminHandler.GetAuditEvents)
 }
@@ -4,12 +4,20 @@ import (
 	"context"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/gommon/log"
 	_ "github.com/lib/pq"
+	"golang.org/x/time/rate"
+	"log/slog"
 	"music-echo/api/handler"
+	"music-echo/api/job"
+	"music-echo/api/middleware"
 	"music-echo/api/repository"
 	"music-echo/api/router"
 	"music-echo/utils"
+	"music-echo/utils/lockout"
+	"music-echo/utils/metrics"
+	"music-echo/utils/storage"
+	"music-echo/utils/tracing"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -18,47 +26,129 @@ import (
 
 func main() {
 	// CONFIG
+	cfg := utils.LoadConfig()
+	// Logger
+	slog.SetDefault(utils.NewLogger(os.Stdout, cfg.LogLevel))
+	// Tracing
+	switch cfg.TracingExporter {
+	case "otlp":
+		tracing.SetDefault(tracing.NewTracer(tracing.NewOTLPExporter(cfg.OTLPEndpoint, cfg.ServiceName)))
+	default:
+		tracing.SetDefault(tracing.NewTracer(tracing.NoopExporter{}))
+	}
 	// Echo
 	e := echo.New()
+	e.HideBanner = true
+	// Client IP, X-Forwarded-For is only believed when it was set by a trusted proxy
+	e.IPExtractor = echo.ExtractIPDirect()
+	if len(cfg.TrustedProxies) > 0 {
+		// Only the configured proxies, not echo's default of every loopback, link-local and private address
+		trustOptions := []echo.TrustOption{
+			echo.TrustLoopback(false),
+			echo.TrustLinkLocal(false),
+			echo.TrustPrivateNet(false),
+		}
+		for _, proxy := range cfg.TrustedProxies {
+			_, ipNet, err := net.ParseCIDR(proxy)
+			if err != nil {
+				slog.Error("invalid trusted proxy", "proxy", proxy, "error", err)
+				os.Exit(1)
+			}
+			trustOptions = append(trustOptions, echo.TrustIPRange(ipNet))
+		}
+		e.IPExtractor = echo.ExtractIPFromXFFHeader(trustOptions...)
+	}
 	// Database
 	Db := utils.OpenDB()
 	defer Db.Close()
+	// Metrics
+	metrics.RegisterDB(metrics.Default, Db)
+	metrics.RegisterRuntime(metrics.Default)
 
 	// SECONDARY
 	// Validator
 	validators := validator.New()
+	// Blob storage
+	var blobStore storage.BlobStore
+	switch cfg.BlobBackend {
+	case "postgres":
+		blobStore = storage.NewLargeObjectStore(Db, repository.NewTxManagerImpl(Db))
+	default:
+		blobStore = storage.NewFileStore(cfg.BlobDir)
+	}
 	// Mailer
 	mailer := utils.NewMailer("sandbox.smtp.mailtrap.io", "cf2a8d3974ccd2", "6d08f2c539ad01", "Spookify <no-reply@spookify.rdtyads.com>", 2525)
+	// Lockout
+	accountGuard := lockout.NewGuard(cfg.LockoutAccountAttempts, cfg.LockoutBaseDelay, cfg.LockoutMaxDelay, cfg.LockoutForget)
+	ipGuard := lockout.NewGuard(cfg.LockoutIPAttempts, cfg.LockoutBaseDelay, cfg.LockoutMaxDelay, cfg.LockoutForget)
 
 	// PRIMARY
-	// Repository
+	// Repository, configured timeouts come on top of the defaults
+	queryTimeouts := repository.QueryTimeouts{Default: cfg.QueryTimeout, Operations: make(map[string]time.Duration)}
+	for query, timeout := range repository.DefaultQueryTimeouts.Operations {
+		queryTimeouts.Operations[query] = timeout
+	}
+	for query, timeout := range cfg.QueryTimeouts {
+		queryTimeouts.Operations[query] = timeout
+	}
+	repository.SetQueryTimeouts(queryTimeouts)
 	artistRepository := repository.NewArtistRepositoryImpl(Db)
 	tracksRepository := repository.NewTracksRepositoryImpl(Db)
 	likesRepository := repository.NewLikeRepositoryImpl(Db)
 	usersRepository := repository.NewUserRepositoryImpl(Db)
 	tokenRepository := repository.NewTokenRepositoryImpl(Db)
+	searchRepository := repository.NewSearchRepositoryImpl(Db)
+	imagesRepository := repository.NewImagesRepositoryImpl(Db)
+	playsRepository := repository.NewPlaysRepositoryImpl(Db)
+	chartsRepository := repository.NewChartsRepositoryImpl(Db)
+	recommendationsRepository := repository.NewRecommendationsRepositoryImpl(Db)
+	followsRepository := repository.NewFollowsRepositoryImpl(Db)
+	genresRepository := repository.NewGenresRepositoryImpl(Db)
+	txManager := repository.NewTxManagerImpl(Db)
+	permissionsRepository := repository.NewPermissionsRepositoryImpl(Db)
+	auditRepository := repository.NewAuditRepositoryImpl(Db)
 	// Handler
-	tracksHandler := handler.NewTracksHandlerImpl(tracksRepository, artistRepository, likesRepository, validators)
-	userHandler := handler.NewUserHandlerImpl(validators, usersRepository, tokenRepository, mailer)
+	tracksHandler := handler.NewTracksHandlerImpl(tracksRepository, artistRepository, likesRepository, genresRepository, auditRepository, txManager, validators)
+	userHandler := handler.NewUserHandlerImpl(validators, usersRepository, tokenRepository, auditRepository, txManager, mailer, accountGuard, ipGuard)
+	searchHandler := handler.NewSearchHandlerImpl(searchRepository)
+	importHandler := handler.NewImportHandlerImpl(tracksRepository, artistRepository, genresRepository, auditRepository, txManager, validators)
+	audioHandler := handler.NewAudioHandlerImpl(tracksRepository, artistRepository, playsRepository, genresRepository, auditRepository, txManager, blobStore, cfg.MaxAudioSize)
+	imageHandler := handler.NewImageHandlerImpl(tracksRepository, artistRepository, imagesRepository, auditRepository, txManager, blobStore)
+	playsHandler := handler.NewPlaysHandlerImpl(playsRepository, validators)
+	chartsHandler := handler.NewChartsHandlerImpl(chartsRepository)
+	recommendationsHandler := handler.NewRecommendationsHandlerImpl(recommendationsRepository)
+	artistHandler := handler.NewArtistHandlerImpl(artistRepository, followsRepository)
+	genresHandler := handler.NewGenresHandlerImpl(genresRepository)
+	adminHandler := handler.NewAdminHandlerImpl(usersRepository, tokenRepository, permissionsRepository, auditRepository, txManager, mailer, validators)
+	// Middleware
+	rateLimiters := make(map[string]*middleware.RateLimiter)
+	for name, limit := range cfg.RateLimits {
+		rateLimiters[name] = middleware.NewRateLimiter(name, rate.Limit(limit.RPS), limit.Burst, cfg.RateLimitIdle)
+	}
+	m := middleware.NewMiddleware(usersRepository, permissionsRepository, rateLimiters)
 	// Router
-	router.Init(e, tracksHandler, userHandler)
+	router.Init(e, tracksHandler, userHandler, searchHandler, importHandler, audioHandler, imageHandler, playsHandler, chartsHandler, recommendationsHandler, artistHandler, genresHandler, adminHandler, m, cfg.TrustedOrigins)
 
 	// Server (graceful shutdown)
-	e.Logger.SetLevel(log.INFO)
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
+	// Background jobs
+	chartsJob := job.NewChartsJob(chartsRepository, cfg.ChartsInterval)
+	go chartsJob.Run(ctx)
+
 	// start server
 	go func() {
-		e.Logger.Printf("Starting server on %s", "8000")
+		slog.Info("starting server", "addr", ":8000")
 		if err := e.Start(":8000"); err != nil && err != http.ErrServerClosed {
-			e.Logger.Fatalf("Server error: %v", err)
+			slog.Error("server error", "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	// wait for interrupt signal to gracefully shutdowns the server with a timeout of 10 seconds.
 	<-ctx.Done()
-	e.Logger.Print("Shutdown signal received")
+	slog.Info("shutdown signal received")
 
 	// create a timeout context for shutdown (10 seconds)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -66,8 +156,14 @@ func main() {
 
 	// attempt gracefully shutdown
 	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatalf("Server forced to shutdown: %v", err)
+		slog.Error("server forced to shutdown", "error", err)
+		os.Exit(1)
+	}
+
+	// send the spans still queued
+	if err := tracing.Default().Shutdown(ctx); err != nil {
+		slog.Error("flush spans", "error", err)
 	}
 
-	e.Logger.Print("Server gracefully stopped")
+	slog.Info("server gracefully stopped")
 }
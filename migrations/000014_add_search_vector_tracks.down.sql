@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS artist_search_vector_trigger ON artist;
+DROP TRIGGER IF EXISTS tracks_search_vector_trigger ON tracks;
+DROP FUNCTION IF EXISTS artist_search_vector_update();
+DROP FUNCTION IF EXISTS tracks_search_vector_update();
+DROP FUNCTION IF EXISTS tracks_search_vector(TEXT, TEXT[], BIGINT);
+DROP INDEX IF EXISTS tracks_search_vector_idx;
+ALTER TABLE tracks
+    DROP COLUMN IF EXISTS search_vector;
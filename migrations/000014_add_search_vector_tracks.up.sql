@@ -0,0 +1,35 @@
+ALTER TABLE tracks
+    ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION tracks_search_vector(track_title TEXT, track_genre TEXT[], artist_id BIGINT) RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(track_title, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce((SELECT name FROM artist WHERE id = artist_id), '')), 'B') ||
+           setweight(to_tsvector('simple', coalesce(array_to_string(track_genre, ' '), '')), 'C')
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION tracks_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := tracks_search_vector(NEW.title, NEW.genre, NEW.idartist);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tracks_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, genre, idartist ON tracks
+    FOR EACH ROW EXECUTE FUNCTION tracks_search_vector_update();
+
+CREATE OR REPLACE FUNCTION artist_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE tracks SET search_vector = tracks_search_vector(title, genre, idartist) WHERE idartist = NEW.id;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER artist_search_vector_trigger
+    AFTER UPDATE OF name ON artist
+    FOR EACH ROW EXECUTE FUNCTION artist_search_vector_update();
+
+UPDATE tracks
+    SET search_vector = tracks_search_vector(title, genre, idartist);
+
+CREATE INDEX IF NOT EXISTS tracks_search_vector_idx ON tracks USING GIN (search_vector);
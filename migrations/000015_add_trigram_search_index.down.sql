@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS artist_name_trgm_idx;
+DROP INDEX IF EXISTS tracks_title_trgm_idx;
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS tracks_title_trgm_idx ON tracks USING GIN (title gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS artist_name_trgm_idx ON artist USING GIN (name gin_trgm_ops);
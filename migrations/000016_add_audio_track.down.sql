@@ -0,0 +1,7 @@
+ALTER TABLE tracks
+    DROP COLUMN IF EXISTS audio_location,
+    DROP COLUMN IF EXISTS audio_format,
+    DROP COLUMN IF EXISTS audio_mime,
+    DROP COLUMN IF EXISTS audio_size,
+    DROP COLUMN IF EXISTS audio_checksum,
+    DROP COLUMN IF EXISTS audio_updated_at
@@ -0,0 +1,7 @@
+ALTER TABLE tracks
+    ADD COLUMN audio_location TEXT,
+    ADD COLUMN audio_format TEXT,
+    ADD COLUMN audio_mime TEXT,
+    ADD COLUMN audio_size BIGINT,
+    ADD COLUMN audio_checksum TEXT,
+    ADD COLUMN audio_updated_at TIMESTAMP(0) WITH TIME ZONE
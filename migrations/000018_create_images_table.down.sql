@@ -0,0 +1,7 @@
+ALTER TABLE artist
+    DROP COLUMN IF EXISTS image_hash;
+
+ALTER TABLE tracks
+    DROP COLUMN IF EXISTS image_hash;
+
+DROP TABLE IF EXISTS images;
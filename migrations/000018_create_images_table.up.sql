@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS images(
+    hash TEXT NOT NULL,
+    size TEXT NOT NULL,
+    location TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (hash, size)
+);
+
+ALTER TABLE tracks
+    ADD COLUMN image_hash TEXT;
+
+ALTER TABLE artist
+    ADD COLUMN image_hash TEXT;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS plays(
+    id BIGSERIAL PRIMARY KEY,
+    id_users INTEGER NOT NULL,
+    id_tracks INTEGER NOT NULL,
+    played_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    seconds INTEGER NOT NULL DEFAULT 0,
+    client_id TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_user_plays FOREIGN KEY (id_users) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_tracks_plays FOREIGN KEY (id_tracks) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS plays_history_idx ON plays (id_users, played_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS plays_dedup_idx ON plays (id_users, id_tracks, client_id, played_at);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS chart_snapshots;
+
+DROP INDEX IF EXISTS plays_played_at_idx;
+DROP INDEX IF EXISTS likes_created_at_idx;
+
+ALTER TABLE likes
+    DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,18 @@
+-- Likes from before they were timestamped get the epoch, so they don't all land in the first current window
+ALTER TABLE likes
+    ADD COLUMN created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT 'epoch';
+ALTER TABLE likes
+    ALTER COLUMN created_at SET DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS likes_created_at_idx ON likes (created_at);
+CREATE INDEX IF NOT EXISTS plays_played_at_idx ON plays (played_at);
+
+CREATE TABLE IF NOT EXISTS chart_snapshots(
+    chart_window TEXT NOT NULL,
+    computed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    id_tracks INTEGER NOT NULL,
+    score BIGINT NOT NULL,
+    previous_score BIGINT NOT NULL,
+    CONSTRAINT fk_tracks_chart_snapshots FOREIGN KEY (id_tracks) REFERENCES tracks(id) ON DELETE CASCADE,
+    PRIMARY KEY (chart_window, computed_at, id_tracks)
+);
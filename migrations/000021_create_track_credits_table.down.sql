@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS likes_user_idx;
+
+DROP TABLE IF EXISTS track_credits;
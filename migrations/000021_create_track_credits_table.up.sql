@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS track_credits(
+    id_tracks INTEGER NOT NULL,
+    id_artist INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    CONSTRAINT fk_tracks_track_credits FOREIGN KEY (id_tracks) REFERENCES tracks(id) ON DELETE CASCADE,
+    CONSTRAINT fk_artist_track_credits FOREIGN KEY (id_artist) REFERENCES artist(id) ON DELETE CASCADE,
+    PRIMARY KEY (id_tracks, id_artist, role)
+);
+
+CREATE INDEX IF NOT EXISTS track_credits_artist_idx ON track_credits (id_artist);
+CREATE INDEX IF NOT EXISTS likes_user_idx ON likes (id_users);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tracks_artist_created_at_idx;
+
+DROP TABLE IF EXISTS follows;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS follows(
+    id_users INTEGER NOT NULL,
+    id_artist INTEGER NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_user_follows FOREIGN KEY (id_users) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_artist_follows FOREIGN KEY (id_artist) REFERENCES artist(id) ON DELETE CASCADE,
+    PRIMARY KEY (id_users, id_artist)
+);
+
+CREATE INDEX IF NOT EXISTS follows_artist_idx ON follows (id_artist);
+CREATE INDEX IF NOT EXISTS tracks_artist_created_at_idx ON tracks (idartist, created_at DESC, id DESC);
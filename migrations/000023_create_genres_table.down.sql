@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS genre_descendants(TEXT);
+DROP FUNCTION IF EXISTS genre_slug(TEXT);
+DROP FUNCTION IF EXISTS genre_slugify(TEXT);
+
+DROP TABLE IF EXISTS genres;
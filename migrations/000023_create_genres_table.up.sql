@@ -0,0 +1,71 @@
+CREATE TABLE IF NOT EXISTS genres(
+    id SERIAL PRIMARY KEY,
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    parent_id INTEGER,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    CONSTRAINT fk_parent_genres FOREIGN KEY (parent_id) REFERENCES genres(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS genres_aliases_idx ON genres USING GIN (aliases);
+
+-- genre_slugify turn a display name into a slug, ex: "R&B / Soul" -> "r-b-soul"
+CREATE OR REPLACE FUNCTION genre_slugify(genre TEXT) RETURNS TEXT AS $$
+    SELECT trim(BOTH '-' FROM regexp_replace(lower(trim(genre)), '[^a-z0-9]+', '-', 'g'))
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- genre_slug resolve a slug, display name or alias to its slug, NULL when unknown
+CREATE OR REPLACE FUNCTION genre_slug(genre TEXT) RETURNS TEXT AS $$
+    SELECT slug
+    FROM genres
+    WHERE slug = genre_slugify(genre)
+       OR lower(name) = lower(trim(genre))
+       OR lower(trim(genre)) = ANY(aliases)
+    ORDER BY slug = genre_slugify(genre) DESC
+    LIMIT 1
+$$ LANGUAGE SQL STABLE;
+
+-- genre_descendants list the slug of a genre and of every genre below it, ex: metal -> {metal, death-metal, ...}
+CREATE OR REPLACE FUNCTION genre_descendants(genre TEXT) RETURNS TEXT[] AS $$
+    WITH RECURSIVE tree AS (
+        SELECT id, slug FROM genres WHERE slug = genre_slug(genre)
+        UNION
+        SELECT g.id, g.slug FROM genres g JOIN tree ON g.parent_id = tree.id
+    )
+    SELECT COALESCE(array_agg(slug), '{}') FROM tree
+$$ LANGUAGE SQL STABLE;
+
+INSERT INTO genres (slug, name, aliases) VALUES
+    ('rock', 'Rock', '{}'),
+    ('metal', 'Metal', '{"heavy metal"}'),
+    ('pop', 'Pop', '{}'),
+    ('hip-hop', 'Hip-Hop', '{"hip hop", "hiphop", "rap"}'),
+    ('electronic', 'Electronic', '{"electronica", "edm"}'),
+    ('jazz', 'Jazz', '{}'),
+    ('classical', 'Classical', '{}'),
+    ('r-b', 'R&B', '{"rnb", "r&b", "rhythm and blues"}'),
+    ('soul', 'Soul', '{}'),
+    ('blues', 'Blues', '{}'),
+    ('country', 'Country', '{}'),
+    ('folk', 'Folk', '{}'),
+    ('reggae', 'Reggae', '{}')
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO genres (slug, name, parent_id, aliases)
+SELECT child.slug, child.name, parent.id, child.aliases
+FROM (VALUES
+    ('alternative', 'Alternative', 'rock', '{"alternative rock", "alt rock"}'::TEXT[]),
+    ('grunge', 'Grunge', 'rock', '{}'),
+    ('punk', 'Punk', 'rock', '{"punk rock"}'),
+    ('indie', 'Indie', 'rock', '{"indie rock"}'),
+    ('death-metal', 'Death Metal', 'metal', '{}'),
+    ('black-metal', 'Black Metal', 'metal', '{}'),
+    ('thrash-metal', 'Thrash Metal', 'metal', '{"thrash"}'),
+    ('house', 'House', 'electronic', '{}'),
+    ('techno', 'Techno', 'electronic', '{}'),
+    ('trip-hop', 'Trip-Hop', 'electronic', '{"trip hop"}'),
+    ('disco', 'Disco', 'pop', '{}'),
+    ('synth-pop', 'Synth-Pop', 'pop', '{"synthpop", "synth pop"}')
+) AS child(slug, name, parent, aliases)
+    JOIN genres parent ON parent.slug = child.parent
+ON CONFLICT (slug) DO NOTHING;
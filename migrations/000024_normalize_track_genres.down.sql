@@ -0,0 +1,20 @@
+DROP TRIGGER IF EXISTS genres_search_vector_trigger ON genres;
+DROP FUNCTION IF EXISTS genres_search_vector_update();
+
+CREATE OR REPLACE FUNCTION tracks_search_vector(track_title TEXT, track_genre TEXT[], artist_id BIGINT) RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(track_title, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce((SELECT name FROM artist WHERE id = artist_id), '')), 'B') ||
+           setweight(to_tsvector('simple', coalesce(array_to_string(track_genre, ' '), '')), 'C')
+$$ LANGUAGE SQL STABLE;
+
+-- Put back the original spelling of the genres, a track edited since keep its new genres
+UPDATE tracks t
+SET genre = b.genre
+FROM tracks_genre_backup b
+WHERE b.id_tracks = t.id
+  AND t.genre IS NOT DISTINCT FROM b.normalized;
+
+UPDATE tracks
+    SET search_vector = tracks_search_vector(title, genre, idartist);
+
+DROP TABLE IF EXISTS tracks_genre_backup;
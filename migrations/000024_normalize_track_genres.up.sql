@@ -0,0 +1,85 @@
+-- Keep every array as it was so the down migration can put it back
+CREATE TABLE IF NOT EXISTS tracks_genre_backup(
+    id_tracks INTEGER PRIMARY KEY,
+    genre TEXT[] NOT NULL,
+    normalized TEXT[],
+    CONSTRAINT fk_tracks_genre_backup FOREIGN KEY (id_tracks) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+INSERT INTO tracks_genre_backup (id_tracks, genre)
+SELECT id, genre FROM tracks WHERE genre IS NOT NULL
+ON CONFLICT (id_tracks) DO NOTHING;
+
+-- Every free-text genre already on a track must resolve to a genre: a value close enough to a
+-- known genre become one of its aliases, anything else become a genre of its own
+DO $$
+DECLARE
+    raw TEXT;
+    match INTEGER;
+BEGIN
+    FOR raw IN
+        SELECT DISTINCT lower(trim(g))
+        FROM tracks, unnest(genre) AS g
+        WHERE trim(g) <> ''
+    LOOP
+        IF genre_slug(raw) IS NOT NULL THEN
+            CONTINUE;
+        END IF;
+
+        SELECT id INTO match
+        FROM genres
+        WHERE similarity(raw, lower(name)) >= 0.4
+        ORDER BY similarity(raw, lower(name)) DESC
+        LIMIT 1;
+
+        IF match IS NOT NULL THEN
+            UPDATE genres SET aliases = array_append(aliases, raw) WHERE id = match;
+        ELSE
+            INSERT INTO genres (slug, name)
+            VALUES (genre_slugify(raw), initcap(raw))
+            ON CONFLICT (slug) DO UPDATE SET aliases = array_append(genres.aliases, raw);
+        END IF;
+    END LOOP;
+END
+$$;
+
+-- Arrays now hold slugs, index the name and aliases of each genre along with its slug so a search
+-- for "hip hop" still find hip-hop
+CREATE OR REPLACE FUNCTION tracks_search_vector(track_title TEXT, track_genre TEXT[], artist_id BIGINT) RETURNS tsvector AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(track_title, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce((SELECT name FROM artist WHERE id = artist_id), '')), 'B') ||
+           setweight(to_tsvector('simple', coalesce(array_to_string(track_genre, ' '), '') || ' ' ||
+               coalesce((SELECT string_agg(name || ' ' || array_to_string(aliases, ' '), ' ')
+                         FROM genres
+                         WHERE slug = ANY(track_genre)), '')), 'C')
+$$ LANGUAGE SQL STABLE;
+
+-- Rewrite every array as slugs, keeping the first occurrence order and dropping duplicates
+UPDATE tracks
+SET genre = ARRAY(
+    SELECT slug
+    FROM (
+        SELECT genre_slug(g) AS slug, MIN(position) AS position
+        FROM unnest(genre) WITH ORDINALITY AS raw(g, position)
+        WHERE genre_slug(g) IS NOT NULL
+        GROUP BY 1
+    ) normalized
+    ORDER BY position
+)
+WHERE genre IS NOT NULL;
+
+UPDATE tracks_genre_backup b
+SET normalized = t.genre
+FROM tracks t
+WHERE t.id = b.id_tracks;
+
+CREATE OR REPLACE FUNCTION genres_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE tracks SET search_vector = tracks_search_vector(title, genre, idartist) WHERE NEW.slug = ANY(genre);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER genres_search_vector_trigger
+    AFTER UPDATE OF name, aliases ON genres
+    FOR EACH ROW EXECUTE FUNCTION genres_search_vector_update();
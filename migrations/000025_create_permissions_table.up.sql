@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS permissions(
+    id BIGSERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions(
+    user_id BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL,
+    CONSTRAINT fk_user_users_permissions FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE,
+    CONSTRAINT fk_permission_users_permissions FOREIGN KEY (permission_id) REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES ('admin')
+ON CONFLICT (code) DO NOTHING;
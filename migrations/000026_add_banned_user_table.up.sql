@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS banned BOOL NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS audit_events(
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_id BIGINT,
+    action TEXT NOT NULL,
+    entity TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    CONSTRAINT fk_actor_audit_events FOREIGN KEY (actor_id) REFERENCES users ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON audit_events (entity, entity_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_id, created_at);
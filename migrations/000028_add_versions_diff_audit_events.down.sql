@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS audit_events_created_at_idx;
+
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS version_before,
+    DROP COLUMN IF EXISTS version_after,
+    DROP COLUMN IF EXISTS diff;
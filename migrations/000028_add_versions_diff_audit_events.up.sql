@@ -0,0 +1,6 @@
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS version_before BIGINT,
+    ADD COLUMN IF NOT EXISTS version_after BIGINT,
+    ADD COLUMN IF NOT EXISTS diff JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
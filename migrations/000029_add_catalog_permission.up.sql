@@ -0,0 +1,13 @@
+INSERT INTO permissions (code)
+VALUES ('catalog')
+ON CONFLICT (code) DO NOTHING;
+
+-- Catalog writes were open to every activated user until now, only admins keep them, anyone
+-- else must be granted catalog through POST /v1/admin/users/:userId/permissions
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT up.user_id, catalog.id
+FROM users_permissions up
+         INNER JOIN permissions admin ON admin.id = up.permission_id AND admin.code = 'admin'
+         CROSS JOIN permissions catalog
+WHERE catalog.code = 'catalog'
+ON CONFLICT DO NOTHING;
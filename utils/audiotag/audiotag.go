@@ -0,0 +1,168 @@
+// Package audiotag read the tags and the true duration of audio files, with no cgo or external tools.
+// Supported are MP3 (ID3v2, ID3v1, Xing/VBRI/CBR duration), FLAC, Ogg Vorbis/Opus and MP4/M4A.
+package audiotag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+type Tags struct {
+	Format   string        `json:"format"`
+	Title    string        `json:"title,omitempty"`
+	Artist   string        `json:"artist,omitempty"`
+	Album    string        `json:"album,omitempty"`
+	Year     int64         `json:"year,omitempty"`
+	Genre    []string      `json:"genre,omitempty"`
+	Duration time.Duration `json:"-"`
+}
+
+// Read detect the format of r from its first bytes and parse its tags and duration
+func Read(r io.ReadSeeker) (*Tags, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 12)
+	_, err = io.ReadFull(r, head)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return readFLAC(r, 0)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return readOgg(r, size)
+	case bytes.Equal(head[4:8], []byte("ftyp")):
+		return readMP4(r, size)
+	case bytes.HasPrefix(head, []byte("ID3")):
+		// FLAC files occasionally carry an ID3v2 tag in front
+		tagSize, err := id3v2Size(head)
+		if err == nil && isAt(r, tagSize, "fLaC") {
+			return readFLAC(r, tagSize)
+		}
+		return readMP3(r, size)
+	case head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		return readMP3(r, size)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// isAt report whether r hold magic at offset
+func isAt(r io.ReadSeeker, offset int64, magic string) bool {
+	buf := make([]byte, len(magic))
+	_, err := r.Seek(offset, io.SeekStart)
+	if err != nil {
+		return false
+	}
+	_, err = io.ReadFull(r, buf)
+	return err == nil && string(buf) == magic
+}
+
+// parseYear take the year out of the usual date shapes, ex: 1975, 1975-10-31, 1975-10-31T00:00:00Z
+func parseYear(s string) int64 {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0
+	}
+	year, err := strconv.ParseInt(s[:4], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// splitGenre split multi-valued genre strings, ex: "Rock; Pop" or "Rock/Pop"
+func splitGenre(s string) []string {
+	var genres []string
+	for _, genre := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '/' || r == '\x00' }) {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			genres = append(genres, genre)
+		}
+	}
+	return genres
+}
+
+// setComment apply a Vorbis comment (also used by FLAC) to tags
+func (t *Tags) setComment(comment string) {
+	key, value, ok := strings.Cut(comment, "=")
+	if !ok {
+		return
+	}
+
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		t.Title = value
+	case "ARTIST":
+		t.Artist = value
+	case "ALBUM":
+		t.Album = value
+	case "DATE", "YEAR":
+		t.Year = parseYear(value)
+	case "GENRE":
+		t.Genre = append(t.Genre, splitGenre(value)...)
+	}
+}
+
+// readVorbisComments parse a Vorbis comment block, all lengths are little endian
+func (t *Tags) readVorbisComments(b []byte) error {
+	r := bytes.NewReader(b)
+	var vendorLength uint32
+	err := readLE(r, &vendorLength)
+	if err != nil {
+		return err
+	}
+	_, err = r.Seek(int64(vendorLength), io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	var count uint32
+	err = readLE(r, &count)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		var length uint32
+		err = readLE(r, &length)
+		if err != nil {
+			return err
+		}
+		if int64(length) > int64(r.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+		comment := make([]byte, length)
+		_, err = io.ReadFull(r, comment)
+		if err != nil {
+			return err
+		}
+		t.setComment(string(comment))
+	}
+	return nil
+}
+
+func readLE(r io.Reader, v any) error {
+	return binary.Read(r, binary.LittleEndian, v)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,221 @@
+package audiotag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func id3Frame(id string, value []byte) []byte {
+	frame := []byte(id)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(value)))
+	return append(frame, 0, 0)
+}
+
+func id3Tag(frames ...[]byte) []byte {
+	var body []byte
+	for _, frame := range frames {
+		body = append(body, frame...)
+	}
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(header, body...)
+}
+
+func textFrame(id string, encoding byte, text []byte) []byte {
+	value := append([]byte{encoding}, text...)
+	return append(id3Frame(id, value), value...)
+}
+
+// mp3Frames build n MPEG-1 Layer III frames at 128 kbps and 44.1 kHz, 417 bytes each
+func mp3Frames(n int, first []byte) []byte {
+	var audio []byte
+	for i := 0; i < n; i++ {
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		if i == 0 && first != nil {
+			copy(frame[36:], first)
+		}
+		audio = append(audio, frame...)
+	}
+	return audio
+}
+
+func assertDuration(t *testing.T, got, want time.Duration) {
+	t.Helper()
+	if diff := got - want; diff > 10*time.Millisecond || diff < -10*time.Millisecond {
+		t.Errorf("duration = %v, want %v", got, want)
+	}
+}
+
+func TestReadMP3ConstantBitrate(t *testing.T) {
+	utf16Artist := []byte{0xFF, 0xFE, 'R', 0, 'a', 0, 'd', 0, 'i', 0, 'o', 0, 'h', 0, 'e', 0, 'a', 0, 'd', 0}
+	file := id3Tag(
+		textFrame("TIT2", 0, []byte("Paranoid Android")),
+		textFrame("TPE1", 1, utf16Artist),
+		textFrame("TYER", 0, []byte("1997")),
+		textFrame("TCON", 0, []byte("(17)")),
+	)
+	file = append(file, mp3Frames(100, nil)...)
+
+	tags, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tags{Format: "mp3", Title: "Paranoid Android", Artist: "Radiohead", Year: 1997, Genre: []string{"Rock"}}
+	assertDuration(t, tags.Duration, 2606250*time.Microsecond)
+	tags.Duration = 0
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %+v, want %+v", tags, want)
+	}
+}
+
+func TestReadMP3Xing(t *testing.T) {
+	xing := []byte("Xing")
+	xing = binary.BigEndian.AppendUint32(xing, 1)
+	xing = binary.BigEndian.AppendUint32(xing, 1000)
+	file := mp3Frames(3, xing)
+
+	tags, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 frames of 1152 samples at 44.1 kHz
+	assertDuration(t, tags.Duration, 26122449*time.Microsecond)
+}
+
+func vorbisComments(comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 4)
+	b = append(b, "test"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, comment := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(comment)))
+		b = append(b, comment...)
+	}
+	return b
+}
+
+func TestReadFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	// 44.1 kHz, 2 channels, 16 bits, 441000 samples
+	binary.BigEndian.PutUint64(streamInfo[10:], 44100<<44|1<<41|15<<36|441000)
+	comments := vorbisComments("TITLE=One More Time", "ARTIST=Daft Punk", "DATE=2001-03-12", "GENRE=House;Disco")
+
+	file := []byte("fLaC")
+	file = append(file, 0, 0, 0, 34)
+	file = append(file, streamInfo...)
+	file = append(file, 0x84, 0, byte(len(comments)>>8), byte(len(comments)))
+	file = append(file, comments...)
+
+	tags, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tags{Format: "flac", Title: "One More Time", Artist: "Daft Punk", Year: 2001, Genre: []string{"House", "Disco"}}
+	assertDuration(t, tags.Duration, 10*time.Second)
+	tags.Duration = 0
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %+v, want %+v", tags, want)
+	}
+}
+
+func oggPage(granule uint64, packet []byte) []byte {
+	page := []byte("OggS")
+	page = append(page, 0, 0)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = append(page, make([]byte, 12)...)
+
+	var segments []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+	return append(page, packet...)
+}
+
+func TestReadOggVorbis(t *testing.T) {
+	id := []byte("\x01vorbis")
+	id = append(id, 0, 0, 0, 0, 2)
+	id = binary.LittleEndian.AppendUint32(id, 48000)
+	id = append(id, make([]byte, 14)...)
+	comment := append([]byte("\x03vorbis"), vorbisComments("title=Windowlicker", "artist=Aphex Twin", "date=1999")...)
+
+	var file []byte
+	file = append(file, oggPage(0, id)...)
+	file = append(file, oggPage(0, comment)...)
+	file = append(file, oggPage(480000, make([]byte, 300))...)
+
+	tags, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tags{Format: "ogg", Title: "Windowlicker", Artist: "Aphex Twin", Year: 1999}
+	assertDuration(t, tags.Duration, 10*time.Second)
+	tags.Duration = 0
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %+v, want %+v", tags, want)
+	}
+}
+
+func atom(atomType string, children ...[]byte) []byte {
+	var body []byte
+	for _, child := range children {
+		body = append(body, child...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, atomType...)
+	return append(b, body...)
+}
+
+func dataAtom(value []byte) []byte {
+	return atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, value)
+}
+
+func TestReadMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 215000)
+
+	file := atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	file = append(file, atom("moov",
+		atom("mvhd", mvhd),
+		atom("udta", atom("meta", []byte{0, 0, 0, 0}, atom("ilst",
+			atom("\xa9nam", dataAtom([]byte("Teardrop"))),
+			atom("\xa9ART", dataAtom([]byte("Massive Attack"))),
+			atom("\xa9day", dataAtom([]byte("1998-04-20T07:00:00Z"))),
+			atom("gnre", dataAtom([]byte{0, 28})),
+		))),
+	)...)
+	file = append(file, atom("mdat", make([]byte, 64))...)
+
+	tags, err := Read(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tags{Format: "m4a", Title: "Teardrop", Artist: "Massive Attack", Year: 1998, Genre: []string{"Trip-Hop"}}
+	assertDuration(t, tags.Duration, 215*time.Second)
+	tags.Duration = 0
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %+v, want %+v", tags, want)
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	_, err := Read(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")))
+	if err != ErrUnsupportedFormat {
+		t.Errorf("err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
@@ -0,0 +1,70 @@
+package audiotag
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	// flacBlockLimit skip metadata blocks bigger than this (cover art mostly)
+	flacBlockLimit = 1 << 20
+)
+
+// readFLAC walk the metadata blocks after the "fLaC" marker found at offset
+func readFLAC(r io.ReadSeeker, offset int64) (*Tags, error) {
+	tags := &Tags{Format: "flac"}
+
+	offset += 4
+	for {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		header := make([]byte, 4)
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return nil, err
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4 + length
+
+		if (blockType == flacStreamInfo || blockType == flacVorbisComment) && length <= flacBlockLimit {
+			block := make([]byte, length)
+			_, err = io.ReadFull(r, block)
+			if err != nil {
+				return nil, err
+			}
+
+			switch blockType {
+			case flacStreamInfo:
+				if len(block) < 18 {
+					return nil, errors.New("FLAC STREAMINFO too short")
+				}
+				// 20 bits sample rate, 3 bits channels, 5 bits bits per sample, 36 bits total samples
+				packed := binary.BigEndian.Uint64(block[10:18])
+				sampleRate := int64(packed >> 44)
+				totalSamples := int64(packed & 0xFFFFFFFFF)
+				if sampleRate > 0 {
+					tags.Duration = seconds(float64(totalSamples) / float64(sampleRate))
+				}
+			case flacVorbisComment:
+				err = tags.readVorbisComments(block)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if last {
+			break
+		}
+	}
+
+	return tags, nil
+}
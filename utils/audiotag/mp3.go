@@ -0,0 +1,368 @@
+package audiotag
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// id3TextFrameLimit skip text frames bigger than this, no sane title need more
+const id3TextFrameLimit = 64 << 10
+
+// id3v1Genres are the ID3v1 genre numbers still referenced by TCON, ex: "(17)" is Rock
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz", "Metal",
+	"New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno", "Industrial",
+	"Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk",
+	"Fusion", "Trance", "Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic",
+	"Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult", "Gangsta",
+	"Top 40", "Christian Rap", "Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes",
+	"Trailer", "Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+// MPEG audio bitrates in kbps, indexed by [version is MPEG-1][layer - 1][bitrate index]
+var mpegBitrates = [2][3][16]int64{
+	{ // MPEG-2 and 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+}
+
+// MPEG audio sample rates, indexed by [version bits][sample rate index]
+var mpegSampleRates = [4][3]int64{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+func readMP3(r io.ReadSeeker, size int64) (*Tags, error) {
+	tags := &Tags{Format: "mp3"}
+
+	// ID3v2 at the start
+	var audioStart int64
+	header := make([]byte, 10)
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if tagSize, err := id3v2Size(header); err == nil {
+		err = tags.readID3v2(r, header)
+		if err != nil {
+			return nil, err
+		}
+		audioStart = tagSize
+	}
+
+	// ID3v1 at the end, only used to fill what ID3v2 didn't have
+	var audioEnd = size
+	if size >= 128 {
+		id3v1 := make([]byte, 128)
+		_, err = r.Seek(size-128, io.SeekStart)
+		if err == nil {
+			_, err = io.ReadFull(r, id3v1)
+		}
+		if err == nil && string(id3v1[:3]) == "TAG" {
+			tags.readID3v1(id3v1)
+			audioEnd -= 128
+		}
+	}
+
+	tags.Duration, err = mp3Duration(r, audioStart, audioEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// id3v2Size return the full size of the ID3v2 tag starting header, header included
+func id3v2Size(header []byte) (int64, error) {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0, errors.New("no ID3v2 tag")
+	}
+
+	size := 10 + syncsafe(header[6:10])
+	// footer present
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	return size, nil
+}
+
+// syncsafe decode an ID3v2 integer which only use the low 7 bits of each byte
+func syncsafe(b []byte) int64 {
+	var n int64
+	for _, c := range b {
+		n = n<<7 | int64(c&0x7F)
+	}
+	return n
+}
+
+func (t *Tags) readID3v2(r io.ReadSeeker, header []byte) error {
+	version := header[3]
+	end := 10 + syncsafe(header[6:10])
+
+	// extended header, skip it
+	offset := int64(10)
+	if header[5]&0x40 != 0 {
+		extended := make([]byte, 4)
+		_, err := io.ReadFull(r, extended)
+		if err != nil {
+			return err
+		}
+		extendedSize := int64(binary.BigEndian.Uint32(extended))
+		if version == 4 {
+			extendedSize = syncsafe(extended)
+		} else {
+			extendedSize += 4
+		}
+		offset += extendedSize
+	}
+
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+
+	for offset+int64(headerLength) <= end {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		frameHeader := make([]byte, headerLength)
+		_, err = io.ReadFull(r, frameHeader)
+		if err != nil {
+			return err
+		}
+
+		// padding reached
+		if frameHeader[0] == 0 {
+			break
+		}
+
+		id := string(frameHeader[:idLength])
+		var frameSize int64
+		switch version {
+		case 2:
+			frameSize = int64(frameHeader[3])<<16 | int64(frameHeader[4])<<8 | int64(frameHeader[5])
+		case 3:
+			frameSize = int64(binary.BigEndian.Uint32(frameHeader[4:8]))
+		default:
+			frameSize = syncsafe(frameHeader[4:8])
+		}
+		offset += int64(headerLength) + frameSize
+		if offset > end {
+			break
+		}
+
+		if id[0] != 'T' || frameSize == 0 || frameSize > id3TextFrameLimit {
+			continue
+		}
+		frame := make([]byte, frameSize)
+		_, err = io.ReadFull(r, frame)
+		if err != nil {
+			return err
+		}
+		t.setID3Frame(id, decodeID3Text(frame))
+	}
+
+	return nil
+}
+
+func (t *Tags) setID3Frame(id, value string) {
+	switch id {
+	case "TIT2", "TT2":
+		t.Title = value
+	case "TPE1", "TP1":
+		t.Artist = value
+	case "TALB", "TAL":
+		t.Album = value
+	case "TYER", "TYE", "TDRC", "TDOR":
+		if t.Year == 0 {
+			t.Year = parseYear(value)
+		}
+	case "TCON", "TCO":
+		t.Genre = id3Genres(value)
+	}
+}
+
+// decodeID3Text decode a text frame, the first byte tell the encoding
+func decodeID3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+
+	var s string
+	data := frame[1:]
+	switch frame[0] {
+	case 1, 2:
+		bigEndian := frame[0] == 2
+		if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			bigEndian, data = true, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			bigEndian, data = false, data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(data[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(data[2*i:])
+			}
+		}
+		s = string(utf16.Decode(units))
+	case 3:
+		s = string(data)
+	default:
+		s = latin1(data)
+	}
+
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// id3Genres resolve TCON values, which may reference ID3v1 genres, ex: "(17)", "17", "(17)Rock" or "Rock"
+func id3Genres(value string) []string {
+	var genres []string
+	for _, genre := range splitGenre(value) {
+		if strings.HasPrefix(genre, "(") {
+			ref, rest, _ := strings.Cut(genre[1:], ")")
+			if rest != "" {
+				genre = rest
+			} else {
+				genre = ref
+			}
+		}
+		if i, err := strconv.Atoi(genre); err == nil {
+			if i < 0 || i >= len(id3v1Genres) {
+				continue
+			}
+			genre = id3v1Genres[i]
+		}
+		genres = append(genres, genre)
+	}
+	return genres
+}
+
+func (t *Tags) readID3v1(tag []byte) {
+	field := func(b []byte) string {
+		return strings.TrimSpace(strings.TrimRight(latin1(b), "\x00"))
+	}
+
+	if t.Title == "" {
+		t.Title = field(tag[3:33])
+	}
+	if t.Artist == "" {
+		t.Artist = field(tag[33:63])
+	}
+	if t.Album == "" {
+		t.Album = field(tag[63:93])
+	}
+	if t.Year == 0 {
+		t.Year = parseYear(field(tag[93:97]))
+	}
+	if len(t.Genre) == 0 && int(tag[127]) < len(id3v1Genres) {
+		t.Genre = []string{id3v1Genres[tag[127]]}
+	}
+}
+
+// mp3Duration compute the duration from the first frame, using the Xing/Info or VBRI frame
+// count when present and the bitrate otherwise (constant bitrate)
+func mp3Duration(r io.ReadSeeker, start, end int64) (time.Duration, error) {
+	_, err := r.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 64<<10)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		header := binary.BigEndian.Uint32(buf[i:])
+		versionBits := header >> 19 & 3
+		layer := 4 - int(header>>17&3)
+		bitrateIndex := header >> 12 & 0xF
+		sampleIndex := header >> 10 & 3
+		if versionBits == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || sampleIndex == 3 {
+			continue
+		}
+
+		mpeg1 := versionBits == 3
+		mpeg1Index := 0
+		if mpeg1 {
+			mpeg1Index = 1
+		}
+		bitrate := mpegBitrates[mpeg1Index][layer-1][bitrateIndex] * 1000
+		sampleRate := mpegSampleRates[versionBits][sampleIndex]
+		mono := header>>6&3 == 3
+
+		samplesPerFrame := int64(1152)
+		if layer == 1 {
+			samplesPerFrame = 384
+		} else if layer == 3 && !mpeg1 {
+			samplesPerFrame = 576
+		}
+
+		// Xing/Info header sit right after the side information
+		sideInfo := 32
+		switch {
+		case mpeg1 && mono:
+			sideInfo = 17
+		case !mpeg1 && !mono:
+			sideInfo = 17
+		case !mpeg1 && mono:
+			sideInfo = 9
+		}
+		frame := buf[i:]
+		if xing := 4 + sideInfo; len(frame) >= xing+12 {
+			tag := string(frame[xing : xing+4])
+			flags := binary.BigEndian.Uint32(frame[xing+4:])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				frames := int64(binary.BigEndian.Uint32(frame[xing+8:]))
+				return framesDuration(frames, samplesPerFrame, sampleRate), nil
+			}
+		}
+		if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+			frames := int64(binary.BigEndian.Uint32(frame[36+14:]))
+			return framesDuration(frames, samplesPerFrame, sampleRate), nil
+		}
+
+		audioBytes := end - start - int64(i)
+		return seconds(float64(audioBytes*8) / float64(bitrate)), nil
+	}
+
+	return 0, errors.New("no MPEG audio frame found")
+}
+
+func framesDuration(frames, samplesPerFrame, sampleRate int64) time.Duration {
+	return seconds(float64(frames*samplesPerFrame) / float64(sampleRate))
+}
@@ -0,0 +1,157 @@
+package audiotag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// mp4MoovLimit is the biggest moov atom read in memory, cover art can make it large
+const mp4MoovLimit = 16 << 20
+
+// readMP4 find the moov atom and read the movie duration and the iTunes style metadata
+func readMP4(r io.ReadSeeker, size int64) (*Tags, error) {
+	tags := &Tags{Format: "m4a"}
+
+	var offset int64
+	for offset+8 <= size {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		atomType, headerSize, atomSize, err := readAtomHeader(r, size-offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if atomType == "moov" {
+			if atomSize-headerSize > mp4MoovLimit {
+				return nil, errors.New("MP4 moov atom too big")
+			}
+			moov := make([]byte, atomSize-headerSize)
+			_, err = io.ReadFull(r, moov)
+			if err != nil {
+				return nil, err
+			}
+			err = tags.readMoov(moov)
+			if err != nil {
+				return nil, err
+			}
+			return tags, nil
+		}
+
+		offset += atomSize
+	}
+
+	return nil, errors.New("no MP4 moov atom found")
+}
+
+// readAtomHeader read size and type, size 1 means a 64 bits size follow and 0 means up to the end
+func readAtomHeader(r io.Reader, remaining int64) (string, int64, int64, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	atomType := string(header[4:8])
+	headerSize := int64(8)
+	atomSize := int64(binary.BigEndian.Uint32(header))
+	switch atomSize {
+	case 0:
+		atomSize = remaining
+	case 1:
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		headerSize = 16
+		atomSize = int64(binary.BigEndian.Uint64(header))
+	}
+	if atomSize < headerSize || atomSize > remaining {
+		return "", 0, 0, errors.New("invalid MP4 atom size")
+	}
+
+	return atomType, headerSize, atomSize, nil
+}
+
+// atoms split b into its child atoms by type
+func atoms(b []byte) map[string][]byte {
+	children := make(map[string][]byte)
+	r := bytes.NewReader(b)
+	for r.Len() >= 8 {
+		remaining := int64(r.Len())
+		atomType, headerSize, atomSize, err := readAtomHeader(r, remaining)
+		if err != nil {
+			break
+		}
+		start := len(b) - int(remaining) + int(headerSize)
+		end := len(b) - int(remaining) + int(atomSize)
+		if _, ok := children[atomType]; !ok {
+			children[atomType] = b[start:end]
+		}
+		_, _ = r.Seek(int64(end), io.SeekStart)
+	}
+	return children
+}
+
+func (t *Tags) readMoov(moov []byte) error {
+	children := atoms(moov)
+
+	// mvhd: version 0 has 32 bits times, version 1 has 64 bits times
+	mvhd := children["mvhd"]
+	switch {
+	case len(mvhd) >= 20 && mvhd[0] == 0:
+		timescale := int64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration := int64(binary.BigEndian.Uint32(mvhd[16:20]))
+		if timescale > 0 {
+			t.Duration = seconds(float64(duration) / float64(timescale))
+		}
+	case len(mvhd) >= 32 && mvhd[0] == 1:
+		timescale := int64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration := int64(binary.BigEndian.Uint64(mvhd[24:32]))
+		if timescale > 0 {
+			t.Duration = seconds(float64(duration) / float64(timescale))
+		}
+	default:
+		return errors.New("missing MP4 mvhd atom")
+	}
+
+	// moov.udta.meta.ilst, meta start with 4 bytes of version and flags
+	meta := atoms(children["udta"])["meta"]
+	if len(meta) < 4 {
+		return nil
+	}
+	for itemType, item := range atoms(atoms(meta[4:])["ilst"]) {
+		// data: 4 bytes type, 4 bytes locale, then the value
+		data := atoms(item)["data"]
+		if len(data) < 8 {
+			continue
+		}
+		value := data[8:]
+
+		switch itemType {
+		case "\xa9nam":
+			t.Title = string(value)
+		case "\xa9ART":
+			t.Artist = string(value)
+		case "\xa9alb":
+			t.Album = string(value)
+		case "\xa9day":
+			t.Year = parseYear(string(value))
+		case "\xa9gen":
+			t.Genre = splitGenre(string(value))
+		case "gnre":
+			// ID3v1 genre number plus one
+			if len(value) >= 2 {
+				i := int(binary.BigEndian.Uint16(value)) - 1
+				if i >= 0 && i < len(id3v1Genres) {
+					t.Genre = []string{id3v1Genres[i]}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package audiotag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// oggHeaderLimit is how much of the stream is read looking for the header packets
+	oggHeaderLimit = 1 << 20
+	// oggTailSize is how much of the end is searched for the last page
+	oggTailSize = 64 << 10
+)
+
+// readOgg read the identification and comment packets of an Ogg Vorbis or Opus stream,
+// the duration is the granule position of the last page
+func readOgg(r io.ReadSeeker, size int64) (*Tags, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	packets, err := oggPackets(io.LimitReader(r, oggHeaderLimit), 2)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := &Tags{}
+	var sampleRate, preSkip int64
+	id, comment := packets[0], packets[1]
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		tags.Format = "ogg"
+		sampleRate = int64(binary.LittleEndian.Uint32(id[12:16]))
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return nil, errors.New("missing Vorbis comment header")
+		}
+		err = tags.readVorbisComments(comment[7:])
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 12:
+		tags.Format = "opus"
+		// Opus granule positions always count at 48 kHz
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:12]))
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return nil, errors.New("missing Opus tags header")
+		}
+		err = tags.readVorbisComments(comment[8:])
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	granule, err := oggLastGranule(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if sampleRate > 0 && granule > preSkip {
+		tags.Duration = seconds(float64(granule-preSkip) / float64(sampleRate))
+	}
+
+	return tags, nil
+}
+
+// oggPackets reassemble the first n packets from the pages of r, packets may span pages
+func oggPackets(r io.Reader, n int) ([][]byte, error) {
+	var packets [][]byte
+	var packet []byte
+	header := make([]byte, 27)
+	for len(packets) < n {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, errors.New("truncated Ogg header pages")
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, errors.New("invalid Ogg page")
+		}
+
+		segments := make([]byte, header[26])
+		_, err = io.ReadFull(r, segments)
+		if err != nil {
+			return nil, err
+		}
+		for _, length := range segments {
+			data := make([]byte, length)
+			_, err = io.ReadFull(r, data)
+			if err != nil {
+				return nil, err
+			}
+			packet = append(packet, data...)
+			// a segment shorter than 255 end the packet
+			if length < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+	return packets[:n], nil
+}
+
+// oggLastGranule find the last page in the tail of the stream and return its granule position
+func oggLastGranule(r io.ReadSeeker, size int64) (int64, error) {
+	start := max(size-oggTailSize, 0)
+	_, err := r.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	tail := make([]byte, size-start)
+	_, err = io.ReadFull(r, tail)
+	if err != nil {
+		return 0, err
+	}
+
+	i := bytes.LastIndex(tail, []byte("OggS"))
+	if i < 0 || i+14 > len(tail) {
+		return 0, errors.New("no Ogg page found at the end of the stream")
+	}
+	return int64(binary.LittleEndian.Uint64(tail[i+6:])), nil
+}
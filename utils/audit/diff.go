@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+)
+
+// Change is a field whose value changed, From is null for a created entity and To for a deleted one
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Diff compare the JSON encoding of before and after field by field, either may be nil. Fields
+// hidden from JSON are left out, and so are the ignored ones, ex: the version
+func Diff(before, after any, ignore ...string) (map[string]Change, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(map[string]Change)
+	for key, from := range beforeFields {
+		to := afterFields[key]
+		if !slices.Contains(ignore, key) && !reflect.DeepEqual(from, to) {
+			diff[key] = Change{From: from, To: to}
+		}
+	}
+	for key, to := range afterFields {
+		if _, ok := beforeFields[key]; !ok && to != nil && !slices.Contains(ignore, key) {
+			diff[key] = Change{From: nil, To: to}
+		}
+	}
+	return diff, nil
+}
+
+// fields decode the JSON object v encode to, numbers are kept as written
+func fields(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded map[string]any
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type track struct {
+	Id      int64    `json:"id"`
+	Title   string   `json:"title"`
+	Genre   []string `json:"genre"`
+	Version int64    `json:"version"`
+	Secret  string   `json:"-"`
+}
+
+func TestDiffChangedFields(t *testing.T) {
+	before := &track{Id: 1, Title: "Old", Genre: []string{"rock"}, Version: 1, Secret: "a"}
+	after := &track{Id: 1, Title: "New", Genre: []string{"rock", "pop"}, Version: 2, Secret: "b"}
+
+	diff, err := Diff(before, after, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := json.Marshal(diff)
+	want := `{"genre":{"from":["rock"],"to":["rock","pop"]},"title":{"from":"Old","to":"New"}}`
+	if string(got) != want {
+		t.Fatalf("diff = %s, want %s", got, want)
+	}
+}
+
+func TestDiffCreatedAndDeleted(t *testing.T) {
+	var none *track
+	created, err := Diff(none, &track{Id: 7, Title: "New"}, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || created["title"].From != nil || created["title"].To != "New" {
+		t.Fatalf("created diff = %v", created)
+	}
+
+	deleted, err := Diff(&track{Id: 7, Title: "Old"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 || deleted["id"].From != json.Number("7") || deleted["id"].To != nil {
+		t.Fatalf("deleted diff = %v", deleted)
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	diff, err := Diff(track{Id: 1, Title: "Same"}, track{Id: 1, Title: "Same", Version: 3}, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Fatalf("diff = %v, want none", diff)
+	}
+}
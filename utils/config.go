@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"github.com/joho/godotenv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Database, QueryTimeouts override QueryTimeout per repository query ex: "tracks.GetAll"
+	QueryTimeout  time.Duration
+	QueryTimeouts map[string]time.Duration
+	// Logging, one of debug, info, warn or error
+	LogLevel string
+	// Storage
+	BlobBackend  string
+	BlobDir      string
+	MaxAudioSize int64
+	// Charts
+	ChartsInterval time.Duration
+	// Rate limiting, keyed by route group
+	RateLimits     map[string]RateLimitConfig
+	RateLimitIdle  time.Duration
+	TrustedProxies []string
+	// Tracing, TracingExporter is none or otlp
+	TracingExporter string
+	OTLPEndpoint    string
+	ServiceName     string
+	// CORS, origins the web player may call the API from
+	TrustedOrigins []string
+	// Lockout after failed logins and activations
+	LockoutAccountAttempts int
+	LockoutIPAttempts      int
+	LockoutBaseDelay       time.Duration
+	LockoutMaxDelay        time.Duration
+	LockoutForget          time.Duration
+}
+
+// RateLimitConfig is a token bucket refilled at RPS tokens per second up to Burst tokens
+type RateLimitConfig struct {
+	RPS   float64
+	Burst int
+}
+
+// LoadConfig read the configuration from environment variables (and .env when present)
+func LoadConfig() Config {
+	_ = godotenv.Load()
+
+	return Config{
+		QueryTimeout:   time.Duration(getEnvInt("DB_QUERY_TIMEOUT_MS", 3000)) * time.Millisecond,
+		QueryTimeouts:  getEnvDurations("DB_QUERY_TIMEOUTS"),
+		LogLevel:       getEnv("LOG_LEVEL", "info"),
+		BlobBackend:    getEnv("BLOB_BACKEND", "file"),
+		BlobDir:        getEnv("BLOB_DIR", "storage"),
+		MaxAudioSize:   getEnvInt("MAX_AUDIO_SIZE", 50<<20),
+		ChartsInterval: time.Duration(getEnvPositiveInt("CHARTS_INTERVAL_MINUTES", 10)) * time.Minute,
+		RateLimits: map[string]RateLimitConfig{
+			"default": getEnvRateLimit("RATE_LIMIT_DEFAULT", RateLimitConfig{RPS: 10, Burst: 20}),
+			"search":  getEnvRateLimit("RATE_LIMIT_SEARCH", RateLimitConfig{RPS: 5, Burst: 10}),
+			"strict":  getEnvRateLimit("RATE_LIMIT_STRICT", RateLimitConfig{RPS: 0.1, Burst: 5}),
+		},
+		RateLimitIdle:          time.Duration(getEnvInt("RATE_LIMIT_IDLE_MINUTES", 10)) * time.Minute,
+		TrustedProxies:         getEnvList("TRUSTED_PROXIES"),
+		TracingExporter:        getEnv("TRACING_EXPORTER", "none"),
+		OTLPEndpoint:           getEnv("OTLP_ENDPOINT", "http://localhost:4318/v1/traces"),
+		ServiceName:            getEnv("SERVICE_NAME", "music-echo"),
+		TrustedOrigins:         getEnvList("TRUSTED_ORIGINS"),
+		LockoutAccountAttempts: int(getEnvInt("LOCKOUT_ACCOUNT_ATTEMPTS", 5)),
+		LockoutIPAttempts:      int(getEnvInt("LOCKOUT_IP_ATTEMPTS", 20)),
+		LockoutBaseDelay:       time.Duration(getEnvInt("LOCKOUT_BASE_MINUTES", 1)) * time.Minute,
+		LockoutMaxDelay:        time.Duration(getEnvInt("LOCKOUT_MAX_MINUTES", 60)) * time.Minute,
+		LockoutForget:          time.Duration(getEnvInt("LOCKOUT_FORGET_HOURS", 24)) * time.Hour,
+	}
+}
+
+func getEnv(key, def string) string {
+	s := os.Getenv(key)
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func getEnvInt(key string, def int64) int64 {
+	i, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// getEnvPositiveInt read an integer that must be above zero, such as a ticker interval, anything else fall back to def
+func getEnvPositiveInt(key string, def int64) int64 {
+	i := getEnvInt(key, def)
+	if i <= 0 {
+		return def
+	}
+	return i
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// getEnvRateLimit read a rate limit written as "rps,burst", ex: "0.1,5" for 6 per minute and bursts of 5
+func getEnvRateLimit(key string, def RateLimitConfig) RateLimitConfig {
+	rps, burst, ok := strings.Cut(os.Getenv(key), ",")
+	if !ok {
+		return def
+	}
+
+	var limit RateLimitConfig
+	var err error
+	limit.RPS, err = strconv.ParseFloat(strings.TrimSpace(rps), 64)
+	if err != nil {
+		return def
+	}
+	limit.Burst, err = strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil {
+		return def
+	}
+	return limit
+}
+
+// getEnvDurations read durations written as "name=duration" pairs, ex: "tracks.GetAll=5s,tracks.Export=0s",
+// malformed pairs are skipped
+func getEnvDurations(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, pair := range getEnvList(key) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations
+}
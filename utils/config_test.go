@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadConfigChartsInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 10 * time.Minute},
+		{"5", 5 * time.Minute},
+		{"0", 10 * time.Minute},
+		{"-3", 10 * time.Minute},
+		{"soon", 10 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Setenv("CHARTS_INTERVAL_MINUTES", test.value)
+		if got := LoadConfig().ChartsInterval; got != test.want {
+			t.Fatalf("CHARTS_INTERVAL_MINUTES=%q: interval = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
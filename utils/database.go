@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/joho/godotenv"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 )
@@ -14,7 +14,8 @@ func OpenDB() *sql.DB {
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		slog.Error("load .env file", "error", err)
+		os.Exit(1)
 	}
 
 	// Retrieve environment variables
@@ -33,7 +34,11 @@ func OpenDB() *sql.DB {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	db.PingContext(ctx)
-	fmt.Println("success")
+	err = db.PingContext(ctx)
+	if err != nil {
+		slog.Error("ping database", "host", dbHost, "name", dbName, "error", err)
+	} else {
+		slog.Info("database connection established", "host", dbHost, "name", dbName)
+	}
 	return db
 }
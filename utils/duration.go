@@ -9,8 +9,18 @@ import (
 
 type Duration int64
 
+// String format Duration the same way as in JSON, ex: 123 seconds
+func (d Duration) String() string {
+	return fmt.Sprintf("%d seconds", d)
+}
+
+// Milliseconds return Duration in milliseconds, as used by XSPF
+func (d Duration) Milliseconds() int64 {
+	return int64(d) * 1000
+}
+
 func (d Duration) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%d seconds", d)
+	jsonValue := d.String()
 
 	quotedJSONValue := strconv.Quote(jsonValue)
 
@@ -25,19 +35,39 @@ func (d *Duration) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidDurationFormat
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	i, err := ParseDuration(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+
+	*d = i
+
+	return nil
+}
+
+// ParseDuration parse the "123 seconds" format used in JSON into Duration
+func ParseDuration(s string) (Duration, error) {
+	parts := strings.Split(s, " ")
 
 	// ex: 123 seconds
 	if len(parts) != 2 || parts[1] != "seconds" {
-		return ErrInvalidDurationFormat
+		return 0, ErrInvalidDurationFormat
 	}
 
 	i, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return ErrInvalidDurationFormat
+		return 0, ErrInvalidDurationFormat
 	}
 
-	*d = Duration(i)
+	return Duration(i), nil
+}
 
-	return nil
+// ParseSeconds parse either the "123 seconds" format or bare seconds "123" into Duration
+func ParseSeconds(s string) (Duration, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return Duration(i), nil
+	}
+
+	return ParseDuration(s)
 }
@@ -1,16 +1,21 @@
 package utils
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"io"
-	"log"
+	"log/slog"
+	"music-echo/utils/metrics"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var wg sync.WaitGroup
@@ -50,42 +55,85 @@ func (p Paginatings) Validate(validate *validator.Validate) error {
 	return nil
 }
 
-// Sortings sort the given column from query parameter
+// Sortings sort the given columns from query parameter, ex: sort=-likes,title
 type Sortings struct {
-	Sorts         string
-	SafeSortLists []string
+	Sorts string
+	// SafeSortLists map each allowed sort key to the SQL expression it orders by
+	SafeSortLists map[string]string
 }
 
-func (s Sortings) SortName() string {
-	for _, v := range s.SafeSortLists {
-		if s.Sorts == v {
-			return strings.TrimPrefix(s.Sorts, "-")
+// Keys return the comma-separated sort keys without their direction prefix
+func (s Sortings) Keys() []string {
+	var keys []string
+	for _, v := range strings.Split(s.Sorts, ",") {
+		keys = append(keys, strings.TrimPrefix(strings.TrimSpace(v), "-"))
+	}
+	return keys
+}
+
+// Validate make sure every sort key is in the safe sort list
+func (s Sortings) Validate() error {
+	var seen = make(map[string]bool)
+	for _, key := range s.Keys() {
+		if _, ok := s.SafeSortLists[key]; !ok {
+			return fmt.Errorf("unsupported sort %q, valid options: %s", key, strings.Join(s.Options(), ", "))
 		}
+		if seen[key] {
+			return fmt.Errorf("duplicate sort %q", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Options list the valid sort keys, ascending and descending
+func (s Sortings) Options() []string {
+	var options []string
+	for key := range s.SafeSortLists {
+		options = append(options, key, "-"+key)
 	}
-	panic("unsafe sort")
+	sort.Strings(options)
+	return options
 }
 
-func (s Sortings) SortDirection() string {
-	if strings.HasPrefix(s.Sorts, "-") {
-		return "DESC"
+// OrderBy build the ORDER BY clause from the safe sort list, must be called after Validate
+func (s Sortings) OrderBy() string {
+	var clauses []string
+	for _, v := range strings.Split(s.Sorts, ",") {
+		v = strings.TrimSpace(v)
+		direction := "ASC"
+		if strings.HasPrefix(v, "-") {
+			direction = "DESC"
+		}
+		clauses = append(clauses, s.SafeSortLists[strings.TrimPrefix(v, "-")]+" "+direction)
 	}
-	return "ASC"
+	return strings.Join(clauses, ", ")
 }
 
-// Background goroutines for sent email
-func Background(fn func()) {
+var (
+	backgroundRunning = metrics.Default.Gauge("background_goroutines", "Goroutines started by utils.Background still running.")
+	backgroundPanics  = metrics.Default.Counter("background_panics_total", "Goroutines started by utils.Background that panicked.")
+)
+
+// Background goroutines for sent email, fn get a context that keep the values of ctx, such as
+// the request ID, but isn't canceled when the request is over
+func Background(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
 	// use goroutines to immediately return JSON Response without waiting email to be sent
 	wg.Add(1)
+	backgroundRunning.Inc()
 	go func() {
 		defer wg.Done()
+		defer backgroundRunning.Dec()
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Println(err)
+				backgroundPanics.Inc()
+				slog.ErrorContext(ctx, "background job panicked", "panic", err)
 			}
 		}()
 
-		fn()
+		fn(ctx)
 	}()
 }
 
@@ -102,18 +150,18 @@ func ReadStrQuery(e echo.Context, key string, def string) string {
 }
 
 // ReadIntQuery read query parameter return as Integer
-func ReadIntQuery(e echo.Context, key string, def int64) int64 {
+func ReadIntQuery(e echo.Context, key string, def int64) (int64, error) {
 	var s = e.QueryParam(key)
 	if s == "" {
-		return def
+		return def, nil
 	}
 
-	var i, err = strconv.Atoi(s)
+	var i, err = strconv.ParseInt(s, 10, 64)
 	if err != nil {
-		return def
+		return def, fmt.Errorf("%s must be an integer", key)
 	}
 
-	return int64(i)
+	return i, nil
 }
 
 // ReadCSVQuery read query parameter return as CSV
@@ -127,9 +175,49 @@ func ReadCSVQuery(e echo.Context, key string, def []string) []string {
 
 }
 
+// ReadDurationQuery read query parameter return as Duration, accept "123 seconds" or bare "123"
+func ReadDurationQuery(e echo.Context, key string, def Duration) (Duration, error) {
+	var s = e.QueryParam(key)
+	if s == "" {
+		return def, nil
+	}
+
+	var d, err = ParseSeconds(s)
+	if err != nil {
+		return def, fmt.Errorf("%s must be a duration like \"120 seconds\"", key)
+	}
+
+	return d, nil
+}
+
+// ReadTimeQuery read query parameter return as Time, accept RFC 3339 or YYYY-MM-DD
+func ReadTimeQuery(e echo.Context, key string, def time.Time) (time.Time, error) {
+	var s = e.QueryParam(key)
+	if s == "" {
+		return def, nil
+	}
+
+	var t, err = time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(time.DateOnly, s)
+	if err != nil {
+		return def, fmt.Errorf("%s must be a RFC 3339 timestamp or YYYY-MM-DD date", key)
+	}
+
+	return t, nil
+}
+
 // ReadIdParam for read ID in path parameter
 func ReadIdParam(e echo.Context) (int64, error) {
-	params := e.Param("tracksId")
+	return ReadIdParamByName(e, "tracksId")
+}
+
+// ReadIdParamByName for read ID in the named path parameter
+func ReadIdParamByName(e echo.Context, name string) (int64, error) {
+	params := e.Param(name)
 	id, err := strconv.ParseInt(params, 10, 64)
 
 	if err != nil || id == 0 {
@@ -139,6 +227,27 @@ func ReadIdParam(e echo.Context) (int64, error) {
 	return id, nil
 }
 
+// EncodeCursor build an opaque keyset cursor from the last row of a page
+func EncodeCursor(t time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", t.UnixNano(), id)))
+}
+
+// DecodeCursor read back a cursor built by EncodeCursor
+func DecodeCursor(cursor string) (time.Time, int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+
+	var nanos, id int64
+	_, err = fmt.Sscanf(string(b), "%d:%d", &nanos, &id)
+	if err != nil {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+
+	return time.Unix(0, nanos), id, nil
+}
+
 // ReadJSON for read request body
 func ReadJSON(e echo.Context, dst any) error {
 	err := e.Bind(dst)
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func queryContext(target string) echo.Context {
+	return echo.New().NewContext(httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder())
+}
+
+func TestReadIntQuery(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    int64
+		wantErr string
+	}{
+		{"/tracks", 5, ""},
+		{"/tracks?year_min=1999", 1999, ""},
+		{"/tracks?year_min=-3", -3, ""},
+		{"/tracks?year_min=abc", 5, "year_min must be an integer"},
+		{"/tracks?year_min=19.5", 5, "year_min must be an integer"},
+	}
+	for _, test := range tests {
+		got, err := ReadIntQuery(queryContext(test.target), "year_min", 5)
+		if test.wantErr == "" && err != nil {
+			t.Fatalf("%s: unexpected error %v", test.target, err)
+		}
+		if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+			t.Fatalf("%s: err = %v, want %q", test.target, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Fatalf("%s: got %d, want %d", test.target, got, test.want)
+		}
+	}
+}
+
+var trackSorts = map[string]string{
+	"id":    "t.id",
+	"title": "t.title",
+	"likes": "likes",
+}
+
+func TestSortingsValidate(t *testing.T) {
+	tests := []struct {
+		sorts   string
+		wantErr bool
+	}{
+		{"id", false},
+		{"-likes", false},
+		{"-likes,title", false},
+		{" title , -id ", false},
+		{"password", true},
+		{"-password", true},
+		{"t.id", true},
+		{"likes; DROP TABLE tracks", true},
+		{"--likes", true},
+		{"", true},
+		{"title,-title", true},
+	}
+	for _, test := range tests {
+		err := Sortings{Sorts: test.sorts, SafeSortLists: trackSorts}.Validate()
+		if (err != nil) != test.wantErr {
+			t.Fatalf("%q: err = %v, want error %v", test.sorts, err, test.wantErr)
+		}
+	}
+}
+
+func TestSortingsOrderBy(t *testing.T) {
+	tests := []struct {
+		sorts string
+		want  string
+	}{
+		{"id", "t.id ASC"},
+		{"-likes", "likes DESC"},
+		{"-likes,title", "likes DESC, t.title ASC"},
+		{" title , -id ", "t.title ASC, t.id DESC"},
+	}
+	for _, test := range tests {
+		got := Sortings{Sorts: test.sorts, SafeSortLists: trackSorts}.OrderBy()
+		if got != test.want {
+			t.Fatalf("%q: got %q, want %q", test.sorts, got, test.want)
+		}
+	}
+}
+
+func TestSortingsKeysAndOptions(t *testing.T) {
+	s := Sortings{Sorts: "-likes, title", SafeSortLists: trackSorts}
+
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0] != "likes" || keys[1] != "title" {
+		t.Fatalf("keys = %q", keys)
+	}
+	options := strings.Join(s.Options(), ",")
+	if options != "-id,-likes,-title,id,likes,title" {
+		t.Fatalf("options = %q", options)
+	}
+}
@@ -0,0 +1,131 @@
+// Package lockout count failed attempts per key and lock the key out for an escalating delay
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often forgotten keys are looked for
+const sweepEvery = time.Minute
+
+// Guard track failures per key, ex: "account:someone@example.com" or "ip:10.0.0.1". Every Threshold
+// consecutive failures lock the key out, the first lockout last BaseDelay and each following one
+// twice as long as the one before, up to MaxDelay. A key left alone for Forget start over.
+type Guard struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Forget    time.Duration
+	// Now is the clock lockouts are measured with, time.Now unless a test replace it
+	Now func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	failures    int
+	lockouts    int
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+func NewGuard(threshold int, baseDelay, maxDelay, forget time.Duration) *Guard {
+	return &Guard{
+		Threshold: threshold,
+		BaseDelay: baseDelay,
+		MaxDelay:  maxDelay,
+		Forget:    forget,
+		Now:       time.Now,
+		entries:   make(map[string]*entry),
+	}
+}
+
+// Locked return how long the key is still locked out, 0 when it isn't
+func (g *Guard) Locked(key string) time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	entry := g.entry(key, false)
+	if entry == nil {
+		return 0
+	}
+	return max(entry.lockedUntil.Sub(g.Now()), 0)
+}
+
+// Fail record a failed attempt, it return how long the key is now locked out for when this
+// failure is the one that locked it, 0 otherwise
+func (g *Guard) Fail(key string) time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := g.Now()
+	entry := g.entry(key, true)
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures < g.Threshold {
+		return 0
+	}
+
+	delay := g.BaseDelay << entry.lockouts
+	if delay > g.MaxDelay || delay <= 0 {
+		delay = g.MaxDelay
+	}
+	entry.failures = 0
+	entry.lockouts++
+	entry.lockedUntil = now.Add(delay)
+	return delay
+}
+
+// Succeed forget the failures of the key
+func (g *Guard) Succeed(key string) {
+	g.Unlock(key)
+}
+
+// Unlock lift a lockout and forget the failures of the key
+func (g *Guard) Unlock(key string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.entries, key)
+}
+
+// entry return the entry of key, dropping it first when it has been left alone for Forget,
+// it is created when create is set
+func (g *Guard) entry(key string, create bool) *entry {
+	now := g.Now()
+	g.sweep(now)
+
+	e, ok := g.entries[key]
+	if ok && e.forgotten(now, g.Forget) {
+		delete(g.entries, key)
+		e, ok = nil, false
+	}
+	if !ok && create {
+		e = &entry{}
+		g.entries[key] = e
+	}
+	return e
+}
+
+// sweep drop every entry left alone for Forget, at most once per sweepEvery
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < sweepEvery {
+		return
+	}
+	g.lastSweep = now
+
+	for key, e := range g.entries {
+		if e.forgotten(now, g.Forget) {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// forgotten report whether the entry is no longer locked and its last failure is older than forget
+func (e *entry) forgotten(now time.Time, forget time.Duration) bool {
+	return now.After(e.lockedUntil) && now.Sub(e.lastFailure) > forget
+}
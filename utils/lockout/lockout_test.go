@@ -0,0 +1,132 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+func newTestGuard() (*Guard, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	guard := NewGuard(3, time.Minute, 10*time.Minute, time.Hour)
+	guard.Now = clock.Now
+	return guard, clock
+}
+
+func failN(g *Guard, key string, n int) time.Duration {
+	var delay time.Duration
+	for i := 0; i < n; i++ {
+		delay = g.Fail(key)
+	}
+	return delay
+}
+
+func TestGuardLockAfterThreshold(t *testing.T) {
+	guard, clock := newTestGuard()
+
+	if delay := failN(guard, "account:a", 2); delay != 0 {
+		t.Fatalf("locked after 2 failures for %v", delay)
+	}
+	if locked := guard.Locked("account:a"); locked != 0 {
+		t.Fatalf("locked = %v, want 0", locked)
+	}
+
+	if delay := guard.Fail("account:a"); delay != time.Minute {
+		t.Fatalf("delay = %v, want 1m", delay)
+	}
+	clock.Advance(20 * time.Second)
+	if locked := guard.Locked("account:a"); locked != 40*time.Second {
+		t.Errorf("locked = %v, want 40s", locked)
+	}
+	if locked := guard.Locked("account:b"); locked != 0 {
+		t.Errorf("other key locked = %v, want 0", locked)
+	}
+
+	clock.Advance(40 * time.Second)
+	if locked := guard.Locked("account:a"); locked != 0 {
+		t.Errorf("locked after delay = %v, want 0", locked)
+	}
+}
+
+func TestGuardEscalate(t *testing.T) {
+	guard, clock := newTestGuard()
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, w := range want {
+		if delay := failN(guard, "ip:10.0.0.1", 3); delay != w {
+			t.Errorf("lockout %d: delay = %v, want %v", i+1, delay, w)
+		}
+		clock.Advance(w)
+	}
+}
+
+func TestGuardSucceedReset(t *testing.T) {
+	guard, _ := newTestGuard()
+
+	failN(guard, "account:a", 2)
+	guard.Succeed("account:a")
+	if delay := failN(guard, "account:a", 2); delay != 0 {
+		t.Errorf("locked after reset for %v", delay)
+	}
+}
+
+func TestGuardUnlock(t *testing.T) {
+	guard, _ := newTestGuard()
+
+	failN(guard, "account:a", 3)
+	guard.Unlock("account:a")
+	if locked := guard.Locked("account:a"); locked != 0 {
+		t.Errorf("locked after unlock = %v, want 0", locked)
+	}
+
+	// Escalation start over too
+	if delay := failN(guard, "account:a", 3); delay != time.Minute {
+		t.Errorf("delay = %v, want 1m", delay)
+	}
+}
+
+func TestGuardForget(t *testing.T) {
+	guard, clock := newTestGuard()
+
+	failN(guard, "account:a", 3)
+	clock.Advance(time.Minute)
+	failN(guard, "account:a", 3)
+
+	// Long after the last failure the escalation is forgotten
+	clock.Advance(2 * time.Hour)
+	if delay := failN(guard, "account:a", 3); delay != time.Minute {
+		t.Errorf("delay = %v, want 1m", delay)
+	}
+}
+
+func TestGuardSweepForgottenKeys(t *testing.T) {
+	guard, clock := newTestGuard()
+
+	failN(guard, "account:a", 1)
+	failN(guard, "account:b", 1)
+
+	// Within sweepEvery of the last sweep other keys are left alone
+	clock.Advance(2 * time.Hour)
+	guard.lastSweep = clock.Now().Add(-time.Second)
+	guard.Locked("account:c")
+	if len(guard.entries) != 2 {
+		t.Fatalf("entries = %d, want 2 before the sweep", len(guard.entries))
+	}
+
+	clock.Advance(sweepEvery)
+	guard.Locked("account:c")
+	if len(guard.entries) != 0 {
+		t.Fatalf("entries = %d, want 0 after the sweep", len(guard.entries))
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestLoggerAddRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "info").With("component", "test")
+
+	logger.InfoContext(WithRequestID(context.Background(), "abc123"), "hello")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["request_id"] != "abc123" || record["component"] != "test" || record["msg"] != "hello" {
+		t.Fatalf("record = %v", record)
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "warn")
+
+	logger.Info("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("info logged at warn level: %s", buf.String())
+	}
+	logger.Warn("kept")
+	if buf.Len() == 0 {
+		t.Fatal("warn not logged at warn level")
+	}
+}
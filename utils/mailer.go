@@ -2,18 +2,34 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
 	"github.com/go-mail/mail/v2"
 	"html/template"
-	"log"
+	"log/slog"
+	"music-echo/utils/metrics"
+	"music-echo/utils/tracing"
 	"time"
 )
 
+var emailSends = metrics.Default.Counter("email_sends_total", "Emails sent through Mailer.Send by result.", "template", "result")
+
+const (
+	mailAttempts   = 5
+	mailRetryDelay = 500 * time.Millisecond
+)
+
 //go:embed template/*
 var templateFS embed.FS
 
+// MailDialer deliver messages, *mail.Dialer does it over SMTP
+type MailDialer interface {
+	DialAndSend(m ...*mail.Message) error
+}
+
 type Mailer struct {
-	Dialer *mail.Dialer
+	Dialer MailDialer
 	Sender string
 }
 
@@ -26,7 +42,18 @@ func NewMailer(host, username, password, sender string, port int) Mailer {
 	}
 }
 
-func (m *Mailer) Send(recipient, templateFile string, data any) error {
+func (m *Mailer) Send(ctx context.Context, recipient, templateFile string, data any) (err error) {
+	ctx, span := tracing.Start(ctx, "mailer.Send", tracing.String("mail.template", templateFile))
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		emailSends.Inc(templateFile, result)
+		span.RecordError(err)
+		span.End()
+	}()
+
 	tmpl, err := template.New("email").ParseFS(templateFS, "template/"+templateFile)
 	if err != nil {
 		return err
@@ -57,14 +84,34 @@ func (m *Mailer) Send(recipient, templateFile string, data any) error {
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
-	for i := 0; i < 5; i++ {
+	err = m.deliver(ctx, msg)
+	if err != nil {
+		slog.ErrorContext(ctx, "send email", "template", templateFile, "error", err)
+		return err
+	}
+	return nil
+}
+
+// deliver make up to mailAttempts attempts at sending msg, the wait between two attempts is cut
+// short when ctx is done, ex: on shutdown
+func (m *Mailer) deliver(ctx context.Context, msg *mail.Message) error {
+	var err error
+	for i := 0; i < mailAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(mailRetryDelay):
+			}
+		}
+
+		_, attempt := tracing.Start(ctx, "mailer.Send attempt", tracing.Int("mail.attempt", int64(i+1)))
 		err = m.Dialer.DialAndSend(msg)
+		attempt.RecordError(err)
+		attempt.End()
 		if err == nil {
 			return nil
 		}
-		time.Sleep(500 * time.Millisecond)
 	}
-
-	log.Println(err)
 	return err
 }
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/go-mail/mail/v2"
+	"testing"
+	"time"
+)
+
+// failingDialer fail every delivery, cancel is called after the first one
+type failingDialer struct {
+	attempts int
+	cancel   context.CancelFunc
+}
+
+func (f *failingDialer) DialAndSend(...*mail.Message) error {
+	f.attempts++
+	if f.cancel != nil {
+		f.cancel()
+	}
+	return errors.New("connection refused")
+}
+
+func TestMailerSendRetry(t *testing.T) {
+	dialer := &failingDialer{}
+	mailer := Mailer{Dialer: dialer, Sender: "no-reply@example.com"}
+
+	err := mailer.Send(context.Background(), "someone@example.com", "user_welcome.tmpl", map[string]any{"Name": "someone", "Id": 1})
+	if err == nil || dialer.attempts != mailAttempts {
+		t.Fatalf("attempts = %d err = %v, want %d failed attempts", dialer.attempts, err, mailAttempts)
+	}
+}
+
+func TestMailerSendStopRetryWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dialer := &failingDialer{cancel: cancel}
+	mailer := Mailer{Dialer: dialer, Sender: "no-reply@example.com"}
+
+	start := time.Now()
+	err := mailer.Send(ctx, "someone@example.com", "user_welcome.tmpl", map[string]any{"Name": "someone", "Id": 1})
+	if !errors.Is(err, context.Canceled) || dialer.attempts != 1 {
+		t.Fatalf("attempts = %d err = %v, want a single attempt and context.Canceled", dialer.attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed >= mailRetryDelay {
+		t.Fatalf("send took %v, want no wait for a retry", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"database/sql"
+	"runtime"
+)
+
+// RegisterDB report the connection pool of db, read from sql.DBStats at every scrape
+func RegisterDB(r *Registry, db *sql.DB) {
+	maxOpen := r.Gauge("db_max_open_connections", "Maximum number of open connections to the database.")
+	open := r.Gauge("db_open_connections", "Number of established connections, in use and idle.")
+	inUse := r.Gauge("db_in_use_connections", "Number of connections currently in use.")
+	idle := r.Gauge("db_idle_connections", "Number of idle connections.")
+	waitCount := r.Counter("db_wait_count_total", "Total number of connections waited for.")
+	waitDuration := r.Counter("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.")
+	maxIdleClosed := r.Counter("db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.")
+	maxIdleTimeClosed := r.Counter("db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.")
+	maxLifetimeClosed := r.Counter("db_max_lifetime_closed_total", "Total number of connections closed due to SetConnMaxLifetime.")
+
+	r.OnCollect(func() {
+		stats := db.Stats()
+		maxOpen.Set(float64(stats.MaxOpenConnections))
+		open.Set(float64(stats.OpenConnections))
+		inUse.Set(float64(stats.InUse))
+		idle.Set(float64(stats.Idle))
+		waitCount.Set(float64(stats.WaitCount))
+		waitDuration.Set(stats.WaitDuration.Seconds())
+		maxIdleClosed.Set(float64(stats.MaxIdleClosed))
+		maxIdleTimeClosed.Set(float64(stats.MaxIdleTimeClosed))
+		maxLifetimeClosed.Set(float64(stats.MaxLifetimeClosed))
+	})
+}
+
+// RegisterRuntime report goroutines, memory and garbage collection of the Go runtime,
+// runtime.ReadMemStats stop the world so it is read once per scrape
+func RegisterRuntime(r *Registry) {
+	goroutines := r.Gauge("go_goroutines", "Number of goroutines that currently exist.")
+	threads := r.Gauge("go_threads", "Number of OS threads created.")
+	info := r.Gauge("go_info", "Information about the Go environment.", "version")
+	alloc := r.Gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.")
+	allocTotal := r.Counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.")
+	sys := r.Gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.")
+	heapObjects := r.Gauge("go_memstats_heap_objects", "Number of allocated objects.")
+	heapInUse := r.Gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.")
+	gcCount := r.Counter("go_gc_cycles_total", "Number of completed GC cycles.")
+	gcPause := r.Counter("go_gc_pause_seconds_total", "Total time the world was stopped by the GC.")
+
+	r.OnCollect(func() {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		threadCount, _ := runtime.ThreadCreateProfile(nil)
+
+		goroutines.Set(float64(runtime.NumGoroutine()))
+		threads.Set(float64(threadCount))
+		info.Set(1, runtime.Version())
+		alloc.Set(float64(stats.Alloc))
+		allocTotal.Set(float64(stats.TotalAlloc))
+		sys.Set(float64(stats.Sys))
+		heapObjects.Set(float64(stats.HeapObjects))
+		heapInUse.Set(float64(stats.HeapInuse))
+		gcCount.Set(float64(stats.NumGC))
+		gcPause.Set(float64(stats.PauseTotalNs) / 1e9)
+	})
+}
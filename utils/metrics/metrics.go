@@ -0,0 +1,282 @@
+// Package metrics keep counters, gauges and histograms and write them in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry hold every metric family and the hooks that refresh gauges right before a scrape
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+	hooks    []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is one metric name with a series per combination of label values
+type family struct {
+	name    string
+	help    string
+	kind    metricType
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+// Counter register a counter, it panic when the name is taken by another kind of metric
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, labels, nil)}
+}
+
+// Gauge register a gauge
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, labels, nil)}
+}
+
+// Histogram register a histogram over the given upper bounds, +Inf is implied
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, typeHistogram, labels, buckets)}
+}
+
+// OnCollect run fn before every scrape, ex: to copy sql.DBStats into gauges
+func (r *Registry) OnCollect(fn func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.hooks = append(r.hooks, fn)
+}
+
+func (r *Registry) register(name, help string, kind metricType, labels []string, buckets []float64) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s registered twice with different kinds or labels", name))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with return the series of the label values, creating it on first use
+func (f *family) with(values []string, update func(s *series)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s want %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == typeHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	update(s)
+}
+
+type Counter struct{ f *family }
+
+// Inc add one to the series of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add add v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.f.with(values, func(s *series) { s.value += v })
+}
+
+// Set overwrite the series, only for counters copied from another source such as sql.DBStats
+func (c *Counter) Set(v float64, values ...string) {
+	c.f.with(values, func(s *series) { s.value = v })
+}
+
+type Gauge struct{ f *family }
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.with(values, func(s *series) { s.value = v })
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.f.with(values, func(s *series) { s.value += v })
+}
+
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+type Histogram struct{ f *family }
+
+// Observe count v in every bucket whose upper bound is at least v
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.with(values, func(s *series) {
+		for i, bound := range h.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.sum += v
+		s.samples++
+	})
+}
+
+// WriteTo run the collect hooks then write every family, sorted by name then label values
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.lock.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return slices.Compare(all[i].values, all[j].values) < 0
+	})
+
+	for _, s := range all {
+		if f.kind != typeHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range f.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.values, "le", "+Inf"), s.samples)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, labelPairs(f.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, labelPairs(f.labels, s.values, "", ""), s.samples)
+	}
+}
+
+// Handler serve the registry to a Prometheus scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
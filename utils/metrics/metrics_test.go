@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	inFlight := r.Gauge("in_flight", "Requests in flight.")
+
+	requests.Inc("/v1/tracks/:tracksId", "200")
+	requests.Inc("/v1/tracks/:tracksId", "200")
+	requests.Inc("/v1/tracks", "404")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+
+	want := `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/v1/tracks",status="404"} 1
+requests_total{route="/v1/tracks/:tracksId",status="200"} 2
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("scrape =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	r := NewRegistry()
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.5, 0.1, 1}, "route")
+
+	latency.Observe(0.05, "/")
+	latency.Observe(0.3, "/")
+	latency.Observe(2, "/")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 1
+latency_seconds_bucket{route="/",le="0.5"} 2
+latency_seconds_bucket{route="/",le="1"} 2
+latency_seconds_bucket{route="/",le="+Inf"} 3
+latency_seconds_sum{route="/"} 2.35
+latency_seconds_count{route="/"} 3
+`
+	if got := scrape(t, r); got != want {
+		t.Fatalf("scrape =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("errors_total", "Errors.", "message").Inc("say \"hi\"\\\n")
+
+	if got := scrape(t, r); !strings.Contains(got, `errors_total{message="say \"hi\"\\\n"} 1`) {
+		t.Fatalf("scrape = %s", got)
+	}
+}
+
+func TestOnCollectRunBeforeScrape(t *testing.T) {
+	r := NewRegistry()
+	gauge := r.Gauge("answer", "The answer.")
+	r.OnCollect(func() { gauge.Set(42) })
+
+	if got := scrape(t, r); !strings.Contains(got, "answer 42\n") {
+		t.Fatalf("scrape = %s", got)
+	}
+}
+
+func TestRegisterTwiceReturnSameFamily(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("sends_total", "Sends.", "result").Inc("success")
+	r.Counter("sends_total", "Sends.", "result").Inc("success")
+
+	if got := scrape(t, r); !strings.Contains(got, `sends_total{result="success"} 2`) {
+		t.Fatalf("scrape = %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a gauge under a counter name did not panic")
+		}
+	}()
+	r.Gauge("sends_total", "Sends.", "result")
+}
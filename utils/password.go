@@ -3,8 +3,18 @@ package utils
 import (
 	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"sync"
 )
 
+// passwordCost is the bcrypt cost of every stored password
+const passwordCost = 12
+
+// dummyHash is hashed like a stored password, only to be compared against
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), passwordCost)
+	return hash
+})
+
 type Password struct {
 	Plaintext *string
 	Hash      []byte
@@ -12,7 +22,7 @@ type Password struct {
 
 // Set method for hashing plain text
 func (p *Password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), passwordCost)
 	if err != nil {
 		return err
 	}
@@ -34,3 +44,9 @@ func (p *Password) Matches(plainTextPassword string) (bool, error) {
 	}
 	return true, nil
 }
+
+// MatchesNothing compare plain text against a dummy hash, call it when there's no password to check
+// so an unknown user take as long to answer as a wrong password
+func MatchesNothing(plainTextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(plainTextPassword))
+}
@@ -0,0 +1,212 @@
+// Package recommend score candidate tracks against a listening profile. It know nothing
+// about HTTP or SQL, the caller load seeds and candidates and hand them over.
+package recommend
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Track is the part of a track the scoring look at
+type Track struct {
+	Id        int64
+	ArtistIds []int64
+	Genre     []string
+	Year      int64
+}
+
+// Candidate is a track that may be recommended, CoLikes count the users who liked both
+// a seed and this track
+type Candidate struct {
+	Track
+	CoLikes int64
+}
+
+// Profile is the weighted taste built from seed tracks, genre and artist weights are in [0, 1]
+type Profile struct {
+	Genres  map[string]float64
+	Artists map[int64]float64
+	Year    float64
+}
+
+// Weights balance the signals against each other
+type Weights struct {
+	Genre   float64
+	Artist  float64
+	Year    float64
+	CoLikes float64
+	// YearScale is the year distance at which the year signal has dropped to 1/e
+	YearScale float64
+	// CoLikesHalf is the number of co-likes that give half the co-likes signal
+	CoLikesHalf float64
+}
+
+var DefaultWeights = Weights{
+	Genre:       3,
+	Artist:      2,
+	Year:        1,
+	CoLikes:     2,
+	YearScale:   10,
+	CoLikesHalf: 3,
+}
+
+// Reasons explain which signals contributed to a score
+const (
+	ReasonGenre   = "genre"
+	ReasonArtist  = "artist"
+	ReasonYear    = "year"
+	ReasonCoLikes = "co_likes"
+)
+
+// Scored is a ranked candidate
+type Scored struct {
+	Candidate
+	Score   float64
+	Reasons []string
+}
+
+// NewProfile fold seed tracks into a profile, weights[i] is how much seeds[i] count
+// and a missing weight count as 1
+func NewProfile(seeds []Track, weights []float64) Profile {
+	profile := Profile{
+		Genres:  make(map[string]float64),
+		Artists: make(map[int64]float64),
+	}
+
+	var yearSum, yearWeight float64
+	for i, seed := range seeds {
+		weight := 1.0
+		if i < len(weights) {
+			weight = weights[i]
+		}
+
+		for _, genre := range seed.Genre {
+			profile.Genres[normalize(genre)] += weight
+		}
+		for _, artistId := range seed.ArtistIds {
+			profile.Artists[artistId] += weight
+		}
+		if seed.Year != 0 {
+			yearSum += float64(seed.Year) * weight
+			yearWeight += weight
+		}
+	}
+
+	scale(profile.Genres)
+	scale(profile.Artists)
+	if yearWeight > 0 {
+		profile.Year = yearSum / yearWeight
+	}
+
+	return profile
+}
+
+// Score rate a candidate against the profile, 0 mean nothing in common
+func (w Weights) Score(profile Profile, candidate Candidate) (float64, []string) {
+	var score float64
+	var reasons []string
+
+	if s := genreSimilarity(profile.Genres, candidate.Genre); s > 0 {
+		score += w.Genre * s
+		reasons = append(reasons, ReasonGenre)
+	}
+
+	var artist float64
+	for _, artistId := range candidate.ArtistIds {
+		artist = max(artist, profile.Artists[artistId])
+	}
+	if artist > 0 {
+		score += w.Artist * artist
+		reasons = append(reasons, ReasonArtist)
+	}
+
+	// Year only refine a candidate that already share something
+	if score > 0 && profile.Year != 0 && candidate.Year != 0 && w.YearScale > 0 {
+		distance := math.Abs(profile.Year - float64(candidate.Year))
+		score += w.Year * math.Exp(-distance/w.YearScale)
+		if distance <= w.YearScale {
+			reasons = append(reasons, ReasonYear)
+		}
+	}
+
+	if candidate.CoLikes > 0 && w.CoLikesHalf > 0 {
+		coLikes := float64(candidate.CoLikes)
+		score += w.CoLikes * coLikes / (coLikes + w.CoLikesHalf)
+		reasons = append(reasons, ReasonCoLikes)
+	}
+
+	return score, reasons
+}
+
+// Rank score every candidate and return the best limit of them, highest first, ties broken
+// by track id so the order is stable
+func (w Weights) Rank(profile Profile, candidates []Candidate, limit int) []Scored {
+	var scored []Scored
+	for _, candidate := range candidates {
+		score, reasons := w.Score(profile, candidate)
+		if score <= 0 {
+			continue
+		}
+		scored = append(scored, Scored{Candidate: candidate, Score: score, Reasons: reasons})
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		return scored[i].Id < scored[j].Id
+	})
+
+	if len(scored) > limit {
+		scored = scored[:limit]
+	}
+	return scored
+}
+
+// genreSimilarity is the weighted Jaccard similarity of the profile genres and the candidate genres
+func genreSimilarity(profile map[string]float64, genres []string) float64 {
+	if len(profile) == 0 || len(genres) == 0 {
+		return 0
+	}
+
+	candidate := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		candidate[normalize(genre)] = true
+	}
+
+	var intersection, union float64
+	for genre, weight := range profile {
+		if candidate[genre] {
+			intersection += weight
+			union += 1
+		} else {
+			union += weight
+		}
+	}
+	for genre := range candidate {
+		if _, ok := profile[genre]; !ok {
+			union += 1
+		}
+	}
+
+	return intersection / union
+}
+
+// scale divide every weight by the largest so they end up in [0, 1]
+func scale[K comparable](weights map[K]float64) {
+	var top float64
+	for _, weight := range weights {
+		top = max(top, weight)
+	}
+	if top == 0 {
+		return
+	}
+	for key := range weights {
+		weights[key] /= top
+	}
+}
+
+func normalize(genre string) string {
+	return strings.ToLower(strings.TrimSpace(genre))
+}
@@ -0,0 +1,82 @@
+package recommend
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func ids(scored []Scored) []int64 {
+	var ids []int64
+	for _, s := range scored {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestNewProfile(t *testing.T) {
+	profile := NewProfile([]Track{
+		{Id: 1, ArtistIds: []int64{10}, Genre: []string{"Rock", "Grunge"}, Year: 1990},
+		{Id: 2, ArtistIds: []int64{10, 11}, Genre: []string{"rock"}, Year: 2000},
+	}, []float64{1, 3})
+
+	wantGenres := map[string]float64{"rock": 1, "grunge": 0.25}
+	if !reflect.DeepEqual(profile.Genres, wantGenres) {
+		t.Errorf("genres = %v, want %v", profile.Genres, wantGenres)
+	}
+	wantArtists := map[int64]float64{10: 1, 11: 0.75}
+	if !reflect.DeepEqual(profile.Artists, wantArtists) {
+		t.Errorf("artists = %v, want %v", profile.Artists, wantArtists)
+	}
+	if profile.Year != 1997.5 {
+		t.Errorf("year = %v, want 1997.5", profile.Year)
+	}
+}
+
+func TestScoreNothingInCommon(t *testing.T) {
+	profile := NewProfile([]Track{{Id: 1, ArtistIds: []int64{10}, Genre: []string{"Rock"}, Year: 1990}}, nil)
+
+	score, reasons := DefaultWeights.Score(profile, Candidate{Track: Track{Id: 2, ArtistIds: []int64{20}, Genre: []string{"Jazz"}, Year: 1990}})
+	if score != 0 || reasons != nil {
+		t.Errorf("score = %v %v, want 0 and no reasons", score, reasons)
+	}
+}
+
+func TestScoreSignals(t *testing.T) {
+	profile := NewProfile([]Track{{Id: 1, ArtistIds: []int64{10}, Genre: []string{"Rock", "Grunge"}, Year: 1991}}, nil)
+	candidate := Candidate{Track: Track{Id: 2, ArtistIds: []int64{10}, Genre: []string{"rock"}, Year: 1991}, CoLikes: 3}
+
+	score, reasons := DefaultWeights.Score(profile, candidate)
+
+	// genre 1/2, same artist, same year, co-likes at the half point
+	want := 3*0.5 + 2*1 + 1*1 + 2*0.5
+	if math.Abs(score-want) > 1e-9 {
+		t.Errorf("score = %v, want %v", score, want)
+	}
+	wantReasons := []string{ReasonGenre, ReasonArtist, ReasonYear, ReasonCoLikes}
+	if !reflect.DeepEqual(reasons, wantReasons) {
+		t.Errorf("reasons = %v, want %v", reasons, wantReasons)
+	}
+}
+
+func TestRank(t *testing.T) {
+	profile := NewProfile([]Track{{Id: 1, ArtistIds: []int64{10}, Genre: []string{"Rock"}, Year: 1990}}, nil)
+	candidates := []Candidate{
+		{Track: Track{Id: 2, ArtistIds: []int64{20}, Genre: []string{"Rock"}, Year: 2020}},
+		{Track: Track{Id: 3, ArtistIds: []int64{10}, Genre: []string{"Rock"}, Year: 1990}},
+		{Track: Track{Id: 4, ArtistIds: []int64{30}, Genre: []string{"Jazz"}, Year: 1990}},
+		{Track: Track{Id: 5, ArtistIds: []int64{20}, Genre: []string{"Rock"}, Year: 2020}},
+		{Track: Track{Id: 6, ArtistIds: []int64{30}, Genre: []string{"Jazz"}}, CoLikes: 10},
+	}
+
+	got := ids(DefaultWeights.Rank(profile, candidates, 10))
+	want := []int64{3, 2, 5, 6}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rank = %v, want %v", got, want)
+	}
+
+	got = ids(DefaultWeights.Rank(profile, candidates, 2))
+	if !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("rank limit 2 = %v, want %v", got, want[:2])
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const filePrefix = "file:"
+
+// FileStore keep blobs on the local filesystem under Dir
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) BlobStore {
+	return &FileStore{Dir: dir}
+}
+
+func (f FileStore) Put(ctx context.Context, r io.Reader) (string, int64, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Fan out into sub directories so no directory grows too big, ex: ab/abcdef...
+	name := hex.EncodeToString(id)
+	path := filepath.Join(f.Dir, name[:2], name)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Write to a temporary file first so a failed upload never leave a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), name+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r})
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return filePrefix + filepath.ToSlash(filepath.Join(name[:2], name)), size, nil
+}
+
+func (f FileStore) Open(ctx context.Context, location string) (Blob, error) {
+	path, err := f.path(location)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileBlob{File: file, size: info.Size()}, nil
+}
+
+func (f FileStore) Delete(ctx context.Context, location string) error {
+	path, err := f.path(location)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+// path resolve a location inside Dir, refusing anything that would escape it
+func (f FileStore) path(location string) (string, error) {
+	rel, ok := strings.CutPrefix(location, filePrefix)
+	if !ok || !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", ErrBlobNotFound
+	}
+	return filepath.Join(f.Dir, filepath.FromSlash(rel)), nil
+}
+
+type fileBlob struct {
+	*os.File
+	size int64
+}
+
+func (f *fileBlob) Size() int64 {
+	return f.size
+}
+
+// contextReader stop reading once ctx is done, so an abandoned upload doesn't keep writing
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
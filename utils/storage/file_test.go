@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	location, size, err := store.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !strings.HasPrefix(location, filePrefix) {
+		t.Fatalf("location = %q size = %d, want a file location of %d bytes", location, size, len(data))
+	}
+
+	blob, err := store.Open(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Size() != size {
+		t.Fatalf("blob size = %d, want %d", blob.Size(), size)
+	}
+	got, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read back different bytes")
+	}
+
+	// Streams seek to serve ranges
+	if _, err = blob.Seek(9995, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tail, _ := io.ReadAll(blob)
+	if string(tail) != "56789" {
+		t.Fatalf("tail = %q, want 56789", tail)
+	}
+	blob.Close()
+
+	if err = store.Delete(ctx, location); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Open(ctx, location); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("open deleted: err = %v, want ErrBlobNotFound", err)
+	}
+	if err = store.Delete(ctx, location); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("delete twice: err = %v, want ErrBlobNotFound", err)
+	}
+}
+
+func TestFileStorePutDistinctLocations(t *testing.T) {
+	store := NewFileStore(t.TempDir())
+
+	first, _, err := store.Put(context.Background(), strings.NewReader("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := store.Put(context.Background(), strings.NewReader("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both blobs stored at %q", first)
+	}
+}
+
+func TestFileStoreRefuseLocationsOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "blobs")
+	if err := os.MkdirAll(filepath.Join(dir, "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(root, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStore(dir)
+
+	locations := []string{
+		"file:../secret",
+		"file:ab/../../secret",
+		"file:" + filepath.ToSlash(secret),
+		"file:",
+		"pg-lo:42",
+		"../secret",
+	}
+	for _, location := range locations {
+		if _, err := store.Open(context.Background(), location); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("open %q: err = %v, want ErrBlobNotFound", location, err)
+		}
+		if err := store.Delete(context.Background(), location); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("delete %q: err = %v, want ErrBlobNotFound", location, err)
+		}
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Fatalf("file outside the store was touched: %v", err)
+	}
+}
+
+// failingReader return some bytes then fail, like an upload cut short
+type failingReader struct {
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := min(len(p), f.n)
+	f.n -= n
+	return n, nil
+}
+
+// assertNoFiles check that nothing but directories was left under dir
+func assertNoFiles(t *testing.T, dir string) {
+	t.Helper()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			t.Errorf("left %s behind", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStorePutLeaveNothingOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(dir)
+
+	if _, _, err := store.Put(context.Background(), &failingReader{n: 4096}); err == nil {
+		t.Fatal("failed read was not returned")
+	}
+	assertNoFiles(t, dir)
+
+	// An abandoned upload stop writing once its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := store.Put(ctx, strings.NewReader("data")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	assertNoFiles(t, dir)
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"music-echo/api/repository"
+	"strconv"
+	"strings"
+)
+
+const largeObjectPrefix = "pg-lo:"
+
+// largeObjectChunk is how many bytes are moved per lo_put / lo_get round trip
+const largeObjectChunk = 256 << 10
+
+// LargeObjectStore keep blobs as Postgres large objects, so they're backed up with the database.
+// Put and Delete run within the transaction of the caller when there is one
+type LargeObjectStore struct {
+	Db        *sql.DB
+	TxManager repository.TxManager
+}
+
+func NewLargeObjectStore(db *sql.DB, txManager repository.TxManager) BlobStore {
+	return &LargeObjectStore{Db: db, TxManager: txManager}
+}
+
+func (l LargeObjectStore) Put(ctx context.Context, r io.Reader) (string, int64, error) {
+	var oid, size int64
+	err := l.TxManager.WithinTx(ctx, func(ctx context.Context) error {
+		db := repository.Conn(ctx, l.Db)
+
+		err := db.QueryRowContext(ctx, "SELECT lo_create(0)").Scan(&oid)
+		if err != nil {
+			return err
+		}
+
+		buf := make([]byte, largeObjectChunk)
+		for {
+			n, readErr := io.ReadFull(r, buf)
+			if n > 0 {
+				_, err = db.ExecContext(ctx, "SELECT lo_put($1, $2, $3)", oid, size, buf[:n])
+				if err != nil {
+					return err
+				}
+				size += int64(n)
+			}
+			if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if readErr != nil {
+				return readErr
+			}
+		}
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return largeObjectPrefix + strconv.FormatInt(oid, 10), size, nil
+}
+
+func (l LargeObjectStore) Open(ctx context.Context, location string) (Blob, error) {
+	oid, err := l.oid(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// The size is only reachable through a descriptor, which only live until the end of the
+	// transaction, so it's opened and sought in one statement
+	var size int64
+	err = repository.Conn(ctx, l.Db).QueryRowContext(ctx, "SELECT lo_lseek64(lo_open($1, x'40000'::int), 0, 2)", oid).Scan(&size)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	return &largeObjectBlob{ctx: ctx, db: l.Db, oid: oid, size: size}, nil
+}
+
+func (l LargeObjectStore) Delete(ctx context.Context, location string) error {
+	oid, err := l.oid(location)
+	if err != nil {
+		return err
+	}
+
+	_, err = repository.Conn(ctx, l.Db).ExecContext(ctx, "SELECT lo_unlink($1)", oid)
+	return err
+}
+
+func (l LargeObjectStore) oid(location string) (int64, error) {
+	s, ok := strings.CutPrefix(location, largeObjectPrefix)
+	if !ok {
+		return 0, ErrBlobNotFound
+	}
+	oid, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, ErrBlobNotFound
+	}
+	return oid, nil
+}
+
+// largeObjectBlob read a large object with lo_get, so no transaction is held open while streaming
+type largeObjectBlob struct {
+	ctx    context.Context
+	db     *sql.DB
+	oid    int64
+	size   int64
+	offset int64
+}
+
+func (l *largeObjectBlob) Read(p []byte) (int, error) {
+	if l.offset >= l.size {
+		return 0, io.EOF
+	}
+
+	n := min(len(p), largeObjectChunk)
+	var data []byte
+	err := l.db.QueryRowContext(l.ctx, "SELECT lo_get($1, $2, $3)", l.oid, l.offset, n).Scan(&data)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, io.EOF
+	}
+
+	copy(p, data)
+	l.offset += int64(len(data))
+	return len(data), nil
+}
+
+func (l *largeObjectBlob) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += l.offset
+	case io.SeekEnd:
+		offset += l.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	l.offset = offset
+	return offset, nil
+}
+
+func (l *largeObjectBlob) Size() int64 {
+	return l.size
+}
+
+func (l *largeObjectBlob) Close() error {
+	return nil
+}
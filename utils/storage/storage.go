@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keep media files outside the tracks table, locations are opaque to callers
+type BlobStore interface {
+	// Put store r and return the location to Open it later with its size in bytes
+	Put(ctx context.Context, r io.Reader) (string, int64, error)
+	Open(ctx context.Context, location string) (Blob, error)
+	Delete(ctx context.Context, location string) error
+}
+
+// Blob is a stored file that can be read from any offset
+type Blob interface {
+	io.ReadSeekCloser
+	Size() int64
+}
@@ -0,0 +1,122 @@
+// Package thumbnail render fixed-size square JPEG thumbnails using only the standard library
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// Quality is the JPEG quality of every rendered thumbnail
+const Quality = 85
+
+// MaxPixels cap the decoded size of an upload, checked from the header before decoding
+const MaxPixels = 40_000_000
+
+// Size is a named square thumbnail edge in pixels
+type Size struct {
+	Name string
+	Px   int
+}
+
+// Sizes are the renditions generated for every image, smallest first
+var Sizes = []Size{
+	{Name: "small", Px: 64},
+	{Name: "medium", Px: 256},
+	{Name: "large", Px: 640},
+}
+
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// Thumbnail is one rendered size
+type Thumbnail struct {
+	Size   Size
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Render decode r and produce every size in Sizes, an image smaller than a size is never upscaled
+func Render(r io.ReadSeeker) ([]Thumbnail, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	square := crop(src)
+	var thumbnails []Thumbnail
+	for _, size := range Sizes {
+		px := min(size.Px, square.Dx())
+		dst := resize(src, square, px)
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: Quality})
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", size.Name, err)
+		}
+		thumbnails = append(thumbnails, Thumbnail{Size: size, Width: px, Height: px, Data: buf.Bytes()})
+	}
+
+	return thumbnails, nil
+}
+
+// crop return the centered square of the source bounds
+func crop(src image.Image) image.Rectangle {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// resize scale the square area of src down to px by averaging every source pixel that fall in a target pixel
+func resize(src image.Image, square image.Rectangle, px int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, px, px))
+	side := square.Dx()
+
+	for dy := 0; dy < px; dy++ {
+		y0 := square.Min.Y + dy*side/px
+		y1 := max(square.Min.Y+(dy+1)*side/px, y0+1)
+		for dx := 0; dx < px; dx++ {
+			x0 := square.Min.X + dx*side/px
+			x1 := max(square.Min.X+(dx+1)*side/px, x0+1)
+
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(x, y).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
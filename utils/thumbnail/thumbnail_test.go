@@ -0,0 +1,67 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func pngImage(t *testing.T, width, height int) *bytes.Reader {
+	t.Helper()
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestRender(t *testing.T) {
+	thumbnails, err := Render(pngImage(t, 1000, 800))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != len(Sizes) {
+		t.Fatalf("got %d thumbnails, want %d", len(thumbnails), len(Sizes))
+	}
+
+	for i, thumbnail := range thumbnails {
+		want := Sizes[i].Px
+		config, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != want || config.Height != want {
+			t.Errorf("%s = %dx%d, want %dx%d", thumbnail.Size.Name, config.Width, config.Height, want, want)
+		}
+	}
+}
+
+func TestRenderNoUpscale(t *testing.T) {
+	thumbnails, err := Render(pngImage(t, 100, 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := thumbnails[len(thumbnails)-1]
+	if large.Width != 100 || large.Height != 100 {
+		t.Errorf("large = %dx%d, want 100x100", large.Width, large.Height)
+	}
+}
+
+func TestRenderInvalid(t *testing.T) {
+	_, err := Render(bytes.NewReader([]byte("not an image")))
+	if err == nil {
+		t.Error("expected an error for invalid input")
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
-	"encoding/hex"
-	"fmt"
 	"music-echo/api/domain/dao"
 	"time"
 )
 
-const ScopeActivation = "activation"
+const (
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
+)
 
 func GenerateToken(userId int64, ttl time.Duration, scope string) (*dao.Token, string, error) {
 
@@ -34,9 +35,5 @@ func GenerateToken(userId int64, ttl time.Duration, scope string) (*dao.Token, s
 	hash := sha256.Sum256([]byte(plaintext))
 	token.Hash = hash[:]
 
-	hexString := fmt.Sprintf("%x", token.Hash)
-	hexHash := hex.EncodeToString(token.Hash)
-	fmt.Println(hexHash, hexString)
-
 	return token, plaintext, nil
 }
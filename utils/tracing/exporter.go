@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NoopExporter drop every span, a tracer built on it doesn't even record them
+type NoopExporter struct{}
+
+func (NoopExporter) ExportSpan(SpanData) {}
+
+func (NoopExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// InMemoryExporter keep every span, for tests
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (m *InMemoryExporter) ExportSpan(span SpanData) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.spans = append(m.spans, span)
+}
+
+func (m *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans return the exported spans in the order they ended
+func (m *InMemoryExporter) Spans() []SpanData {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]SpanData(nil), m.spans...)
+}
+
+func (m *InMemoryExporter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.spans = nil
+}
+
+// Tree draw the exported spans as an indented tree of names, children in the order they
+// started, ex: "GET /v1/tracks\n  tracks.GetAll\n"
+func (m *InMemoryExporter) Tree() string {
+	spans := m.Spans()
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	exported := make(map[SpanID]bool, len(spans))
+	children := make(map[SpanID][]SpanData)
+	for _, span := range spans {
+		exported[span.SpanID] = true
+		children[span.ParentSpanID] = append(children[span.ParentSpanID], span)
+	}
+
+	var b strings.Builder
+	var draw func(span SpanData, depth int)
+	draw = func(span SpanData, depth int) {
+		b.WriteString(strings.Repeat("  ", depth) + span.Name + "\n")
+		for _, child := range children[span.SpanID] {
+			draw(child, depth+1)
+		}
+	}
+	// Roots are the spans whose parent wasn't exported, ex: a remote parent
+	for _, span := range spans {
+		if !exported[span.ParentSpanID] {
+			draw(span, 0)
+		}
+	}
+	return b.String()
+}
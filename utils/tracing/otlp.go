@@ -0,0 +1,223 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// otlpBatchSize is how many spans are sent per request
+	otlpBatchSize = 512
+	// otlpMaxQueue bound the spans waiting to be sent, newer spans are dropped past it
+	otlpMaxQueue = 4096
+	// otlpFlushEvery is how long a span wait at most before being sent
+	otlpFlushEvery = 5 * time.Second
+)
+
+// OTLPExporter send spans in batches to an OTLP/HTTP collector, JSON encoded,
+// ex: http://localhost:4318/v1/traces
+type OTLPExporter struct {
+	Endpoint string
+	Service  string
+	Client   *http.Client
+
+	lock    sync.Mutex
+	queue   []SpanData
+	dropped int
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	o := &OTLPExporter{
+		Endpoint: endpoint,
+		Service:  service,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+func (o *OTLPExporter) ExportSpan(span SpanData) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.queue) >= otlpMaxQueue {
+		o.dropped++
+		return
+	}
+	o.queue = append(o.queue, span)
+	if len(o.queue) >= otlpBatchSize {
+		select {
+		case o.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Shutdown stop the exporter and send what is left, until ctx is done
+func (o *OTLPExporter) Shutdown(ctx context.Context) error {
+	close(o.done)
+	select {
+	case <-o.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return o.send(ctx)
+}
+
+func (o *OTLPExporter) run() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(otlpFlushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+		case <-o.flush:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), o.Client.Timeout)
+		err := o.send(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("export spans", "endpoint", o.Endpoint, "error", err)
+		}
+	}
+}
+
+// send post the queued spans, a batch at a time
+func (o *OTLPExporter) send(ctx context.Context) error {
+	for {
+		o.lock.Lock()
+		n := min(len(o.queue), otlpBatchSize)
+		batch := o.queue[:n:n]
+		o.queue = o.queue[n:]
+		dropped := o.dropped
+		o.dropped = 0
+		o.lock.Unlock()
+
+		if dropped > 0 {
+			slog.Warn("spans dropped, export queue full", "dropped", dropped)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		body, err := MarshalOTLP(o.Service, batch)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := o.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("collector answered %s", res.Status)
+		}
+	}
+}
+
+// MarshalOTLP encode the spans as an OTLP ExportTraceServiceRequest in the JSON mapping
+func MarshalOTLP(service string, spans []SpanData) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: 2, Message: span.Error}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	request := map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes([]Attribute{String("service.name", service)}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "music-echo/utils/tracing"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+	return json.Marshal(request)
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// otlpAttributes encode values as AnyValue, int64 are strings in the JSON mapping
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: attr.Key, Value: value})
+	}
+	return encoded
+}
@@ -0,0 +1,294 @@
+// Package tracing record spans around requests, queries and mail sends and hand them to an exporter
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanKind follow the OTLP numbering
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attribute is a key and a string, int64, float64 or bool value
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Float(key string, value float64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanContext identify a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// SpanData is an ended span as handed to the exporter
+type SpanData struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Name         string
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	// Error is the message of the recorded error, "" when the span succeeded
+	Error string
+}
+
+// Exporter receive every ended span, ExportSpan is called inline so it must not block
+type Exporter interface {
+	ExportSpan(span SpanData)
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer start spans and export them when they end
+type Tracer struct {
+	exporter Exporter
+	noop     bool
+	now      func() time.Time
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	_, noop := exporter.(NoopExporter)
+	return &Tracer{
+		exporter: exporter,
+		noop:     noop,
+		now:      time.Now,
+	}
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(NoopExporter{}))
+}
+
+// SetDefault replace the tracer used by Start, the no-op tracer until then
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+// Start a span with the default tracer
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return Default().Start(ctx, name, attrs...)
+}
+
+// Start a span, child of the span in ctx or of the remote parent in ctx. The no-op tracer
+// return a nil span, every Span method accept a nil span
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	if t.noop {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			SpanID:     newSpanID(),
+			Name:       name,
+			Kind:       KindInternal,
+			Start:      t.now(),
+			Attributes: append([]Attribute(nil), attrs...),
+		},
+	}
+	if parent := SpanContextFromContext(ctx); parent.TraceID.IsValid() {
+		span.data.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.data.TraceID = newTraceID()
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Shutdown flush the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exporter.Shutdown(ctx)
+}
+
+type Span struct {
+	tracer *Tracer
+
+	lock  sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) SetKind(kind SpanKind) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Kind = kind
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetRows record how many rows a query returned or affected
+func (s *Span) SetRows(n int) {
+	s.SetAttributes(Int("db.rows", int64(n)))
+}
+
+// RecordError mark the span as failed, a nil error is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+// End the span and export it, only the first call count
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.lock.Unlock()
+
+	s.tracer.exporter.ExportSpan(data)
+}
+
+// SpanContext return the IDs of the span, the zero SpanContext for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithRemoteParent make spans started from ctx children of a span of another process
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// SpanContextFromContext return the current span of ctx, or else its remote parent
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span.SpanContext()
+	}
+	if parent, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return parent
+	}
+	return SpanContext{}
+}
+
+// ParseTraceparent read a W3C traceparent header, ex: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent format the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
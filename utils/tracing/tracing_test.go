@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpanTree(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "GET /v1/tracks")
+	childCtx, child := tracer.Start(ctx, "tracks.GetAll", String("db.operation", "tracks.GetAll"))
+	_, grandchild := tracer.Start(childCtx, "scan")
+	grandchild.End()
+	child.SetRows(3)
+	child.End()
+	_, sibling := tracer.Start(ctx, "likes.CountLikes")
+	sibling.RecordError(errors.New("boom"))
+	sibling.End()
+	root.End()
+
+	want := "GET /v1/tracks\n  tracks.GetAll\n    scan\n  likes.CountLikes\n"
+	if got := exporter.Tree(); got != want {
+		t.Fatalf("tree =\n%s\nwant\n%s", got, want)
+	}
+
+	spans := exporter.Spans()
+	for _, span := range spans {
+		if span.TraceID != root.SpanContext().TraceID {
+			t.Fatalf("%s trace = %s, want %s", span.Name, span.TraceID, root.SpanContext().TraceID)
+		}
+		switch span.Name {
+		case "tracks.GetAll":
+			if last := span.Attributes[len(span.Attributes)-1]; last.Key != "db.rows" || last.Value != int64(3) {
+				t.Fatalf("tracks.GetAll attributes = %v", span.Attributes)
+			}
+		case "likes.CountLikes":
+			if span.Error != "boom" {
+				t.Fatalf("likes.CountLikes error = %q, want boom", span.Error)
+			}
+		}
+	}
+}
+
+func TestSpanEndTwiceExportOnce(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	_, span := NewTracer(exporter).Start(context.Background(), "once")
+	span.End()
+	span.End()
+
+	if n := len(exporter.Spans()); n != 1 {
+		t.Fatalf("exported %d spans, want 1", n)
+	}
+}
+
+func TestNoopTracerReturnNilSpan(t *testing.T) {
+	ctx, span := NewTracer(NoopExporter{}).Start(context.Background(), "noop")
+	if span != nil {
+		t.Fatal("no-op tracer returned a span")
+	}
+	// Every method accept the nil span
+	span.SetAttributes(String("k", "v"))
+	span.SetRows(1)
+	span.RecordError(errors.New("boom"))
+	span.End()
+	if SpanContextFromContext(ctx).TraceID.IsValid() {
+		t.Fatal("no-op tracer put a span in the context")
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok {
+		t.Fatal("valid traceparent rejected")
+	}
+	if got := sc.Traceparent(); got != header {
+		t.Fatalf("Traceparent() = %q, want %q", got, header)
+	}
+
+	exporter := NewInMemoryExporter()
+	ctx := ContextWithRemoteParent(context.Background(), sc)
+	_, span := NewTracer(exporter).Start(ctx, "server")
+	span.End()
+	got := exporter.Spans()[0]
+	if got.TraceID != sc.TraceID || got.ParentSpanID != sc.SpanID {
+		t.Fatalf("span trace=%s parent=%s, want the remote parent", got.TraceID, got.ParentSpanID)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Fatalf("invalid traceparent %q accepted", bad)
+		}
+	}
+}
+
+func TestOTLPExporterSendOnShutdown(t *testing.T) {
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL, "music-echo"))
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child", Int("db.rows", 2), Bool("cached", false))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("collector got %q: %v", body, err)
+	}
+
+	resource := request.ResourceSpans[0]
+	if resource.Resource.Attributes[0].Value["stringValue"] != "music-echo" {
+		t.Fatalf("resource attributes = %v", resource.Resource.Attributes)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child0, parent0 := spans[0], spans[1]
+	if child0.ParentSpanID != parent0.SpanID || child0.TraceID != parent0.TraceID {
+		t.Fatalf("child parent=%s trace=%s, parent span=%s trace=%s", child0.ParentSpanID, child0.TraceID, parent0.SpanID, parent0.TraceID)
+	}
+	if child0.Attributes[0].Value["intValue"] != "2" || child0.Status == nil || child0.Status.Code != 2 {
+		t.Fatalf("child = %+v", child0)
+	}
+	if parent0.ParentSpanID != "" || parent0.Kind != int(KindInternal) {
+		t.Fatalf("parent = %+v", parent0)
+	}
+}
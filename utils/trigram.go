@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// HighlightThreshold is the minimum trigram similarity for a word to be highlighted
+const HighlightThreshold = 0.3
+
+// Trigrams split a word into the padded trigram set used by pg_trgm, ex: "cat" -> "  c", " ca", "cat", "at ",
+// an empty word has none
+func Trigrams(word string) map[string]bool {
+	if word == "" {
+		return map[string]bool{}
+	}
+	var runes = []rune("  " + strings.ToLower(word) + " ")
+	var trigrams = make(map[string]bool)
+	for i := 0; i+3 <= len(runes); i++ {
+		trigrams[string(runes[i:i+3])] = true
+	}
+	return trigrams
+}
+
+// Similarity compare two words by the share of trigrams they have in common, from 0 to 1
+func Similarity(a, b string) float64 {
+	var ta, tb = Trigrams(a), Trigrams(b)
+	var common int
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	var union = len(ta) + len(tb) - common
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
+
+// Highlight wrap the words of text that match a word of q in <em>, the rest of text is HTML escaped
+func Highlight(text, q string) string {
+	var terms = strings.FieldsFunc(strings.ToLower(q), isSeparator)
+	var b strings.Builder
+	var word []rune
+
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := string(word)
+		if matchesAny(w, terms) {
+			b.WriteString("<em>" + html.EscapeString(w) + "</em>")
+		} else {
+			b.WriteString(html.EscapeString(w))
+		}
+		word = word[:0]
+	}
+
+	for _, r := range text {
+		if isSeparator(r) {
+			flush()
+			b.WriteString(html.EscapeString(string(r)))
+			continue
+		}
+		word = append(word, r)
+	}
+	flush()
+
+	return b.String()
+}
+
+func matchesAny(word string, terms []string) bool {
+	var lower = strings.ToLower(word)
+	for _, term := range terms {
+		if strings.HasPrefix(lower, term) || Similarity(lower, term) >= HighlightThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"cat", "cat", 1},
+		{"Cat", "cAT", 1},
+		{"cat", "dog", 0},
+		{"", "", 0},
+		{"cat", "", 0},
+		// "  c", " ca", "cat", "at " against "  c", " ca", "car", "ar "
+		{"cat", "car", 2.0 / 6.0},
+		// Trigrams are made of runes, not bytes
+		{"café", "cafe", 3.0 / 7.0},
+		{"Ünïcode", "üNÏCODE", 1},
+		{"東京", "東京", 1},
+	}
+	for _, test := range tests {
+		got := Similarity(test.a, test.b)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Fatalf("Similarity(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+		if reverse := Similarity(test.b, test.a); reverse != got {
+			t.Fatalf("Similarity(%q, %q) = %v is not symmetric: %v", test.a, test.b, got, reverse)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name, text, q, want string
+	}{
+		{"empty query", "Bohemian Rhapsody", "", "Bohemian Rhapsody"},
+		{"blank query", "Bohemian Rhapsody", "  ,, ", "Bohemian Rhapsody"},
+		{"empty text", "", "queen", ""},
+		{"prefix", "Bohemian Rhapsody", "rhap", "Bohemian <em>Rhapsody</em>"},
+		{"typo", "Bohemian Rhapsody", "bohemain", "<em>Bohemian</em> Rhapsody"},
+		{"no match", "Bohemian Rhapsody", "zzz", "Bohemian Rhapsody"},
+		{"every occurrence", "Love me love me", "love", "<em>Love</em> me <em>love</em> me"},
+		{"overlapping terms", "Rhapsody", "rha rhap rhapsody", "<em>Rhapsody</em>"},
+		{"one term many words", "sing singer singing", "sing", "<em>sing</em> <em>singer</em> <em>singing</em>"},
+		{"unicode", "Café del Mar", "cafe", "<em>Café</em> del Mar"},
+		{"unicode prefix", "Ünïcode Song", "ÜNÏ", "<em>Ünïcode</em> Song"},
+		{"escaped", "Rock & <Roll>", "roll", "Rock &amp; &lt;<em>Roll</em>&gt;"},
+		{"separators kept", "AC/DC - T.N.T.", "dc", "AC/<em>DC</em> - T.N.T."},
+	}
+	for _, test := range tests {
+		got := Highlight(test.text, test.q)
+		if got != test.want {
+			t.Fatalf("%s: Highlight(%q, %q) = %q, want %q", test.name, test.text, test.q, got, test.want)
+		}
+	}
+}